@@ -0,0 +1,299 @@
+// Command protofields generates references to the fields of proto
+// messages for the filters of the store, e.g. PersonFields.Phones.Number
+// for the path phones.number. It reads a descriptor set written by
+//
+//	protoc --include_imports -o model.pb model.proto
+//
+// and writes a Go file for the package of the store:
+//
+//	go run ./cmd/protofields -descriptor_set model.pb -out main/modelFields.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func main() {
+	descriptorSet := flag.String("descriptor_set", "", "file with the FileDescriptorSet of the messages, as written by protoc -o")
+	pkg := flag.String("package", "main", "package of the generated file")
+	out := flag.String("out", "", "file to write, stdout if empty")
+	flag.Parse()
+	if *descriptorSet == "" {
+		log.Fatal("-descriptor_set is required")
+	}
+
+	files, err := readDescriptorSet(*descriptorSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(*pkg, files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readDescriptorSet returns the files of the descriptor set, except for
+// those of the well-known types.
+func readDescriptorSet(path string) ([]protoreflect.FileDescriptor, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("could not read descriptor set %s: %w", path, err)
+	}
+	registry, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve descriptor set %s: %w", path, err)
+	}
+	var files []protoreflect.FileDescriptor
+	registry.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		if fd.Package() != "google.protobuf" {
+			files = append(files, fd)
+		}
+		return true
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
+	return files, nil
+}
+
+// reserved are the names of the members of Field and ListField, which a
+// generated field must not shadow.
+var reserved = map[string]bool{
+	"Field": true, "ListField": true, "Elem": true, "String": true,
+	"Eq": true, "Ne": true, "Gt": true, "Gte": true, "Lt": true, "Lte": true,
+	"In": true, "Exists": true, "ElemMatch": true,
+}
+
+type generator struct {
+	buf bytes.Buffer
+	// done are the messages whose types were generated already
+	done map[protoreflect.FullName]bool
+	// queue are the messages whose types are still to be generated
+	queue []protoreflect.MessageDescriptor
+	// lists are the messages which are the elements of repeated fields
+	lists map[protoreflect.FullName]protoreflect.MessageDescriptor
+}
+
+func generate(pkg string, files []protoreflect.FileDescriptor) ([]byte, error) {
+	g := &generator{
+		done:  map[protoreflect.FullName]bool{},
+		lists: map[protoreflect.FullName]protoreflect.MessageDescriptor{},
+	}
+	g.printf("// Code generated by protofields. DO NOT EDIT.\n\npackage %s\n", pkg)
+
+	for _, file := range files {
+		for _, md := range messages(file.Messages()) {
+			g.printf("\n// %sFields references the fields of %s in filters.\n", goIdent(md), md.FullName())
+			g.printf("var %sFields = new%sFields(\"\")\n", goIdent(md), goIdent(md))
+			g.enqueue(md)
+		}
+	}
+	for len(g.queue) > 0 {
+		md := g.queue[0]
+		g.queue = g.queue[1:]
+		g.message(md)
+	}
+	names := make([]string, 0, len(g.lists))
+	for name := range g.lists {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.list(g.lists[protoreflect.FullName(name)])
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+// messages returns the messages and all messages nested in them, except
+// for the entries of maps.
+func messages(mds protoreflect.MessageDescriptors) []protoreflect.MessageDescriptor {
+	var res []protoreflect.MessageDescriptor
+	for i := 0; i < mds.Len(); i++ {
+		md := mds.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		res = append(res, md)
+		res = append(res, messages(md.Messages())...)
+	}
+	return res
+}
+
+func (g *generator) enqueue(md protoreflect.MessageDescriptor) {
+	if !g.done[md.FullName()] {
+		g.done[md.FullName()] = true
+		g.queue = append(g.queue, md)
+	}
+}
+
+// message generates the struct of the references to the fields of the
+// message and its constructor.
+func (g *generator) message(md protoreflect.MessageDescriptor) {
+	typ := fieldsType(md)
+	names := fieldNames(md)
+	fields := md.Fields()
+
+	g.printf("\ntype %s struct {\n", typ)
+	for i := 0; i < fields.Len(); i++ {
+		g.printf("%s %s\n", names[i], g.refType(md, fields.Get(i)))
+	}
+	g.printf("}\n")
+
+	g.printf("\nfunc new%sFields(prefix string) %s {\n", goIdent(md), typ)
+	g.printf("return %s{\n", typ)
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		path := fmt.Sprintf("prefix+%q", fd.JSONName())
+		switch {
+		case !g.descends(md, fd):
+			g.printf("%s: Field(%s),\n", names[i], path)
+		case fd.IsList():
+			g.printf("%s: %s{ListField: ListField{Field(%s)}, %s: new%sFields(%s+\".\"), Elem: new%sFields(\"\")},\n",
+				names[i], listType(fd.Message()), path, fieldsType(fd.Message()), goIdent(fd.Message()), path, goIdent(fd.Message()))
+		default:
+			g.printf("%s: new%sFields(%s+\".\"),\n", names[i], goIdent(fd.Message()), path)
+		}
+	}
+	g.printf("}\n}\n")
+}
+
+// refType returns the type of the reference to the field.
+func (g *generator) refType(parent protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor) string {
+	if !g.descends(parent, fd) {
+		return "Field"
+	}
+	g.enqueue(fd.Message())
+	if fd.IsList() {
+		g.lists[fd.Message().FullName()] = fd.Message()
+		return listType(fd.Message())
+	}
+	return fieldsType(fd.Message())
+}
+
+// list generates the type of the references to a repeated field of the
+// message.
+func (g *generator) list(md protoreflect.MessageDescriptor) {
+	g.printf("\n// %s references a repeated %s field.\n", listType(md), md.FullName())
+	g.printf("type %s struct {\nListField\n%s\nElem %s\n}\n", listType(md), fieldsType(md), fieldsType(md))
+}
+
+// descends tells whether the reference to the field has references to
+// the fields of its message. Maps, well-known types and recursive
+// messages are referenced as a whole, or the references would never end.
+func (g *generator) descends(parent protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor) bool {
+	if fd.Message() == nil || fd.IsMap() || fd.Message().ParentFile().Package() == "google.protobuf" {
+		return false
+	}
+	return !reaches(fd.Message(), parent.FullName(), map[protoreflect.FullName]bool{})
+}
+
+// reaches tells whether the fields of the message lead to the target
+// message, directly or through other messages.
+func reaches(md protoreflect.MessageDescriptor, target protoreflect.FullName, seen map[protoreflect.FullName]bool) bool {
+	if md.FullName() == target {
+		return true
+	}
+	if seen[md.FullName()] {
+		return false
+	}
+	seen[md.FullName()] = true
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		if fd.Message() != nil && reaches(fd.Message(), target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// goIdent returns the name protoc-gen-go gives the message, e.g.
+// Person_PhoneNumber for a nested message.
+func goIdent(md protoreflect.MessageDescriptor) string {
+	name := goCamelCase(string(md.Name()))
+	if parent, ok := md.Parent().(protoreflect.MessageDescriptor); ok {
+		return goIdent(parent) + "_" + name
+	}
+	return name
+}
+
+func fieldsType(md protoreflect.MessageDescriptor) string {
+	ident := goIdent(md)
+	return strings.ToLower(ident[:1]) + ident[1:] + "Fields"
+}
+
+func listType(md protoreflect.MessageDescriptor) string {
+	ident := goIdent(md)
+	return strings.ToLower(ident[:1]) + ident[1:] + "FieldsList"
+}
+
+// fieldNames returns the names of the references to the fields of the
+// message. They are unique, and neither Go keywords nor the members of
+// Field and ListField.
+func fieldNames(md protoreflect.MessageDescriptor) []string {
+	fields := md.Fields()
+	names := make([]string, fields.Len())
+	used := map[string]bool{}
+	for i := 0; i < fields.Len(); i++ {
+		name := goCamelCase(string(fields.Get(i).Name()))
+		for used[name] || reserved[name] || token.IsKeyword(name) {
+			name += "_"
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// goCamelCase converts a proto name like phone_number into an exported
+// Go name like PhoneNumber.
+func goCamelCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
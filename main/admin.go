@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var errNotConfirmed = errors.New("destructive operation not confirmed, pass WithIUnderstandThisDeletesData")
+
+// Truncate removes all documents of the model within the realm of the
+// user, including soft-deleted ones, and returns how many were removed.
+// It requires WithIUnderstandThisDeletesData.
+func (p *BoundProtoStore) Truncate(model func() protoreflect.ProtoMessage, opts ...DestructiveOption) (_ int64, err error) {
+	p, done := p.operation("Truncate", model)
+	defer done(&err)
+	if !confirmed(opts) {
+		return 0, fmt.Errorf("could not truncate collection %s: %w", model().ProtoReflect().Descriptor().FullName(), errNotConfirmed)
+	}
+	return p.deleteMany(model, nil)
+}
+
+// DropCollection drops the collection of the model within the realm of
+// the user, including its indexes. It requires
+// WithIUnderstandThisDeletesData.
+func (p *BoundProtoStore) DropCollection(model func() protoreflect.ProtoMessage, opts ...DestructiveOption) (err error) {
+	p, done := p.operation("DropCollection", model)
+	defer done(&err)
+	if !confirmed(opts) {
+		return fmt.Errorf("could not drop collection %s: %w", model().ProtoReflect().Descriptor().FullName(), errNotConfirmed)
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	err = coll.Drop(p.ctx)
+	p.invalidateCollection(protoreflect.FullName(coll.Name()))
+	if err != nil {
+		return fmt.Errorf("could not drop collection %s: %w", coll.Name(), err)
+	}
+	// the indexes are gone with the collection
+	p.forgetIndexes(coll)
+	return nil
+}
+
+// ModelInfo describes the data of a model within a realm.
+type ModelInfo struct {
+	// Collection is the name of the collection, which is the full name of
+	// the message for collections written by the store.
+	Collection string
+	// FullName is the full name of the message, parsed from the type
+	// field of a sampled document.
+	FullName protoreflect.FullName
+	// Version is the version of the schema of the sampled document.
+	Version int
+	// Count is the estimated number of documents, including soft-deleted
+	// ones.
+	Count int64
+	// ProtoBacked is false if the sampled document has no valid type
+	// field, so the collection was probably not written by the store.
+	ProtoBacked bool
+}
+
+// ListModels enumerates the collections of the realm of the user, which
+// tells what data exists for a tenant. Collections which do not look like
+// they were written by the store are included, with ProtoBacked false.
+func (p *BoundProtoStore) ListModels() (_ []ModelInfo, err error) {
+	p, done := p.operation("ListModels", nil)
+	defer done(&err)
+	if err := p.ready(); err != nil {
+		return nil, err
+	}
+	db := p.db(p.user.Realm)
+	names, err := db.ListCollectionNames(p.ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list collections of database %s: %w", db.Name(), err)
+	}
+	sort.Strings(names)
+
+	infos := make([]ModelInfo, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		coll := db.Collection(name)
+		info := ModelInfo{Collection: name}
+		if info.Count, err = coll.EstimatedDocumentCount(p.ctx); err != nil {
+			return nil, fmt.Errorf("could not count documents of collection %s: %w", name, err)
+		}
+
+		var sample bson.M
+		opts := options.FindOne().SetProjection(bson.D{bson.E{Key: fieldType, Value: 1}})
+		err := coll.FindOne(p.ctx, bson.D{}, opts).Decode(&sample)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("could not sample a document of collection %s: %w", name, err)
+		}
+		if t, ok := sample[fieldType].(string); ok {
+			if fullName, version, err := parseTypeValue(t); err == nil {
+				info.FullName = fullName
+				info.Version = version
+				info.ProtoBacked = true
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
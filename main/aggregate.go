@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AggregateOption configures Aggregate and AggregateRaw.
+type AggregateOption func(*aggregateConfig)
+
+type aggregateConfig struct {
+	allowDiskUse bool
+}
+
+// AllowDiskUse lets the stages of the pipeline write temporary files when
+// they exceed the memory limit of the server, e.g. large sorts.
+func AllowDiskUse() AggregateOption {
+	return func(c *aggregateConfig) {
+		c.allowDiskUse = true
+	}
+}
+
+// Aggregate runs the pipeline on the collection of the model and decodes
+// the resulting documents into messages of the model, like Filter does.
+// Soft-deleted documents are filtered out before the first stage, unless
+// the query includes them, and so are the documents of other users with
+// MineOnly. Pipelines which have to start with a stage like $geoNear need
+// IncludeDeleted and a $match of their own. Use AggregateRaw for
+// pipelines whose results are no messages of the model, e.g. after a
+// $group.
+func (p *BoundProtoStore) Aggregate(model func() protoreflect.ProtoMessage, pipeline mongo.Pipeline, opts ...AggregateOption) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("Aggregate", model)
+	defer done(&err)
+	docs, err := p.AggregateRaw(model, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]protoreflect.ProtoMessage, 0, len(docs))
+	for _, doc := range docs {
+		m, err := p.protoStore.settings.fromDoc(model, doc)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m.Message)
+	}
+	return res, nil
+}
+
+// AggregateRaw works like Aggregate, but returns the resulting documents
+// as they are.
+func (p *BoundProtoStore) AggregateRaw(model func() protoreflect.ProtoMessage, pipeline mongo.Pipeline, opts ...AggregateOption) (_ []bson.M, err error) {
+	p, done := p.operation("AggregateRaw", model)
+	defer done(&err)
+	cfg := aggregateConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	if scope := p.scope(model().ProtoReflect().Descriptor()); len(scope) > 0 {
+		match := bson.E{Key: "$match", Value: bson.D{bson.E{Key: "$and", Value: scope}}}
+		pipeline = append(mongo.Pipeline{{match}}, pipeline...)
+	}
+	aggregateOpts := options.Aggregate()
+	if cfg.allowDiskUse {
+		aggregateOpts.SetAllowDiskUse(true)
+	}
+	if p.query.collation != nil {
+		aggregateOpts.SetCollation(p.query.collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		aggregateOpts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		aggregateOpts.SetHint(p.query.hint)
+	}
+	aggregateOpts.SetComment(p.comment(model().ProtoReflect().Descriptor()))
+	rows, err := coll.Aggregate(p.ctx, pipeline, aggregateOpts)
+	if err != nil {
+		return nil, fmt.Errorf("could not aggregate collection %s: %w", coll.Name(), err)
+	}
+	var docs []bson.M
+	if err := rows.All(p.ctx, &docs); err != nil {
+		return nil, fmt.Errorf("could not fetch results of aggregating collection %s: %w", coll.Name(), err)
+	}
+	return docs, nil
+}
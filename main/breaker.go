@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// WithCircuitBreaker makes operations fail right away with ErrCircuitOpen
+// once threshold operations in a row failed because the database was
+// unavailable, instead of waiting for the server selection to time out
+// while it is down. After the cooldown, a single operation is let through
+// as a probe. If it succeeds, all operations run again, otherwise the
+// cooldown starts over. The state is shared by all stores bound to the
+// ProtoStore. Like WithRetry, it guards Get, Filter, Count and Store.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(s *settings) {
+		s.breakerThreshold = threshold
+		s.breakerCooldown = cooldown
+	}
+}
+
+// circuitBreaker counts the failures of operations in a row, see
+// WithCircuitBreaker. It is open while they reach the threshold.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// probing is set while the probe after the cooldown runs
+	probing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold < 1 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns an error wrapping ErrCircuitOpen if the operation may not
+// run. Otherwise, probe tells whether it is the probe after the cooldown,
+// which has to be passed on to record. A nil breaker allows all
+// operations.
+func (b *circuitBreaker) allow(operation string) (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return false, nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false, fmt.Errorf("database unavailable, %s not attempted: %w", operation, ErrCircuitOpen)
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record counts the outcome of an operation allowed before. An operation
+// which ran out of the time the store gives it, see WithDefaultTimeout,
+// counts as a failure, unlike one whose caller gave up.
+func (b *circuitBreaker) record(probe bool, err error, callerDone bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if err != nil && (callerDone || errors.Is(err, context.Canceled)) {
+		// the caller gave up, which tells nothing about the database
+		return
+	}
+	if err == nil || !unavailable(err) {
+		// the database answered
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// unavailable tells whether the error means the database could not be
+// reached, rather than that it rejected the operation.
+func unavailable(err error) bool {
+	return isTransient(err) || errors.Is(err, topology.ErrServerSelectionTimeout)
+}
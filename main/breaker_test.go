@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// errUnavailable is an error of an operation which did not reach the
+// database.
+var errUnavailable = fmt.Errorf("could not read: %w", topology.ErrServerSelectionTimeout)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := newCircuitBreaker(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		probe, err := b.allow("get")
+		if err != nil || probe {
+			t.Fatalf("failure %d: allow returned %t, %v while closed", i, probe, err)
+		}
+		b.record(probe, errUnavailable, false)
+	}
+	if _, err := b.allow("get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %v after 3 failures, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerResetsOnAnswer(t *testing.T) {
+	b := newCircuitBreaker(2, time.Hour)
+	for _, err := range []error{errUnavailable, nil, errUnavailable, ErrNotFound, errUnavailable} {
+		b.record(false, err, false)
+	}
+	if _, err := b.allow("get"); err != nil {
+		t.Errorf("failures which were not in a row opened the breaker: %v", err)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := newCircuitBreaker(1, 10*time.Millisecond)
+	b.record(false, errUnavailable, false)
+	time.Sleep(20 * time.Millisecond)
+
+	probe, err := b.allow("get")
+	if err != nil || !probe {
+		t.Fatalf("after the cooldown, allow returned %t, %v, want the probe", probe, err)
+	}
+	if _, err := b.allow("get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("a second operation ran next to the probe: %v", err)
+	}
+	// a failed probe starts the cooldown over
+	b.record(probe, errUnavailable, false)
+	if _, err := b.allow("get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %v right after the failed probe, want ErrCircuitOpen", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	probe, err = b.allow("get")
+	if err != nil || !probe {
+		t.Fatalf("after the second cooldown, allow returned %t, %v, want the probe", probe, err)
+	}
+	b.record(probe, nil, false)
+	for i := 0; i < 3; i++ {
+		if probe, err := b.allow("get"); err != nil || probe {
+			t.Errorf("after the probe succeeded, allow returned %t, %v", probe, err)
+		}
+	}
+}
+
+func TestCircuitBreakerConcurrent(t *testing.T) {
+	b := newCircuitBreaker(10, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probe, err := b.allow("get")
+			if err == nil {
+				b.record(probe, errUnavailable, false)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := b.allow("get"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got %v after a burst of failures, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerSharedByBoundStores(t *testing.T) {
+	store, bound := newOfflineStore(t, context.Background(), WithCircuitBreaker(2, time.Hour))
+	for i := 0; i < 2; i++ {
+		if _, err := bound.Get(person, primitive.NewObjectID().Hex()); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("failure %d: the breaker opened early", i)
+		}
+	}
+	other := store.Bind(context.Background(), &User{ID: uuid.NewV4(), Realm: "other"})
+	start := time.Now()
+	if _, err := other.Count(person); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("another bound store got %v, want ErrCircuitOpen", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("the open breaker waited %v for the database", d)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// duplicateKeyCode is the server error code of a duplicate key.
+const duplicateKeyCode = 11000
+
+// BulkError is returned by the bulk operations if some of the messages
+// could not be written. The others were written nonetheless.
+type BulkError struct {
+	// Errors maps the index of a message in the input to why it was not
+	// written.
+	Errors map[int]error
+}
+
+func (e *BulkError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, i := range errorIndexes(e.Errors) {
+		msgs = append(msgs, fmt.Sprintf("%d: %v", i, e.Errors[i]))
+	}
+	return fmt.Sprintf("%d messages could not be written: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Is tells whether the error of any message is target, so errors.Is finds
+// e.g. ErrWriteConcernTimeout among them.
+func (e *BulkError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the messages, by their index, which matches
+// target, see errors.As.
+func (e *BulkError) As(target interface{}) bool {
+	for _, i := range errorIndexes(e.Errors) {
+		if errors.As(e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorIndexes returns the indexes of the errors in ascending order.
+func errorIndexes(errs map[int]error) []int {
+	indexes := make([]int, 0, len(errs))
+	for i := range errs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// StoreMany stores all messages like Store does, but with a single bulk
+// write per collection. It returns the ids in the order of the messages.
+// If some messages could not be written, a *BulkError tells which, and
+// their ids are empty.
+func (p *BoundProtoStore) StoreMany(messages []protoreflect.ProtoMessage, opts ...StoreOption) (_ []string, err error) {
+	p, done := p.operation("StoreMany", nil)
+	defer done(&err)
+	cfg := newStoreConfig(opts)
+	if err := p.ready(); err != nil {
+		return nil, err
+	}
+
+	bulkErr := &BulkError{Errors: map[int]error{}}
+	// the documents are only needed until the ids are set, so they are
+	// reused afterwards
+	docs := make([]map[string]interface{}, len(messages))
+	defer func() {
+		for _, doc := range docs {
+			if doc != nil {
+				putDoc(doc)
+			}
+		}
+	}()
+
+	// the write models per collection, along with the index of the
+	// message each of them was built from
+	models := map[protoreflect.FullName][]mongo.WriteModel{}
+	indexes := map[protoreflect.FullName][]int{}
+	var tables []protoreflect.FullName
+	for i, message := range messages {
+		doc := getDoc()
+		docs[i] = doc
+		if err := p.documentInto(message, doc); err != nil {
+			bulkErr.Errors[i] = err
+			continue
+		}
+		table := message.ProtoReflect().Descriptor().FullName()
+		filter, update := upsert(message.ProtoReflect().Descriptor(), doc, cfg)
+		model := mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+		if _, ok := models[table]; !ok {
+			tables = append(tables, table)
+		}
+		models[table] = append(models[table], model)
+		indexes[table] = append(indexes[table], i)
+	}
+
+	for _, table := range tables {
+		coll := p.db(p.user.Realm).Collection(string(table), p.collectionOptions())
+		_, err := coll.BulkWrite(p.ctx, models[table], options.BulkWrite().SetOrdered(false))
+		docIDs := make([]interface{}, 0, len(indexes[table]))
+		for _, i := range indexes[table] {
+			docIDs = append(docIDs, docs[i][fieldID])
+		}
+		p.invalidateDocs(table, docIDs...)
+		var bwe mongo.BulkWriteException
+		switch {
+		case err == nil, errors.Is(err, mongo.ErrUnacknowledgedWrite):
+		case errors.As(err, &bwe) && bwe.WriteConcernError == nil:
+			for _, we := range bwe.WriteErrors {
+				i := indexes[table][we.Index]
+				var cause error = we
+				if we.Code == duplicateKeyCode && !cfg.restore && p.isDeleted(coll, bson.D{bson.E{Key: fieldID, Value: docs[i][fieldID]}}) {
+					cause = ErrDeleted
+				}
+				bulkErr.Errors[i] = fmt.Errorf("could not store document %v in collection %s: %w", docs[i][fieldID], table, cause)
+			}
+		default:
+			// the whole batch failed, e.g. because of a network error
+			for _, i := range indexes[table] {
+				bulkErr.Errors[i] = fmt.Errorf("could not store documents in collection %s: %w", table, writeError(err))
+			}
+		}
+	}
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		if _, failed := bulkErr.Errors[i]; failed {
+			continue
+		}
+		id, err := p.protoStore.settings.setID(message, docs[i])
+		if err != nil {
+			bulkErr.Errors[i] = err
+			continue
+		}
+		ids[i] = id
+	}
+	if len(bulkErr.Errors) > 0 {
+		return ids, bulkErr
+	}
+	return ids, nil
+}
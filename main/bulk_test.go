@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestBulkError(t *testing.T) {
+	err := &BulkError{Errors: map[int]error{
+		3: fmt.Errorf("could not store: %w", ErrWriteConcernTimeout),
+		1: fmt.Errorf("could not store: %w", &InvalidIDError{ID: 1}),
+	}}
+	if got, want := err.Error(), "2 messages could not be written: 1: could not store: invalid id 1; 3: could not store: write concern timeout"; got != want {
+		t.Errorf("the message is %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrWriteConcernTimeout) || !errors.Is(err, ErrInvalidID) {
+		t.Error("the error does not wrap the errors of the messages")
+	}
+	if errors.Is(err, ErrDeleted) {
+		t.Error("the error wraps ErrDeleted")
+	}
+	var invalid *InvalidIDError
+	if !errors.As(err, &invalid) || invalid.ID != 1 {
+		t.Errorf("errors.As found %v, want the InvalidIDError of message 1", invalid)
+	}
+}
+
+func TestStoreManyReportsDeleted(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.SoftDelete(person, id); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bound.StoreMany([]protoreflect.ProtoMessage{&Person{Id: id, Name: "Ada Lovelace"}, &Person{Name: "Grace"}})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || len(bulkErr.Errors) != 1 || bulkErr.Errors[0] == nil {
+		t.Fatalf("storing a soft-deleted message returned %v, want a BulkError of message 0", err)
+	}
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("the error %v does not wrap ErrDeleted", err)
+	}
+	if ids[0] != "" || ids[1] == "" {
+		t.Errorf("StoreMany returned the ids %q", ids)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"container/list"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WithCache keeps up to maxEntries documents read by Get and GetMany in
+// memory for up to ttl, evicting the least recently used ones first. The
+// writes of the store drop the documents they change from the cache, but
+// the writes of other processes do not, so a cached document may be stale
+// for up to ttl. Gets with query options, like IncludeDeleted, bypass the
+// cache. Hits and misses are reported to the metrics, see WithMetrics.
+func WithCache(maxEntries int, ttl time.Duration) Option {
+	return func(s *settings) {
+		s.cacheEntries = maxEntries
+		s.cacheTTL = ttl
+	}
+}
+
+// docCache is a LRU cache of messages by realm, collection and id.
+type docCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, the most recently used first
+	lru *list.List
+	// generation counts the invalidations, so a message read before one
+	// is not cached after it
+	generation uint64
+}
+
+type cacheEntry struct {
+	key     string
+	message protoreflect.ProtoMessage
+	expires time.Time
+}
+
+func newDocCache(maxEntries int, ttl time.Duration) *docCache {
+	if maxEntries < 1 || ttl <= 0 {
+		return nil
+	}
+	return &docCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// cacheKey returns the key of the document in the cache. The prefix of a
+// collection ends in the separator, so it matches no other collection.
+func cacheKey(realm string, collection protoreflect.FullName, id string) string {
+	return cachePrefix(realm, collection) + id
+}
+
+func cachePrefix(realm string, collection protoreflect.FullName) string {
+	return realm + "\x00" + string(collection) + "\x00"
+}
+
+// get returns a copy of the cached message, if it is of the type of the
+// model.
+func (c *docCache) get(key string, model protoreflect.ProtoMessage) (protoreflect.ProtoMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	if reflect.TypeOf(entry.message) != reflect.TypeOf(model) {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return proto.Clone(entry.message), true
+}
+
+// currentGeneration returns the generation to pass to put for a message
+// which is about to be read.
+func (c *docCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches a copy of the message, unless the cache was invalidated since
+// the generation, as the message may be outdated then.
+func (c *docCache) put(key string, message protoreflect.ProtoMessage, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, message: proto.Clone(message), expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the documents of the keys.
+func (c *docCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// invalidatePrefix drops the documents whose keys start with the prefix,
+// i.e. those of a collection.
+func (c *docCache) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key, elem := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *docCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// cache returns the cache for the queries of the store, or nil if they
+// bypass it.
+func (p *BoundProtoStore) cache() *docCache {
+	if p.protoStore.cache == nil || !reflect.DeepEqual(p.query, queryConfig{}) {
+		return nil
+	}
+	return p.protoStore.cache
+}
+
+// cachedGet returns the cached message of the document, or reads it with
+// get and caches it.
+func (p *BoundProtoStore) cachedGet(model func() protoreflect.ProtoMessage, id string, get func() (protoreflect.ProtoMessage, error)) (protoreflect.ProtoMessage, error) {
+	cache := p.cache()
+	if cache == nil {
+		return get()
+	}
+	m := model()
+	collection := m.ProtoReflect().Descriptor().FullName()
+	key, ok := p.canonicalKey(collection, id)
+	if !ok {
+		// the invalid id fails in get
+		return get()
+	}
+	if cached, ok := cache.get(key, m); ok {
+		p.protoStore.settings.metrics.CacheHit(string(collection))
+		return cached, nil
+	}
+	p.protoStore.settings.metrics.CacheMiss(string(collection))
+	generation := cache.currentGeneration()
+	res, err := get()
+	if err == nil {
+		cache.put(key, res, generation)
+	}
+	return res, err
+}
+
+// canonicalKey returns the key of the document in the cache, with the id
+// as the codec returns it, so equivalent spellings of an id share it.
+func (p *BoundProtoStore) canonicalKey(collection protoreflect.FullName, id string) (string, bool) {
+	s := &p.protoStore.settings
+	docID, err := s.encodeID(string(collection), id)
+	if err != nil {
+		return "", false
+	}
+	canonical, err := s.decodeID(string(collection), docID)
+	if err != nil {
+		return "", false
+	}
+	return cacheKey(p.user.Realm, collection, canonical), true
+}
+
+// invalidate drops the documents of the ids from the cache, after they
+// were written.
+func (p *BoundProtoStore) invalidate(collection protoreflect.FullName, ids ...string) {
+	if p.protoStore.cache == nil {
+		return
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if key, ok := p.canonicalKey(collection, id); ok {
+			keys = append(keys, key)
+		}
+	}
+	p.protoStore.cache.invalidate(keys...)
+}
+
+// invalidateDocs drops the documents of the _id values from the cache.
+func (p *BoundProtoStore) invalidateDocs(collection protoreflect.FullName, docIDs ...interface{}) {
+	if p.protoStore.cache == nil {
+		return
+	}
+	keys := make([]string, 0, len(docIDs))
+	for _, docID := range docIDs {
+		if id, err := p.protoStore.settings.decodeID(string(collection), docID); err == nil {
+			keys = append(keys, cacheKey(p.user.Realm, collection, id))
+		}
+	}
+	p.protoStore.cache.invalidate(keys...)
+}
+
+// invalidateCollection drops all documents of the collection from the
+// cache, after a write by filter.
+func (p *BoundProtoStore) invalidateCollection(collection protoreflect.FullName) {
+	if p.protoStore.cache == nil {
+		return
+	}
+	p.protoStore.cache.invalidatePrefix(cachePrefix(p.user.Realm, collection))
+}
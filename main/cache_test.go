@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestDocCache(t *testing.T) {
+	if newDocCache(0, time.Hour) != nil || newDocCache(10, 0) != nil {
+		t.Error("created a cache without entries or time to live")
+	}
+	c := newDocCache(2, time.Hour)
+	key := func(id string) string { return cacheKey("realm", "main.Person", id) }
+
+	ada := &Person{Name: "Ada"}
+	c.put(key("a"), ada, c.currentGeneration())
+	ada.Name = "changed"
+	got, ok := c.get(key("a"), &Person{})
+	if !ok || got.(*Person).Name != "Ada" {
+		t.Fatalf("got %v, %t, want the message as it was put", got, ok)
+	}
+	got.(*Person).Name = "changed"
+	if got, _ := c.get(key("a"), &Person{}); got.(*Person).Name != "Ada" {
+		t.Errorf("a copy which was changed changed the cached message to %v", got)
+	}
+	if _, ok := c.get(key("a"), sample()); ok {
+		t.Error("got a person as a sample")
+	}
+	if _, ok := c.get(cacheKey("other", "main.Person", "a"), &Person{}); ok {
+		t.Error("got the message of another realm")
+	}
+
+	// the least recently used entry is evicted
+	c.put(key("b"), &Person{Name: "Bob"}, c.currentGeneration())
+	c.get(key("a"), &Person{})
+	c.put(key("c"), &Person{Name: "Cleo"}, c.currentGeneration())
+	if _, ok := c.get(key("b"), &Person{}); ok {
+		t.Error("b is still cached, although it was used least recently")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := c.get(key(id), &Person{}); !ok {
+			t.Errorf("%s was evicted", id)
+		}
+	}
+
+	// a message read before an invalidation is not cached after it
+	generation := c.currentGeneration()
+	c.invalidate(key("a"))
+	c.put(key("b"), &Person{Name: "Bob"}, generation)
+	if _, ok := c.get(key("b"), &Person{}); ok {
+		t.Error("cached a message read before an invalidation")
+	}
+	if _, ok := c.get(key("a"), &Person{}); ok {
+		t.Error("a is cached after it was invalidated")
+	}
+
+	// the documents of a collection are invalidated together
+	c.put(key("a"), ada, c.currentGeneration())
+	c.put(cacheKey("realm", "main.PersonX", "x"), &Person{}, c.currentGeneration())
+	c.invalidatePrefix(cachePrefix("realm", "main.Person"))
+	if _, ok := c.get(key("a"), &Person{}); ok {
+		t.Error("a is cached after its collection was invalidated")
+	}
+	if _, ok := c.get(cacheKey("realm", "main.PersonX", "x"), &Person{}); !ok {
+		t.Error("invalidating a collection invalidated another one with the same prefix")
+	}
+}
+
+func TestDocCacheTTL(t *testing.T) {
+	c := newDocCache(10, 10*time.Millisecond)
+	key := cacheKey("realm", "main.Person", "a")
+	c.put(key, &Person{Name: "Ada"}, c.currentGeneration())
+	if _, ok := c.get(key, &Person{}); !ok {
+		t.Fatal("the message is not cached")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.get(key, &Person{}); ok {
+		t.Error("the message is cached after its time to live")
+	}
+	if c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Error("the expired entry was not removed")
+	}
+}
+
+// setName changes the name of the person in the database, behind the back
+// of the store and its cache.
+func setName(t *testing.T, bound *BoundProtoStore, id, name string) {
+	t.Helper()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := bound.db(bound.user.Realm).Collection(string(person().ProtoReflect().Descriptor().FullName()))
+	if _, err := coll.UpdateByID(bound.ctx, oid, bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "name", Value: name}}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// personName reads the name of the person through the store and its
+// cache.
+func personName(t *testing.T, bound *BoundProtoStore, id string) string {
+	t.Helper()
+	m, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.(*Person).Name
+}
+
+func TestCache(t *testing.T) {
+	metrics := newRecordingMetrics()
+	_, bound := newTestStore(t, WithCache(100, time.Hour), WithMetrics(metrics))
+	id, _, err := bound.Store(&Person{Name: "Ada", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.(*Person).Name = "changed"
+	setName(t, bound, id, "stale")
+	if got := personName(t, bound, id); got != "Ada" {
+		t.Errorf("got %s, want the cached Ada", got)
+	}
+	if metrics.misses["main.Person"] != 1 || metrics.hits["main.Person"] != 1 {
+		t.Errorf("counted %d misses and %d hits, want one each", metrics.misses["main.Person"], metrics.hits["main.Person"])
+	}
+	// query options bypass the cache
+	found, err := bound.With(IncludeDeleted()).Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := found.(*Person).Name; got != "stale" {
+		t.Errorf("got %s with IncludeDeleted, want stale from the database", got)
+	}
+
+	for _, c := range []struct {
+		name  string
+		write func() error
+	}{
+		{"Store", func() error {
+			_, _, err := bound.Store(&Person{Id: id, Name: "Store"})
+			return err
+		}},
+		{"UpdateFields", func() error {
+			return bound.UpdateFields(&Person{Id: id, Name: "UpdateFields"}, &fieldmaskpb.FieldMask{Paths: []string{"name"}})
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			personName(t, bound, id) // cached
+			if err := c.write(); err != nil {
+				t.Fatal(err)
+			}
+			if got := personName(t, bound, id); got != c.name {
+				t.Errorf("got %s after %s, want %s", got, c.name, c.name)
+			}
+		})
+	}
+
+	t.Run("GetMany", func(t *testing.T) {
+		other, _, err := bound.Store(&Person{Name: "Bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		personName(t, bound, id) // cached
+		setName(t, bound, id, "stale")
+		found, err := bound.GetMany(person, []string{id, other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 2 || found[0].(*Person).Name != "UpdateFields" || found[1].(*Person).Name != "Bob" {
+			t.Errorf("got %v, want the cached person and Bob", found)
+		}
+		// Bob is cached now
+		setName(t, bound, other, "stale")
+		if got := personName(t, bound, other); got != "Bob" {
+			t.Errorf("got %s, want Bob cached by GetMany", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		personName(t, bound, id) // cached
+		if err := bound.Delete(person, id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bound.Get(person, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v after Delete, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestCacheInvalidatedByIncrement(t *testing.T) {
+	_, bound := newTestStore(t, WithCache(100, time.Hour))
+	id, _, err := bound.Store(newSample(t, `{"int64Value": "1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Get(sample, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Increment(sample, id, "int64Value", 2); err != nil {
+		t.Fatal(err)
+	}
+	m, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.ProtoReflect().Get(m.ProtoReflect().Descriptor().Fields().ByName("int64_value")).Int(); got != 3 {
+		t.Errorf("got %d after Increment, want 3", got)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	_, bound := newTestStore(t, WithCache(100, 20*time.Millisecond))
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	personName(t, bound, id) // cached
+	setName(t, bound, id, "Grace")
+	if got := personName(t, bound, id); got != "Ada" {
+		t.Fatalf("got %s, want the cached Ada", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got := personName(t, bound, id); got != "Grace" {
+		t.Errorf("got %s after the time to live, want Grace", got)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	_, bound := newTestStore(t, WithCache(2, time.Hour))
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, _, err := bound.Store(&Person{Name: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		personName(t, bound, id) // cached
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		setName(t, bound, id, "stale")
+	}
+	// the first one was evicted by the third one
+	for i := 2; i >= 0; i-- {
+		want := fmt.Sprint(i)
+		if i == 0 {
+			want = "stale"
+		}
+		if got := personName(t, bound, ids[i]); got != want {
+			t.Errorf("got %s for person %d, want %s", got, i, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Clone stores a copy of the document with the given id as a new
+// document and returns the id of the copy. The copy gets a new id and
+// the current user as its creator. If mutate is not nil, it is applied
+// to the copy before it is stored, e.g. to rename it. If there is no
+// such document, an error wrapping ErrNotFound is returned.
+func (p *BoundProtoStore) Clone(model func() protoreflect.ProtoMessage, id string, mutate func(protoreflect.ProtoMessage)) (_ string, err error) {
+	p, done := p.operation("Clone", model)
+	defer done(&err)
+	clone, err := p.Get(model, id)
+	if err != nil {
+		return "", err
+	}
+	if mutate != nil {
+		mutate(clone)
+	}
+	// the id is cleared after mutate, so the copy can never overwrite the
+	// source or any other document
+	if idField := clone.ProtoReflect().Descriptor().Fields().ByName("id"); idField != nil {
+		clone.ProtoReflect().Clear(idField)
+	}
+	cloneID, _, err := p.Store(clone)
+	return cloneID, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestClone(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := otherUser(bound)
+	cloneID, err := other.Clone(person, id, func(m protoreflect.ProtoMessage) {
+		m.(*Person).Name = "Ada's copy"
+		// the clone never overwrites the source
+		m.(*Person).Id = id
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloneID == id {
+		t.Fatal("the clone has the id of the source")
+	}
+
+	source, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, source, &Person{Id: id, Name: "Ada", Email: "ada@example.com"})
+	clone, err := bound.Get(person, cloneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, clone, &Person{Id: cloneID, Name: "Ada's copy", Email: "ada@example.com"})
+	if n, err := bound.Count(person); err != nil || n != 2 {
+		t.Errorf("stored %d persons: %v", n, err)
+	}
+	sourceDoc, cloneDoc := rawDoc(t, bound, person, id), rawDoc(t, bound, person, cloneID)
+	if reflect.DeepEqual(cloneDoc[fieldCreatedBy], sourceDoc[fieldCreatedBy]) {
+		t.Error("the clone has the creator of the source")
+	}
+
+	if _, err := bound.Clone(person, primitive.NewObjectID().Hex(), nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("cloning a missing document returned %v, want ErrNotFound", err)
+	}
+}
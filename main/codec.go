@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var tProtoMessage = reflect.TypeOf((*protoreflect.ProtoMessage)(nil)).Elem()
+
+// protoCodec encodes proto messages into documents and decodes them back,
+// with the same conversion as the store itself, see WithProtoCodec.
+type protoCodec struct {
+	settings settings
+}
+
+// registry returns the registry of the driver, extended by the codec.
+func (c protoCodec) registry() *bsoncodec.Registry {
+	rb := bson.NewRegistryBuilder()
+	rb.RegisterHookEncoder(tProtoMessage, c)
+	rb.RegisterHookDecoder(tProtoMessage, c)
+	return rb.Build()
+}
+
+// message returns the message held by the value, which is a pointer to a
+// generated message or an addressable message. A nil pointer is allocated
+// if alloc is set.
+func (c protoCodec) message(val reflect.Value, alloc bool) (protoreflect.ProtoMessage, bool) {
+	if val.Kind() != reflect.Ptr {
+		if !val.CanAddr() {
+			return nil, false
+		}
+		val = val.Addr()
+	}
+	if val.IsNil() {
+		if !alloc || !val.CanSet() {
+			return nil, false
+		}
+		val.Set(reflect.New(val.Type().Elem()))
+	}
+	m, ok := val.Interface().(protoreflect.ProtoMessage)
+	return m, ok
+}
+
+func (c protoCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		return vw.WriteNull()
+	}
+	m, ok := c.message(val, false)
+	if !ok {
+		return bsoncodec.ValueEncoderError{Name: "protoCodec.EncodeValue", Types: []reflect.Type{tProtoMessage}, Received: val}
+	}
+	doc := getDoc()
+	defer putDoc(doc)
+	if err := c.settings.toMapInto(m, doc); err != nil {
+		return err
+	}
+	c.settings.toGeoJSON(m.ProtoReflect().Descriptor(), doc)
+	enc, err := ec.LookupEncoder(reflect.TypeOf(doc))
+	if err != nil {
+		return err
+	}
+	return enc.EncodeValue(ec, vw, reflect.ValueOf(doc))
+}
+
+func (c protoCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if vr.Type() == bsontype.Null {
+		if val.Kind() == reflect.Ptr && val.CanSet() {
+			val.Set(reflect.Zero(val.Type()))
+		}
+		return vr.ReadNull()
+	}
+	m, ok := c.message(val, true)
+	if !ok {
+		return bsoncodec.ValueDecoderError{Name: "protoCodec.DecodeValue", Types: []reflect.Type{tProtoMessage}, Received: val}
+	}
+	raw, err := bsonrw.Copier{}.CopyDocumentToBytes(vr)
+	if err != nil {
+		return err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	md := m.ProtoReflect().Descriptor()
+	if docID, ok := doc[fieldID]; ok && md.Fields().ByName("id") != nil {
+		id, err := c.settings.decodeID(string(md.FullName()), docID)
+		if err != nil {
+			return fmt.Errorf("%v: %w", err, ErrDataCorruption)
+		}
+		doc["id"] = id
+	}
+	proto := m.ProtoReflect()
+	proto.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		proto.Clear(fd)
+		return true
+	})
+	return c.settings.fromMap(m, doc)
+}
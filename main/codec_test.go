@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProtoCodec(t *testing.T) {
+	s := newSettings(nil)
+	registry := protoCodec{settings: s}.registry()
+
+	want := newSample(t, `{"stringValue": "a", "int64Value": "9007199254740993", "bytesValue": "AQI=", "at": "2021-01-01T00:00:00Z", "tags": ["x"], "mainItem": {"name": "i"}}`)
+	raw, err := bson.MarshalWithRegistry(registry, bson.D{bson.E{Key: "sample", Value: want}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same document as the store writes
+	var encoded struct{ Sample bson.M }
+	if err := bson.Unmarshal(raw, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.toMap(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := bson.M{}
+	if err := roundTrip(doc, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(encoded.Sample, stored) {
+		t.Errorf("the codec encoded %v, the store %v", encoded.Sample, stored)
+	}
+
+	var decoded struct{ Person *Person }
+	person := &Person{Name: "Ada", Phones: []*Person_PhoneNumber{{Number: "1", Type: Person_WORK}}}
+	raw, err = bson.MarshalWithRegistry(registry, bson.D{bson.E{Key: "person", Value: person}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(decoded.Person, person) {
+		t.Errorf("decoded %v, want %v", decoded.Person, person)
+	}
+
+	// nil messages are null
+	raw, err = bson.MarshalWithRegistry(registry, bson.D{bson.E{Key: "person", Value: (*Person)(nil)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded.Person = person
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Person != nil {
+		t.Errorf("decoded null as %v", decoded.Person)
+	}
+
+	// the id is read from the _id, which has to be one of the id codec
+	oid := primitive.NewObjectID()
+	raw, err = bson.Marshal(bson.M{"person": bson.M{fieldID: oid, "name": "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Person.GetId() != oid.Hex() || decoded.Person.GetName() != "Ada" {
+		t.Errorf("decoded %v, want id %s", decoded.Person, oid.Hex())
+	}
+	raw, err = bson.Marshal(bson.M{"person": bson.M{fieldID: 5, "name": "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); !errors.Is(err, ErrDataCorruption) {
+		t.Errorf("decoding an invalid _id returned %v, want ErrDataCorruption", err)
+	}
+	raw, err = bson.Marshal(bson.M{"person": bson.M{"name": 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.UnmarshalWithRegistry(registry, raw, &decoded); err == nil {
+		t.Error("decoded a number as the name")
+	}
+}
+
+// roundTrip encodes the document and decodes it into out, as the database
+// returns it.
+func roundTrip(doc interface{}, out interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, out)
+}
+
+func TestProtoCodecCompatibility(t *testing.T) {
+	_, bound := newTestStore(t, WithProtoCodec())
+	coll := bound.db(bound.user.Realm).Collection(string(person().ProtoReflect().Descriptor().FullName()))
+
+	// written by the store, read with the codec
+	want := &Person{Name: "Ada", Email: "ada@example.com", Phones: []*Person_PhoneNumber{{Number: "1", Type: Person_HOME}}}
+	id, _, err := bound.Store(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Person{}
+	if err := coll.FindOne(bound.ctx, bson.M{fieldID: oid}).Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("the codec read %v, want %v", got, want)
+	}
+
+	// written with the codec, read by the store
+	update := &Person{Name: "Bob", Email: "bob@example.com", Phones: []*Person_PhoneNumber{{Number: "2", Type: Person_WORK}}}
+	if _, err := coll.UpdateByID(bound.ctx, oid, bson.D{bson.E{Key: "$set", Value: update}}); err != nil {
+		t.Fatal(err)
+	}
+	read, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update.Id = id
+	if !proto.Equal(read, update) {
+		t.Errorf("the store read %v, want %v", read, update)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WithMaxPoolSize sets how many connections to each server the store opens
+// at most. Further operations wait for a connection to be returned. The
+// default of the driver is 100, zero means no limit.
+func WithMaxPoolSize(n uint64) Option {
+	return func(s *settings) {
+		s.maxPoolSize = &n
+	}
+}
+
+// WithMinPoolSize sets how many connections to each server the store keeps
+// open, even if they are idle. The default is zero.
+func WithMinPoolSize(n uint64) Option {
+	return func(s *settings) {
+		s.minPoolSize = &n
+	}
+}
+
+// WithMaxConnIdleTime sets how long a connection may be idle before it is
+// closed. By default, idle connections are kept.
+func WithMaxConnIdleTime(d time.Duration) Option {
+	return func(s *settings) {
+		s.maxConnIdleTime = d
+	}
+}
+
+// WithConnectTimeout sets how long opening a connection may take. The
+// default of the driver is 30 seconds.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(s *settings) {
+		s.connectTimeout = d
+	}
+}
+
+// WithServerSelectionTimeout sets how long an operation waits for a
+// suitable server, e.g. while the primary is elected, before it fails.
+// The default of the driver is 30 seconds.
+func WithServerSelectionTimeout(d time.Duration) Option {
+	return func(s *settings) {
+		s.serverSelectionTimeout = d
+	}
+}
+
+// supportedCompressors are the compressors the driver implements.
+var supportedCompressors = map[string]bool{"snappy": true, "zlib": true, "zstd": true}
+
+// WithCompressors compresses the traffic with the database with the first
+// of the compressors, which may be snappy, zlib and zstd, that the server
+// supports too, e.g. to save on the transfer between data centers. By
+// default, the traffic is not compressed. NewProtoStore fails for other
+// names.
+func WithCompressors(names ...string) Option {
+	return func(s *settings) {
+		s.compressors = names
+	}
+}
+
+// WithMetrics sets where the store reports its measurements to, like the
+// use of the connection pool. By default, they are discarded.
+func WithMetrics(metrics Metrics) Option {
+	return func(s *settings) {
+		s.metrics = metrics
+	}
+}
+
+// applyClientOptions sets the options of the client which the settings
+// configure, leaving the others as the connection string set them.
+func (s *settings) applyClientOptions(opts *options.ClientOptions) error {
+	if len(s.compressors) > 0 {
+		for _, name := range s.compressors {
+			if !supportedCompressors[name] {
+				return fmt.Errorf("unknown compressor %q, it must be snappy, zlib or zstd", name)
+			}
+		}
+		opts.SetCompressors(s.compressors)
+	}
+	if s.maxPoolSize != nil {
+		opts.SetMaxPoolSize(*s.maxPoolSize)
+	}
+	if s.minPoolSize != nil {
+		opts.SetMinPoolSize(*s.minPoolSize)
+	}
+	if s.maxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(s.maxConnIdleTime)
+	}
+	if s.connectTimeout > 0 {
+		opts.SetConnectTimeout(s.connectTimeout)
+	}
+	if s.serverSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(s.serverSelectionTimeout)
+	}
+	if _, ok := s.metrics.(nopMetrics); !ok {
+		opts.SetPoolMonitor(newPoolMonitor(s.metrics))
+	}
+	return nil
+}
+
+// envOptions returns the options configured by the DB_MAX_POOL_SIZE,
+// DB_MIN_POOL_SIZE, DB_MAX_CONN_IDLE_TIME, DB_CONNECT_TIMEOUT,
+// DB_SERVER_SELECTION_TIMEOUT and DB_COMPRESSORS environment variables.
+// The durations are given like 30s or 1m, the compressors separated by
+// commas.
+func envOptions() ([]Option, error) {
+	var opts []Option
+	if value := os.Getenv("DB_COMPRESSORS"); value != "" {
+		names := strings.Split(value, ",")
+		for i := range names {
+			names[i] = strings.TrimSpace(names[i])
+		}
+		opts = append(opts, WithCompressors(names...))
+	}
+	sizes := []struct {
+		name   string
+		option func(uint64) Option
+	}{
+		{"DB_MAX_POOL_SIZE", WithMaxPoolSize},
+		{"DB_MIN_POOL_SIZE", WithMinPoolSize},
+	}
+	for _, size := range sizes {
+		value := os.Getenv(size.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment variable %s: %w", size.name, err)
+		}
+		opts = append(opts, size.option(n))
+	}
+	durations := []struct {
+		name   string
+		option func(time.Duration) Option
+	}{
+		{"DB_MAX_CONN_IDLE_TIME", WithMaxConnIdleTime},
+		{"DB_CONNECT_TIMEOUT", WithConnectTimeout},
+		{"DB_SERVER_SELECTION_TIMEOUT", WithServerSelectionTimeout},
+	}
+	for _, duration := range durations {
+		value := os.Getenv(duration.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment variable %s: %w", duration.name, err)
+		}
+		opts = append(opts, duration.option(d))
+	}
+	return opts, nil
+}
+
+// WithConnectRetry makes NewProtoStore wait until the database answers a
+// ping, e.g. while it still starts up next to the application. It tries
+// up to maxAttempts times, waiting backoff after the first failure and
+// twice as long after each further one, with some jitter. Every failed
+// attempt is logged as a warning, see WithLogger. If the last one fails
+// too, NewProtoStore returns its error.
+func WithConnectRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(s *settings) {
+		s.connectAttempts = maxAttempts
+		s.connectBackoff = backoff
+	}
+}
+
+// WithLazyConnect makes NewProtoStore succeed without waiting for the
+// database, even with WithConnectRetry. Instead, operations ping the
+// database until it answered once, and fail with the error of the ping
+// before.
+func WithLazyConnect() Option {
+	return func(s *settings) {
+		s.lazyConnect = true
+	}
+}
+
+// connect pings the database with the retries of WithConnectRetry.
+func (p *ProtoStore) connect(ctx context.Context) error {
+	backoff := p.settings.connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := p.Ping(ctx)
+		if err == nil {
+			atomic.StoreInt32(p.connected, 1)
+			return nil
+		}
+		if attempt >= p.settings.connectAttempts {
+			return fmt.Errorf("database did not answer after %d attempts: %w", attempt, err)
+		}
+		wait := jitter(backoff)
+		p.settings.logger.Warnf("attempt %d of %d to reach the database failed, retrying in %v: %v", attempt, p.settings.connectAttempts, wait, err)
+		if !sleep(ctx, wait) {
+			return fmt.Errorf("gave up waiting for the database: %w", err)
+		}
+		backoff *= 2
+	}
+}
+
+// ready checks that the store is open and, with WithLazyConnect, that the
+// database answered once. Every operation calls it before it touches the
+// database, mostly through collection.
+func (p *BoundProtoStore) ready() error {
+	if err := p.protoStore.checkOpen(); err != nil {
+		return err
+	}
+	return p.ensureConnected()
+}
+
+// ensureConnected pings the database with WithLazyConnect, until it
+// answered once.
+func (p *BoundProtoStore) ensureConnected() error {
+	if !p.protoStore.settings.lazyConnect || atomic.LoadInt32(p.protoStore.connected) != 0 {
+		return nil
+	}
+	if err := p.protoStore.Ping(p.ctx); err != nil {
+		return err
+	}
+	atomic.StoreInt32(p.protoStore.connected, 1)
+	return nil
+}
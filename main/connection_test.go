@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestLazyConnectWaitsForDatabase(t *testing.T) {
+	for name, op := range map[string]func(*BoundProtoStore) error{
+		"Store": func(p *BoundProtoStore) error {
+			_, _, err := p.Store(&Person{Name: "Ada"})
+			return err
+		},
+		"StoreMany": func(p *BoundProtoStore) error {
+			_, err := p.StoreMany([]protoreflect.ProtoMessage{&Person{Name: "Ada"}})
+			return err
+		},
+		"StoreIdempotent": func(p *BoundProtoStore) error {
+			_, err := p.StoreIdempotent(&Person{Name: "Ada"}, "key")
+			return err
+		},
+		"NextSequence": func(p *BoundProtoStore) error {
+			_, err := p.NextSequence("invoices")
+			return err
+		},
+		"ListModels": func(p *BoundProtoStore) error {
+			_, err := p.ListModels()
+			return err
+		},
+		"Filter": func(p *BoundProtoStore) error {
+			_, err := p.Filter(person)
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store, bound := newTestStore(t, WithConnectRetry(3, time.Millisecond), WithLazyConnect())
+			if atomic.LoadInt32(store.connected) != 0 {
+				t.Fatal("lazy store pinged the database on creation")
+			}
+			// the idempotency keys need a TTL index, which not every
+			// server supports, so only the ping is checked
+			_ = op(bound)
+			if atomic.LoadInt32(store.connected) == 0 {
+				t.Errorf("%s did not wait for the database", name)
+			}
+		})
+	}
+}
+
+func TestLazyConnectFailsOperations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store, err := NewProtoStore(ctx, "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=100&connectTimeoutMS=100",
+		WithConnectRetry(3, time.Millisecond), WithLazyConnect())
+	if err != nil {
+		t.Fatalf("lazy store failed on creation: %v", err)
+	}
+	defer store.Close(ctx)
+	bound := store.Bind(ctx, &User{ID: uuid.NewV4(), Realm: "unreachable"})
+	if _, err := bound.NextSequence("invoices"); err == nil {
+		t.Error("operation succeeded without a database")
+	}
+	if atomic.LoadInt32(store.connected) != 0 {
+		t.Error("failed ping marked the store as connected")
+	}
+}
+
+func TestWithCompressors(t *testing.T) {
+	opts := options.Client()
+	s := newSettings([]Option{WithCompressors("zstd", "snappy")})
+	if err := s.applyClientOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"zstd", "snappy"}; !reflect.DeepEqual(opts.Compressors, want) {
+		t.Errorf("set the compressors %v, want %v", opts.Compressors, want)
+	}
+
+	// without the option, those of the connection string are kept
+	opts, err := clientOptions("mongodb://localhost:27017/?compressors=zlib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newSettings(nil)
+	if err := s.applyClientOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"zlib"}; !reflect.DeepEqual(opts.Compressors, want) {
+		t.Errorf("set the compressors %v, want %v of the connection string", opts.Compressors, want)
+	}
+
+	if _, err := NewProtoStore(context.Background(), offlineURI, WithCompressors("zstd", "gzip")); err == nil || !strings.Contains(err.Error(), `"gzip"`) {
+		t.Errorf("creating a store with the compressor gzip returned %v", err)
+	}
+}
+
+func TestEnvCompressors(t *testing.T) {
+	t.Setenv("DB_COMPRESSORS", " zstd,snappy ")
+	envOpts, err := envOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := newSettings(envOpts); !reflect.DeepEqual(s.compressors, []string{"zstd", "snappy"}) {
+		t.Errorf("DB_COMPRESSORS set the compressors %v", s.compressors)
+	}
+
+	t.Setenv("DB_HOST", "127.0.0.1")
+	t.Setenv("DB_PORT", "1")
+	t.Setenv("DB_COMPRESSORS", "zstd,lz4")
+	if _, err := NewProtoStoreFromEnv(context.Background()); err == nil || !strings.Contains(err.Error(), `"lz4"`) {
+		t.Errorf("creating a store with DB_COMPRESSORS=zstd,lz4 returned %v", err)
+	}
+}
+
+// TestCompressorNegotiated connects like NewProtoStore does and checks the
+// compressors the server agreed to in the handshake of its monitor.
+func TestCompressorNegotiated(t *testing.T) {
+	// the store works with the option, even if the server compresses
+	// nothing
+	_, bound := newTestStore(t, WithCompressors("zlib"))
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Get(person, id); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := clientOptions(testURI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSettings([]Option{WithCompressors("zlib")})
+	if err := s.applyClientOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	negotiated := make(chan []string, 1)
+	opts.SetServerMonitor(&event.ServerMonitor{ServerDescriptionChanged: func(e *event.ServerDescriptionChangedEvent) {
+		if e.NewDescription.Kind != description.Unknown {
+			select {
+			case negotiated <- e.NewDescription.Compression:
+			default:
+			}
+		}
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case compression := <-negotiated:
+		if !reflect.DeepEqual(compression, []string{"zlib"}) {
+			t.Errorf("the server agreed to the compressors %v, want zlib", compression)
+		}
+	case <-ctx.Done():
+		t.Fatal("the server was not described")
+	}
+}
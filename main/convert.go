@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// The documents are produced by protojson, which picks json types for
+// some fields that are a bad fit for the database, e.g. strings for 64
+// bit integers. The converters in this file walk the message descriptor
+// alongside the document and replace those values with native BSON types
+// on the write path and back with what protojson expects on the read
+// path.
+
+// valueConverter converts the value of a single field. For lists and maps,
+// it is called once per element, with the descriptor of the element.
+type valueConverter func(fd protoreflect.FieldDescriptor, value interface{}) (interface{}, error)
+
+// toBSONValues converts the json values of the document, as produced by
+// protojson, into the BSON types stored in the database.
+func toBSONValues(md protoreflect.MessageDescriptor, doc map[string]interface{}) error {
+	return walkMessage(md, doc, toBSONValue)
+}
+
+// fromBSONValues converts the BSON values of a document read from the
+// database back into the json values protojson expects.
+func fromBSONValues(md protoreflect.MessageDescriptor, doc map[string]interface{}) error {
+	return walkMessage(md, doc, fromBSONValue)
+}
+
+func walkMessage(md protoreflect.MessageDescriptor, doc map[string]interface{}, convert valueConverter) error {
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		value, ok := doc[fd.JSONName()]
+		if !ok || value == nil {
+			continue
+		}
+		converted, err := walkField(fd, value, convert)
+		if err != nil {
+			return err
+		}
+		doc[fd.JSONName()] = converted
+	}
+	return nil
+}
+
+func walkField(fd protoreflect.FieldDescriptor, value interface{}, convert valueConverter) (interface{}, error) {
+	switch {
+	case fd.IsMap():
+		entries, ok := asDoc(value)
+		if !ok {
+			return value, nil
+		}
+		for key, entry := range entries {
+			converted, err := walkValue(fd.MapValue(), entry, convert)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = converted
+		}
+		return entries, nil
+	case fd.IsList():
+		elems, ok := asList(value)
+		if !ok {
+			return value, nil
+		}
+		for i, elem := range elems {
+			converted, err := walkValue(fd, elem, convert)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = converted
+		}
+		return elems, nil
+	default:
+		return walkValue(fd, value, convert)
+	}
+}
+
+// walkValue converts a singular value. Nested messages are walked, except
+// for the well-known types, which have their own json representation and
+// are left to the converter.
+func walkValue(fd protoreflect.FieldDescriptor, value interface{}, convert valueConverter) (interface{}, error) {
+	if isMessage(fd) && !isWellKnown(fd.Message()) {
+		if sub, ok := asDoc(value); ok {
+			if err := walkMessage(fd.Message(), sub, convert); err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+	return convert(fd, value)
+}
+
+// orderedFields returns the document with its fields in the order of the
+// message descriptor, and so its nested messages. The driver writes maps
+// in random order, but the server compares embedded documents field by
+// field, so equal messages would not match, e.g. in $pullAll or
+// $addToSet. Keys which are no fields, like the metadata, follow sorted.
+func orderedFields(md protoreflect.MessageDescriptor, doc map[string]interface{}) bson.D {
+	ordered := make(bson.D, 0, len(doc))
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if value, ok := doc[fd.JSONName()]; ok {
+			ordered = append(ordered, bson.E{Key: fd.JSONName(), Value: orderedField(fd, value)})
+		}
+	}
+	var rest []string
+	for key := range doc {
+		if fields.ByJSONName(key) == nil {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		ordered = append(ordered, bson.E{Key: key, Value: doc[key]})
+	}
+	return ordered
+}
+
+// orderedField orders the nested messages of the value of a field like
+// orderedFields. The entries of a map field are sorted by their key.
+func orderedField(fd protoreflect.FieldDescriptor, value interface{}) interface{} {
+	switch {
+	case fd.IsMap():
+		entries, ok := asDoc(value)
+		if !ok {
+			return value
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ordered := make(bson.D, 0, len(entries))
+		for _, key := range keys {
+			ordered = append(ordered, bson.E{Key: key, Value: orderedValue(fd.MapValue(), entries[key])})
+		}
+		return ordered
+	case fd.IsList():
+		elems, ok := asList(value)
+		if !ok || !isMessage(fd) || isWellKnown(fd.Message()) {
+			return value
+		}
+		ordered := make(bson.A, len(elems))
+		for i, elem := range elems {
+			ordered[i] = orderedValue(fd, elem)
+		}
+		return ordered
+	default:
+		return orderedValue(fd, value)
+	}
+}
+
+func orderedValue(fd protoreflect.FieldDescriptor, value interface{}) interface{} {
+	if isMessage(fd) && !isWellKnown(fd.Message()) {
+		if sub, ok := asDoc(value); ok {
+			return orderedFields(fd.Message(), sub)
+		}
+	}
+	return value
+}
+
+func toBSONValue(fd protoreflect.FieldDescriptor, value interface{}) (interface{}, error) {
+	if isTimestamp(fd) {
+		// BSON dates only have a precision of milliseconds, anything below
+		// is lost
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert field %s with value %q to a date: %w", fd.FullName(), s, err)
+		}
+		return primitive.NewDateTimeFromTime(t), nil
+	}
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert field %s with value %q to int64: %w", fd.FullName(), s, err)
+		}
+		return n, nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert field %s with value %q to uint64: %w", fd.FullName(), s, err)
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		// BSON has no unsigned integers, a decimal still compares
+		// correctly with the int64 values
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert field %s with value %q to decimal: %w", fd.FullName(), s, err)
+		}
+		return d, nil
+	case protoreflect.BytesKind:
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert field %s to binary: %w", fd.FullName(), err)
+		}
+		return primitive.Binary{Subtype: bsontype.BinaryGeneric, Data: b}, nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		// protojson encodes non-finite numbers as strings, but BSON
+		// doubles support them natively
+		switch value {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+	}
+	return value, nil
+}
+
+func fromBSONValue(fd protoreflect.FieldDescriptor, value interface{}) (interface{}, error) {
+	if isTimestamp(fd) {
+		if t, ok := value.(primitive.DateTime); ok {
+			return t.Time().UTC().Format(time.RFC3339Nano), nil
+		}
+		return value, nil
+	}
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		switch n := value.(type) {
+		case int64:
+			return strconv.FormatInt(n, 10), nil
+		case int32:
+			return strconv.FormatInt(int64(n), 10), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		switch n := value.(type) {
+		case int64:
+			return strconv.FormatUint(uint64(n), 10), nil
+		case int32:
+			return strconv.FormatUint(uint64(n), 10), nil
+		case primitive.Decimal128:
+			return n.String(), nil
+		}
+	case protoreflect.BytesKind:
+		if b, ok := value.(primitive.Binary); ok {
+			return base64.StdEncoding.EncodeToString(b.Data), nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		// json can not represent non-finite numbers, protojson expects
+		// them as strings
+		if f, ok := value.(float64); ok {
+			if s, ok := nonFinite(f); ok {
+				return s, nil
+			}
+		}
+	}
+	return value, nil
+}
+
+// nonFinite returns the protojson representation of NaN and infinite
+// numbers.
+func nonFinite(f float64) (string, bool) {
+	switch {
+	case math.IsNaN(f):
+		return "NaN", true
+	case math.IsInf(f, 1):
+		return "Infinity", true
+	case math.IsInf(f, -1):
+		return "-Infinity", true
+	}
+	return "", false
+}
+
+func isMessage(fd protoreflect.FieldDescriptor) bool {
+	return fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+}
+
+func isTimestamp(fd protoreflect.FieldDescriptor) bool {
+	return isMessage(fd) && fd.Message().FullName() == "google.protobuf.Timestamp"
+}
+
+// isWellKnown tells whether the message is one of the well-known types
+// like google.protobuf.Timestamp.
+func isWellKnown(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile().Package() == "google.protobuf"
+}
+
+func asDoc(value interface{}) (map[string]interface{}, bool) {
+	switch d := value.(type) {
+	case map[string]interface{}:
+		return d, true
+	case primitive.M:
+		return d, true
+	}
+	return nil, false
+}
+
+func asList(value interface{}) ([]interface{}, bool) {
+	switch l := value.(type) {
+	case []interface{}:
+		return l, true
+	case primitive.A:
+		return l, true
+	}
+	return nil, false
+}
+
+// sanitize converts the BSON values json can not represent, wherever they
+// are in the document: ObjectIds become hex strings, dates RFC3339
+// strings and binaries base64 strings. This covers fields that are not
+// known to the message descriptor, e.g. written by external tools.
+func sanitize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.Binary:
+		return base64.StdEncoding.EncodeToString(v.Data)
+	case primitive.Decimal128:
+		return v.String()
+	case float64:
+		if s, ok := nonFinite(v); ok {
+			return s
+		}
+	case primitive.M:
+		for key, e := range v {
+			v[key] = sanitize(e)
+		}
+	case map[string]interface{}:
+		for key, e := range v {
+			v[key] = sanitize(e)
+		}
+	case primitive.A:
+		for i, e := range v {
+			v[i] = sanitize(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = sanitize(e)
+		}
+	}
+	return value
+}
+
+// checkOneofs verifies that at most one member of every oneof is set in
+// the document, which protojson would reject with a rather cryptic error.
+// Store removes the other members when writing one of them, but legacy
+// documents may still contain several.
+func checkOneofs(md protoreflect.MessageDescriptor, doc map[string]interface{}) error {
+	oneofs := md.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		oneof := oneofs.Get(i)
+		var set []string
+		fields := oneof.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			if value, ok := doc[fields.Get(j).JSONName()]; ok && value != nil {
+				set = append(set, fields.Get(j).JSONName())
+			}
+		}
+		if len(set) > 1 {
+			return fmt.Errorf("the fields %v of oneof %s are set at the same time: %w", set, oneof.FullName(), ErrDataCorruption)
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !isMessage(fd) || fd.IsMap() || isWellKnown(fd.Message()) {
+			continue
+		}
+		values := []interface{}{doc[fd.JSONName()]}
+		if fd.IsList() {
+			values, _ = asList(doc[fd.JSONName()])
+		}
+		for _, value := range values {
+			if sub, ok := asDoc(value); ok {
+				if err := checkOneofs(fd.Message(), sub); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// unresolvedAnys returns the type URLs of all google.protobuf.Any values
+// within the message the resolver does not know.
+func unresolvedAnys(m protoreflect.Message, resolver TypeResolver) []string {
+	if resolver == nil {
+		resolver = protoregistry.GlobalTypes
+	}
+	var urls []string
+	if m.Descriptor().FullName() == "google.protobuf.Any" {
+		url := m.Get(m.Descriptor().Fields().ByName("type_url")).String()
+		if _, err := resolver.FindMessageByURL(url); err != nil {
+			return []string{url}
+		}
+		return nil
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if isMessage(fd.MapValue()) {
+				v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					urls = append(urls, unresolvedAnys(v.Message(), resolver)...)
+					return true
+				})
+			}
+		case fd.IsList():
+			if isMessage(fd) {
+				for i := 0; i < v.List().Len(); i++ {
+					urls = append(urls, unresolvedAnys(v.List().Get(i).Message(), resolver)...)
+				}
+			}
+		case isMessage(fd):
+			urls = append(urls, unresolvedAnys(v.Message(), resolver)...)
+		}
+		return true
+	})
+	return urls
+}
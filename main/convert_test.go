@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// withUnknownAny returns a sample whose Any field packs a type no resolver
+// knows, which protojson can not encode.
+func withUnknownAny(t testing.TB) protoreflect.ProtoMessage {
+	t.Helper()
+	msg := sample()
+	extra := msg.ProtoReflect().Descriptor().Fields().ByName("extra")
+	anyMsg := msg.ProtoReflect().NewField(extra).Message()
+	anyMsg.Set(anyMsg.Descriptor().Fields().ByName("type_url"), protoreflect.ValueOfString("type.googleapis.com/unknown.Type"))
+	anyMsg.Set(anyMsg.Descriptor().Fields().ByName("value"), protoreflect.ValueOfBytes([]byte{0x0a, 0x01, 'x'}))
+	msg.ProtoReflect().Set(extra, protoreflect.ValueOfMessage(anyMsg))
+	return msg
+}
+
+func TestToMapPropagatesErrors(t *testing.T) {
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		doc, err := s.toMap(withUnknownAny(t))
+		if err == nil {
+			t.Errorf("direct=%t encoded an Any of an unknown type into %v", s.directConversion, doc)
+			continue
+		}
+		if !strings.Contains(err.Error(), "type.googleapis.com/unknown.Type") {
+			t.Errorf("direct=%t returned %v, which does not tell the unknown type", s.directConversion, err)
+		}
+	}
+}
+
+func TestStoreEncodeError(t *testing.T) {
+	_, bound := newTestStore(t, WithTypeResolver(sampleTypes))
+	if _, _, err := bound.Store(withUnknownAny(t)); err == nil {
+		t.Fatal("stored an Any of an unknown type")
+	}
+	if stored, err := bound.All(sample); err != nil || len(stored) != 0 {
+		t.Errorf("the message which could not be encoded stored %d documents: %v", len(stored), err)
+	}
+}
+
+func TestInt64AsNumbers(t *testing.T) {
+	outer := newTestMessage(t, "Int64Outer",
+		sampleField{name: "inner", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample"},
+		sampleField{name: "inners", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample", repeated: true},
+	)
+	msg := decodeTestMessage(t, outer(), `{
+		"inner": {"int64Value": "-9223372036854775808", "uint64Value": "18446744073709551615", "numbers": ["1", "-2"]},
+		"inners": [{"sint64Value": "5", "fixed64Value": "6", "sfixed64Value": "-7"}]
+	}`)
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		doc, err := s.toMap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inner := doc["inner"].(map[string]interface{})
+		maxUint64, _ := primitive.ParseDecimal128("18446744073709551615")
+		want := map[string]interface{}{
+			"int64Value":  int64(math.MinInt64),
+			"uint64Value": maxUint64,
+			"numbers":     []interface{}{int64(1), int64(-2)},
+		}
+		for field, value := range want {
+			if !reflect.DeepEqual(inner[field], value) {
+				t.Errorf("direct=%t wrote %s as %#v, want %#v", s.directConversion, field, inner[field], value)
+			}
+		}
+		listed := doc["inners"].([]interface{})[0].(map[string]interface{})
+		for field, value := range map[string]interface{}{"sint64Value": int64(5), "fixed64Value": int64(6), "sfixed64Value": int64(-7)} {
+			if listed[field] != value {
+				t.Errorf("direct=%t wrote %s of a repeated message as %#v, want %#v", s.directConversion, field, listed[field], value)
+			}
+		}
+
+		decoded := outer()
+		if err := s.fromMap(decoded, doc); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, decoded, msg)
+	}
+}
+
+func TestInt64RangeQuery(t *testing.T) {
+	_, bound := newTestStore(t)
+	// as strings, "10" and "9000000000" sort before "9"
+	for _, n := range []string{"9", "10", "9000000000", "-3"} {
+		if _, _, err := bound.Store(newSample(t, `{"int64Value": "`+n+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := bound.With(SortBy("int64Value", true)).Filter(sample, Gt("int64Value", int64(9)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, m := range found {
+		got = append(got, m.ProtoReflect().Get(m.ProtoReflect().Descriptor().Fields().ByName("int64_value")).Int())
+	}
+	if !reflect.DeepEqual(got, []int64{10, 9000000000}) {
+		t.Errorf("int64Value > 9 found %v, want [10 9000000000]", got)
+	}
+}
+
+func TestTimestampsAsDates(t *testing.T) {
+	outer := newTestMessage(t, "TimestampOuter",
+		sampleField{name: "inner", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample"},
+		sampleField{name: "inners", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample", repeated: true},
+	)
+	msg := decodeTestMessage(t, outer(), `{
+		"inner": {"at": "2021-02-03T04:05:06.789Z", "history": ["1970-01-01T00:00:00Z", "2038-01-19T03:14:08Z"]},
+		"inners": [{}, {"at": "1999-12-31T23:59:59Z"}]
+	}`)
+	at := primitive.NewDateTimeFromTime(time.Date(2021, 2, 3, 4, 5, 6, 789e6, time.UTC))
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		doc, err := s.toMap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inner := doc["inner"].(map[string]interface{})
+		if inner["at"] != at {
+			t.Errorf("direct=%t wrote a nested timestamp as %#v, want %#v", s.directConversion, inner["at"], at)
+		}
+		for _, v := range inner["history"].([]interface{}) {
+			if _, ok := v.(primitive.DateTime); !ok {
+				t.Errorf("direct=%t wrote a repeated timestamp as %#v", s.directConversion, v)
+			}
+		}
+		inners := doc["inners"].([]interface{})
+		if _, ok := inners[0].(map[string]interface{})["at"]; ok {
+			t.Errorf("direct=%t wrote an unset timestamp", s.directConversion)
+		}
+		if _, ok := inners[1].(map[string]interface{})["at"].(primitive.DateTime); !ok {
+			t.Errorf("direct=%t wrote a timestamp of a repeated message as %#v", s.directConversion, inners[1])
+		}
+
+		decoded := outer()
+		if err := s.fromMap(decoded, doc); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, decoded, msg)
+	}
+}
+
+func TestTimestampRangeQuery(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, at := range []string{"2020-12-31T23:59:59Z", "2021-01-01T00:00:00Z", "2021-06-15T12:00:00Z", "2022-01-01T00:00:00Z"} {
+		if _, _, err := bound.Store(newSample(t, `{"at": "`+at+`", "stringValue": "`+at+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := bound.Store(newSample(t, `{"stringValue": "never"}`)); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	found, err := bound.With(SortBy("at", true)).Filter(sample, Gte("at", from), Lt("at", to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stringValues(found), []string{"2021-01-01T00:00:00Z", "2021-06-15T12:00:00Z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the range of 2021 found %v, want %v", got, want)
+	}
+}
+
+func TestBytesAsBinary(t *testing.T) {
+	msg := newSample(t, `{"bytesValue": "AAEC/w==", "blobs": ["", "/w=="], "blobMap": {"a": "AAE=", "b": ""}}`)
+	binary := func(b ...byte) primitive.Binary {
+		return primitive.Binary{Subtype: bsontype.BinaryGeneric, Data: append([]byte{}, b...)}
+	}
+	want := map[string]interface{}{
+		"bytesValue": binary(0, 1, 2, 255),
+		"blobs":      []interface{}{binary(), binary(255)},
+		"blobMap":    map[string]interface{}{"a": binary(0, 1), "b": binary()},
+	}
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		doc, err := s.toMap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for field, value := range want {
+			if fmt.Sprintf("%#v", doc[field]) != fmt.Sprintf("%#v", value) {
+				t.Errorf("direct=%t wrote %s as %#v, want %#v", s.directConversion, field, doc[field], value)
+			}
+		}
+	}
+
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", "bytesValue": "AAEC/w==", "blobs": ["", "/w=="], "blobMap": {"a": "AAE=", "b": ""}}`))
+	if _, ok := rawDoc(t, bound, sample, id)["bytesValue"].(primitive.Binary); !ok {
+		t.Error("the bytes are not stored as binary")
+	}
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	json := `{"floatValue": "NaN", "doubleValue": "-Infinity", "scores": ["Infinity", "NaN", 0.5], "location": {"lng": "Infinity", "lat": "-Infinity"}}`
+	msg := newSample(t, json)
+	isNaN := func(v interface{}) bool {
+		f, ok := v.(float64)
+		return ok && math.IsNaN(f)
+	}
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		doc, err := s.toMap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scores := doc["scores"].([]interface{})
+		location := doc["location"].(map[string]interface{})
+		if !isNaN(doc["floatValue"]) || doc["doubleValue"] != math.Inf(-1) {
+			t.Errorf("direct=%t wrote %#v and %#v, want NaN and -Inf", s.directConversion, doc["floatValue"], doc["doubleValue"])
+		}
+		if scores[0] != math.Inf(1) || !isNaN(scores[1]) || scores[2] != 0.5 {
+			t.Errorf("direct=%t wrote the list %#v", s.directConversion, scores)
+		}
+		if location["lng"] != math.Inf(1) || location["lat"] != math.Inf(-1) {
+			t.Errorf("direct=%t wrote the nested message %#v", s.directConversion, location)
+		}
+	}
+
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", `+json[1:]))
+	if doc := rawDoc(t, bound, sample, id); !isNaN(doc["floatValue"]) {
+		t.Errorf("NaN is stored as %#v, want a double", doc["floatValue"])
+	}
+	n, err := bound.Count(sample, Gt("doubleValue", 0.0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("-Infinity is greater than 0 in %d documents", n)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	oid := primitive.NewObjectID()
+	at := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	doc := map[string]interface{}{
+		"ref":  oid,
+		"list": primitive.A{oid, primitive.M{"at": primitive.NewDateTimeFromTime(at)}},
+		"sub":  map[string]interface{}{"blob": primitive.Binary{Data: []byte{0, 255}}, "refs": []interface{}{oid}},
+	}
+	sanitize(doc)
+	want := map[string]interface{}{
+		"ref":  oid.Hex(),
+		"list": primitive.A{oid.Hex(), primitive.M{"at": "2021-02-03T04:05:06Z"}},
+		"sub":  map[string]interface{}{"blob": "AP8=", "refs": []interface{}{oid.Hex()}},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("sanitized into %#v, want %#v", doc, want)
+	}
+}
+
+func TestOrderedFields(t *testing.T) {
+	doc := map[string]interface{}{
+		"phones":    []interface{}{map[string]interface{}{"type": "WORK", "number": "2"}},
+		"name":      "Ada",
+		"updatedAt": int64(2),
+		"_id":       int64(1),
+	}
+	got := orderedFields((&Person{}).ProtoReflect().Descriptor(), doc)
+	want := bson.D{
+		bson.E{Key: "name", Value: "Ada"},
+		bson.E{Key: "phones", Value: bson.A{bson.D{bson.E{Key: "number", Value: "2"}, bson.E{Key: "type", Value: "WORK"}}}},
+		bson.E{Key: "_id", Value: int64(1)},
+		bson.E{Key: "updatedAt", Value: int64(2)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ordered into %v, want %v", got, want)
+	}
+}
+
+func TestReadNestedBSONValues(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, ref := primitive.NewObjectID(), primitive.NewObjectID()
+	at := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	// written by another tool, which stores references as ObjectIds
+	_, err := bound.db(bound.user.Realm).Collection("storetest.Sample").InsertOne(bound.ctx, bson.M{
+		fieldID:       id,
+		fieldType:     "storetest.Sample:1",
+		"stringValue": ref,
+		"tags":        bson.A{ref, primitive.NewDateTimeFromTime(at), primitive.Binary{Data: []byte{1}}},
+		"items":       bson.A{bson.M{"name": ref}},
+		"mainItem":    bson.M{"name": primitive.NewDateTimeFromTime(at)},
+		"itemMap":     bson.M{"k": bson.M{"name": primitive.Binary{Data: []byte{0, 255}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bound.Get(sample, id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{
+		"id": "`+id.Hex()+`",
+		"stringValue": "`+ref.Hex()+`",
+		"tags": ["`+ref.Hex()+`", "2021-02-03T04:05:06Z", "AQ=="],
+		"items": [{"name": "`+ref.Hex()+`"}],
+		"mainItem": {"name": "2021-02-03T04:05:06Z"},
+		"itemMap": {"k": {"name": "AP8="}}
+	}`))
+}
+
+func TestOneofFlips(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"text": "hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		json   string
+		member string
+	}{
+		{`{"number": "5"}`, "number"},
+		{`{"item": {"name": "x"}}`, "item"},
+		{`{"text": "again"}`, "text"},
+	} {
+		if _, _, err := bound.Store(newSample(t, `{"id": "`+id+`", `+c.json[1:])); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bound.Get(sample, id)
+		if err != nil {
+			t.Fatalf("could not read the oneof after setting %s: %v", c.member, err)
+		}
+		assertEqual(t, got, newSample(t, `{"id": "`+id+`", `+c.json[1:]))
+		doc := rawDoc(t, bound, sample, id)
+		for _, member := range []string{"text", "number", "item"} {
+			if _, ok := doc[member]; ok != (member == c.member) {
+				t.Errorf("after setting %s, %s is stored: %t", c.member, member, ok)
+			}
+		}
+	}
+}
+
+func TestOneofLegacyDocument(t *testing.T) {
+	jsonPath, direct := conversions()
+	for _, s := range []settings{jsonPath, direct} {
+		err := s.fromMap(sample(), map[string]interface{}{"text": "hi", "item": map[string]interface{}{"name": "x"}})
+		if !errors.Is(err, ErrDataCorruption) || !strings.Contains(err.Error(), "choice") {
+			t.Errorf("direct=%t read two members of a oneof with %v, want ErrDataCorruption", s.directConversion, err)
+		}
+	}
+}
+
+func TestAnyWithTypeResolver(t *testing.T) {
+	json := `{"extra": {"@type": "type.googleapis.com/storetest.Item", "name": "packed", "quantity": 2}, "items": [{"name": "next to it"}]}`
+	for _, direct := range []bool{false, true} {
+		opts := []Option{WithTypeResolver(sampleTypes)}
+		if direct {
+			opts = append(opts, WithDirectConversion())
+		}
+		_, bound := newTestStore(t, opts...)
+		id, _, err := bound.Store(newSample(t, json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := bound.Get(sample, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, got, newSample(t, `{"id": "`+id+`", `+json[1:]))
+	}
+
+	// the items of storetest are not registered globally
+	_, bound := newTestStore(t)
+	_, _, err := bound.Store(newSample(t, json))
+	if err == nil || !strings.Contains(err.Error(), "type.googleapis.com/storetest.Item") || !strings.Contains(err.Error(), "WithTypeResolver") {
+		t.Errorf("storing an Any without a resolver for its type returned %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Count returns how many documents match the filters. They are combined
+// exactly like in Filter, so the count agrees with what Filter returns.
+func (p *BoundProtoStore) Count(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ int64, err error) {
+	p, done := p.operation("Count", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return 0, err
+	}
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return 0, err
+	}
+	opts := options.Count()
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	var n int64
+	err = p.retry("count", func() error {
+		var err error
+		n, err = coll.CountDocuments(p.ctx, filter, opts)
+		return err
+	})
+	p.traceResults(int(n))
+	if err != nil {
+		return 0, fmt.Errorf("could not count documents of collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+	return n, nil
+}
+
+// EstimatedCount returns the number of documents of the model from the
+// collection metadata. It is fast, but approximate and includes
+// soft-deleted documents.
+func (p *BoundProtoStore) EstimatedCount(model func() protoreflect.ProtoMessage) (_ int64, err error) {
+	p, done := p.operation("EstimatedCount", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return 0, err
+	}
+	n, err := coll.EstimatedDocumentCount(p.ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not estimate the number of documents of collection %s: %w", coll.Name(), err)
+	}
+	return n, nil
+}
+
+// Exists tells whether there is a document with the given id. Unlike Get,
+// only the id is fetched and nothing is decoded, which makes it cheap to
+// validate references.
+func (p *BoundProtoStore) Exists(model func() protoreflect.ProtoMessage, id string) (_ bool, err error) {
+	p, done := p.operation("Exists", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return false, err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return false, err
+	}
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), []bson.D{{bson.E{Key: fieldID, Value: docID}}})
+	if err != nil {
+		return false, err
+	}
+	opts := options.FindOne().
+		SetProjection(bson.D{bson.E{Key: fieldID, Value: 1}}).
+		SetComment(p.comment(model().ProtoReflect().Descriptor()))
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	err = coll.FindOne(p.ctx, filter, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check if document %s exists in collection %s: %w", id, coll.Name(), err)
+	}
+	return true, nil
+}
+
+// ExistsWhere tells whether any document matches the filters, which are
+// combined like in Filter. Like Exists, it stops at the first match and
+// decodes nothing.
+func (p *BoundProtoStore) ExistsWhere(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ bool, err error) {
+	p, done := p.operation("ExistsWhere", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	coll, err := p.collection(model)
+	if err != nil {
+		return false, err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return false, err
+	}
+	opts := options.FindOne().
+		SetProjection(bson.D{bson.E{Key: fieldID, Value: 1}}).
+		SetComment(p.comment(md))
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	err = coll.FindOne(p.ctx, filter, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check if a document of collection %s matches filter %v: %w", coll.Name(), filter, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExists(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bound.Exists(person, id); err != nil || !ok {
+		t.Errorf("Exists of a stored document returned %t, %v", ok, err)
+	}
+	if ok, err := bound.Exists(person, primitive.NewObjectID().Hex()); err != nil || ok {
+		t.Errorf("Exists of a missing document returned %t, %v", ok, err)
+	}
+	if _, err := bound.Exists(person, "not-a-hex"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Exists of an invalid id returned %v, want ErrInvalidID", err)
+	}
+
+	if err := bound.SoftDelete(person, id); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bound.Exists(person, id); err != nil || ok {
+		t.Errorf("Exists of a soft-deleted document returned %t, %v", ok, err)
+	}
+	if ok, err := bound.With(IncludeDeleted()).Exists(person, id); err != nil || !ok {
+		t.Errorf("Exists of a soft-deleted document with IncludeDeleted returned %t, %v", ok, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// cursor is the position FilterAfter continues after, which is the last
+// document of the previous page.
+type cursor struct {
+	Collection string      `bson:"c"`
+	Field      string      `bson:"f,omitempty"`
+	Direction  int         `bson:"d"`
+	Value      interface{} `bson:"v"`
+	ID         interface{} `bson:"i"`
+}
+
+// FilterAfter returns the next page of at most limit documents matching
+// the filters, which are combined like in Filter, along with the token of
+// the page after it. Pass an empty token for the first page. The token is
+// empty once there are no more documents. Unlike pages by offset, no
+// document is skipped or returned twice if documents are inserted while
+// walking the pages.
+//
+// The documents are ordered by id, or by the field of a single SortBy
+// option and the id. Tokens of other models or sort orders are rejected
+// with ErrInvalidCursor, as are tampered ones, see WithCursorSecret.
+func (p *BoundProtoStore) FilterAfter(model func() protoreflect.ProtoMessage, token string, limit int, filters ...bson.D) (_ []protoreflect.ProtoMessage, _ string, err error) {
+	p, done := p.operation("FilterAfter", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	if limit < 1 {
+		return nil, "", fmt.Errorf("invalid limit %d, it must be positive", limit)
+	}
+	if len(p.query.sort) > 1 {
+		return nil, "", fmt.Errorf("could not page through collection %s by more than one sort field", md.FullName())
+	}
+	position := cursor{Collection: string(md.FullName()), Direction: 1}
+	if len(p.query.sort) == 1 {
+		sort, err := sortSpec(md, p.query.sort)
+		if err != nil {
+			return nil, "", err
+		}
+		position.Field = sort[0].Key
+		position.Direction = sort[0].Value.(int)
+		if position.Field == fieldID {
+			position.Field = ""
+		}
+	}
+
+	if token != "" {
+		previous, err := p.protoStore.settings.decodeCursor(token)
+		if err != nil {
+			return nil, "", err
+		}
+		if previous.Collection != position.Collection || previous.Field != position.Field || previous.Direction != position.Direction {
+			return nil, "", fmt.Errorf("token of collection %s sorted by %q, not of collection %s sorted by %q: %w", previous.Collection, previous.Field, position.Collection, position.Field, ErrInvalidCursor)
+		}
+		filters = append(filters[:len(filters):len(filters)], after(previous))
+	}
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, "", err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return nil, "", err
+	}
+	sort := bson.D{bson.E{Key: fieldID, Value: position.Direction}}
+	if position.Field != "" {
+		sort = append(bson.D{bson.E{Key: position.Field, Value: position.Direction}}, sort...)
+	}
+	// the extra document tells whether there is a next page
+	opts := options.Find().SetSort(sort).SetLimit(int64(limit) + 1)
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	opts.SetComment(p.comment(md))
+	rows, err := coll.Find(p.ctx, filter, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+	var docs []bson.M
+	if err := rows.All(p.ctx, &docs); err != nil {
+		return nil, "", fmt.Errorf("could not fetch results of collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+
+	next := ""
+	if len(docs) > limit {
+		docs = docs[:limit]
+		last := docs[limit-1]
+		// taken before decoding, which changes the document
+		position.ID = last[fieldID]
+		if position.Field != "" {
+			position.Value, _ = lookupPath(last, position.Field)
+		}
+		if next, err = p.protoStore.settings.encodeCursor(position); err != nil {
+			return nil, "", err
+		}
+	}
+	res := make([]protoreflect.ProtoMessage, 0, len(docs))
+	for _, doc := range docs {
+		m, err := p.protoStore.settings.fromDoc(model, doc)
+		if err != nil {
+			return nil, "", err
+		}
+		res = append(res, m.Message)
+	}
+	return res, next, nil
+}
+
+// after matches the documents after the position in its order. Documents
+// without the sort field come first in ascending order.
+func after(c cursor) bson.D {
+	op := "$gt"
+	if c.Direction < 0 {
+		op = "$lt"
+	}
+	byID := compare(fieldID, op, c.ID)
+	if c.Field == "" {
+		return byID
+	}
+	sameValue := append(bson.D{bson.E{Key: c.Field, Value: c.Value}}, byID...)
+	switch {
+	case c.Value == nil && c.Direction > 0:
+		return Or(sameValue, compare(c.Field, "$ne", nil))
+	case c.Value == nil:
+		return sameValue
+	case c.Direction > 0:
+		return Or(compare(c.Field, op, c.Value), sameValue)
+	default:
+		// comparisons never match missing fields, which come last
+		return Or(compare(c.Field, op, c.Value), sameValue, bson.D{bson.E{Key: c.Field, Value: nil}})
+	}
+}
+
+func (s *settings) encodeCursor(c cursor) (string, error) {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("could not encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(raw, s.signCursor(raw)...)), nil
+}
+
+func (s *settings) decodeCursor(token string) (cursor, error) {
+	signed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(signed) < sha256.Size {
+		return cursor{}, fmt.Errorf("malformed token: %w", ErrInvalidCursor)
+	}
+	raw, signature := signed[:len(signed)-sha256.Size], signed[len(signed)-sha256.Size:]
+	if !hmac.Equal(signature, s.signCursor(raw)) {
+		return cursor{}, fmt.Errorf("token with invalid signature: %w", ErrInvalidCursor)
+	}
+	var c cursor
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		return cursor{}, fmt.Errorf("%v: %w", err, ErrInvalidCursor)
+	}
+	if c.ID == nil {
+		return cursor{}, fmt.Errorf("token without position: %w", ErrInvalidCursor)
+	}
+	return c, nil
+}
+
+func (s *settings) signCursor(raw []byte) []byte {
+	mac := hmac.New(sha256.New, s.cursorSecret)
+	mac.Write(raw)
+	return mac.Sum(nil)
+}
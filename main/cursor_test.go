@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// walk pages through the samples matching the filters and returns their
+// stringValue in the order of the pages. Before each page but the first,
+// it calls between with the number of pages read.
+func walk(t *testing.T, bound *BoundProtoStore, limit int, between func(pages int), filters ...bson.D) []string {
+	t.Helper()
+	names := []string{}
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 0 && between != nil {
+			between(pages)
+		}
+		found, next, err := bound.FilterAfter(sample, token, limit, filters...)
+		if err != nil {
+			t.Fatalf("could not read page %d: %v", pages, err)
+		}
+		if len(found) > limit {
+			t.Fatalf("page %d has %d documents, more than %d", pages, len(found), limit)
+		}
+		names = append(names, stringValues(found)...)
+		if next == "" {
+			return names
+		}
+		token = next
+	}
+}
+
+func TestFilterAfter(t *testing.T) {
+	_, bound := newTestStore(t)
+	seedSamples(t, bound,
+		`{"stringValue": "a", "int32Value": 3}`,
+		`{"stringValue": "b", "int32Value": 1}`,
+		`{"stringValue": "c"}`,
+		`{"stringValue": "d", "int32Value": 3}`,
+		`{"stringValue": "e", "int32Value": 2}`,
+	)
+
+	t.Run("by id", func(t *testing.T) {
+		for limit := 1; limit <= 6; limit++ {
+			if got, want := walk(t, bound, limit, nil), []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("pages of %d are %v, want %v", limit, got, want)
+			}
+		}
+	})
+
+	// protojson omits zero values, so c has no int32Value: it comes first
+	// in ascending and last in descending order
+	t.Run("by field", func(t *testing.T) {
+		asc := bound.With(SortBy("int32Value", true))
+		if got, want := walk(t, asc, 2, nil), []string{"c", "b", "e", "a", "d"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ascending pages are %v, want %v", got, want)
+		}
+		desc := bound.With(SortBy("int32_value", false))
+		if got, want := walk(t, desc, 2, nil), []string{"d", "a", "e", "b", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("descending pages are %v, want %v", got, want)
+		}
+		if got, want := walk(t, desc, 1, nil, Gt("int32Value", int32(1))), []string{"d", "a", "e"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("filtered pages are %v, want %v", got, want)
+		}
+	})
+
+	t.Run("inserts while walking", func(t *testing.T) {
+		inserted := 0
+		got := walk(t, bound.With(SortBy("int32Value", true)), 2, func(pages int) {
+			// one before and one after the position of the walk
+			for _, n := range []int{0, 5} {
+				inserted++
+				json := fmt.Sprintf(`{"stringValue": "new%d", "int32Value": %d}`, inserted, n)
+				if _, _, err := bound.Store(newSample(t, json)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		seen := map[string]int{}
+		for _, name := range got {
+			seen[name]++
+		}
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			if seen[name] != 1 {
+				t.Errorf("walk returned %s %d times: %v", name, seen[name], got)
+			}
+		}
+		for name, n := range seen {
+			if n > 1 {
+				t.Errorf("walk returned %s %d times: %v", name, n, got)
+			}
+		}
+	})
+}
+
+func TestFilterAfterInvalid(t *testing.T) {
+	_, bound := newTestStore(t)
+	seedSamples(t, bound, `{"stringValue": "a"}`, `{"stringValue": "b"}`)
+	_, token, err := bound.FilterAfter(sample, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("the first of two pages has no token")
+	}
+
+	if _, _, err := bound.FilterAfter(sample, "", 0); err == nil {
+		t.Error("read a page of no documents")
+	}
+	if _, _, err := bound.With(SortBy("int32Value", true), SortBy("stringValue", true)).FilterAfter(sample, "", 1); err == nil {
+		t.Error("paged by two sort fields")
+	}
+	if _, _, err := bound.With(SortBy("nowhere", true)).FilterAfter(sample, "", 1); err == nil {
+		t.Error("paged by an unknown field")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)/2] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(raw)
+	other := newSettings([]Option{WithCursorSecret([]byte("other"))})
+	forged, err := other.encodeCursor(cursor{Collection: "storetest.Sample", Direction: 1, ID: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name  string
+		store *BoundProtoStore
+		token string
+	}{
+		{"malformed", bound, "not base64!"},
+		{"short", bound, "YWJj"},
+		{"tampered", bound, tampered},
+		{"signed by another secret", bound, forged},
+		{"of another sort order", bound.With(SortBy("int32Value", true)), token},
+		{"of another direction", bound.With(SortBy(MetaID, false)), token},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, err := c.store.FilterAfter(sample, c.token, 1); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("reading after the token returned %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+	t.Run("of another model", func(t *testing.T) {
+		if _, _, err := bound.FilterAfter(item, token, 1); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("reading items after the token of samples returned %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestCursorSecret(t *testing.T) {
+	position := cursor{Collection: "storetest.Sample", Direction: -1, Field: "int32Value", Value: int32(3), ID: "x"}
+	shared := []Option{WithCursorSecret([]byte("shared"))}
+	issuer := newSettings(shared)
+	token, err := issuer.encodeCursor(position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another instance with the same secret accepts the token
+	s := newSettings(shared)
+	decoded, err := s.decodeCursor(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Collection != position.Collection || decoded.Field != position.Field || decoded.Direction != position.Direction || decoded.Value != position.Value || decoded.ID != position.ID {
+		t.Errorf("decoded %+v, want %+v", decoded, position)
+	}
+	// random secrets differ per store
+	s = newSettings(nil)
+	if _, err := s.decodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("a store with a random secret accepted the token: %v", err)
+	}
+	// a signed token needs a position
+	token, err = issuer.encodeCursor(cursor{Collection: "storetest.Sample", Direction: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.decodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("decoding a token without position returned %v, want ErrInvalidCursor", err)
+	}
+}
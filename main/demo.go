@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	uuid "github.com/satori/go.uuid"
@@ -26,17 +27,24 @@ func main() {
 		Name: "Tom22",
 	}
 	ctx := context.Background()
-	s := NewProtoStoreFromEnv()
+	s, err := NewProtoStoreFromEnv(ctx)
+	if err != nil {
+		log.Fatalf("could not create store: %v", err)
+	}
+	defer s.Close(ctx)
 	store := s.Bind(ctx, &currentUser)
 
-	id, err := store.Store(&p)
+	id, created, err := store.Store(&p)
 	if err != nil {
 		log.Fatalf("could not insert: %v", err)
 	}
-	log.Printf("inserted new, with id: %s", id)
+	log.Printf("inserted new (%t), with id: %s", created, id)
 
-	persons := store.Filter(person,
+	persons, err := store.Filter(person,
 		Eq("name", "Tom22"))
+	if err != nil {
+		log.Fatalf("could not filter persons: %v", err)
+	}
 
 	for _, person := range persons {
 		if p, ok := person.(*Person); ok {
@@ -48,15 +56,16 @@ func main() {
 	}
 
 	if p, ok := persons[0].(*Person); ok {
-		foundPerson, ok := store.Get(person, p.Id)
-		if ok {
-			log.Printf("Found person by id: %v", foundPerson)
-		} else {
+		foundPerson, err := store.Get(person, p.Id)
+		if errors.Is(err, ErrNotFound) {
 			log.Fatalf("Person not found by id: %s", p.Id)
+		} else if err != nil {
+			log.Fatalf("could not get person %s: %v", p.Id, err)
 		}
+		log.Printf("Found person by id: %v", foundPerson)
 
 		p.Name = "Updated name"
-		id, err := store.Store(p)
+		id, _, err := store.Store(p)
 		if err != nil {
 			log.Fatalf("could not update: %v", err)
 		}
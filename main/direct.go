@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// The direct conversion walks the message with protoreflect instead of
+// encoding it to json and decoding the json into a map, see
+// WithDirectConversion. It produces exactly the documents of the json
+// path, including its choice of types, e.g. doubles for 32 bit integers,
+// so documents written by either path can be read by both. The
+// well-known types, which have a json representation of their own, are
+// still converted by protojson.
+
+// directToMap converts the message into the document like toMapInto
+// does.
+func (s *settings) directToMap(m protoreflect.Message, doc map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		var value interface{}
+		if value, err = s.directField(fd, v); err != nil {
+			return false
+		}
+		name := fd.JSONName()
+		if fd.IsExtension() {
+			name = "[" + string(fd.FullName()) + "]"
+		}
+		doc[name] = value
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (s *settings) directField(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, error) {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		res := make([]interface{}, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			value, err := s.directValue(fd, list.Get(i))
+			if err != nil {
+				return nil, err
+			}
+			res[i] = value
+		}
+		return res, nil
+	case fd.IsMap():
+		res := map[string]interface{}{}
+		var err error
+		v.Map().Range(func(key protoreflect.MapKey, v protoreflect.Value) bool {
+			var value interface{}
+			if value, err = s.directValue(fd.MapValue(), v); err != nil {
+				return false
+			}
+			res[key.String()] = value
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return s.directValue(fd, v)
+}
+
+// directValue converts a singular value like protojson and toBSONValue do.
+func (s *settings) directValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool(), nil
+	case protoreflect.StringKind:
+		return v.String(), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return float64(v.Int()), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return float64(v.Uint()), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int(), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n := v.Uint()
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return primitive.ParseDecimal128(strconv.FormatUint(n, 10))
+	case protoreflect.FloatKind:
+		// protojson writes floats with the precision of 32 bits, which
+		// the json is decoded from
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return f, nil
+		}
+		return strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+	case protoreflect.DoubleKind:
+		return v.Float(), nil
+	case protoreflect.BytesKind:
+		return primitive.Binary{Subtype: bsontype.BinaryGeneric, Data: v.Bytes()}, nil
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return nil, nil
+		}
+		if !s.enumAsNumber {
+			if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+				return string(value.Name()), nil
+			}
+		}
+		return float64(v.Enum()), nil
+	}
+	if isWellKnown(fd.Message()) {
+		return s.wellKnownToValue(fd, v.Message())
+	}
+	return s.directToMap(v.Message(), map[string]interface{}{})
+}
+
+// wellKnownToValue converts a message of a well-known type by its json
+// representation.
+func (s *settings) wellKnownToValue(fd protoreflect.FieldDescriptor, m protoreflect.Message) (interface{}, error) {
+	encoded, err := s.marshalOptions().Marshal(m.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("could not encode field %s: %w", fd.FullName(), err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil, fmt.Errorf("could not decode json of field %s: %w", fd.FullName(), err)
+	}
+	return toBSONValue(fd, value)
+}
+
+// directFromMap sets the fields of the message from the document like the
+// json path of fromDoc does. Keys which are no fields of the message,
+// like the metadata of the store, are skipped.
+func (s *settings) directFromMap(m protoreflect.Message, doc map[string]interface{}) error {
+	fields := m.Descriptor().Fields()
+	for key, value := range doc {
+		fd := fields.ByJSONName(key)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(key))
+		}
+		if fd == nil || (value == nil && !isValue(fd)) {
+			// protojson reads null as unset, except for
+			// google.protobuf.Value, which is a null value then
+			continue
+		}
+		if err := s.directSetField(m, fd, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *settings) directSetField(m protoreflect.Message, fd protoreflect.FieldDescriptor, value interface{}) error {
+	switch {
+	case fd.IsList():
+		elems, ok := asList(value)
+		if !ok {
+			return fmt.Errorf("field %s is no list, but %T", fd.FullName(), value)
+		}
+		list := m.Mutable(fd).List()
+		for _, elem := range elems {
+			v := list.NewElement()
+			ok, err := s.directFromValue(fd, &v, elem)
+			if err != nil {
+				return err
+			}
+			if ok {
+				list.Append(v)
+			}
+		}
+		return nil
+	case fd.IsMap():
+		entries, ok := asDoc(value)
+		if !ok {
+			return fmt.Errorf("field %s is no map, but %T", fd.FullName(), value)
+		}
+		mp := m.Mutable(fd).Map()
+		for k, entry := range entries {
+			key, ok, err := directScalar(fd.MapKey(), k)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("invalid key %q of map %s", k, fd.FullName())
+			}
+			v := mp.NewValue()
+			if ok, err = s.directFromValue(fd.MapValue(), &v, entry); err != nil {
+				return err
+			}
+			if ok {
+				mp.Set(key.MapKey(), v)
+			}
+		}
+		return nil
+	}
+	v := m.NewField(fd)
+	ok, err := s.directFromValue(fd, &v, value)
+	if err != nil {
+		return err
+	}
+	if ok {
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+// directFromValue converts a singular value of the document into the
+// value of the field. Messages are decoded into v, which holds a new
+// message of the type of the field, scalars replace it. It tells false
+// for values to skip, like unknown enum names, which protojson discards
+// as well.
+func (s *settings) directFromValue(fd protoreflect.FieldDescriptor, v *protoreflect.Value, value interface{}) (bool, error) {
+	if !isMessage(fd) {
+		// values written by other tools, e.g. ObjectIds for strings, are
+		// converted like on the json path
+		scalar, ok, err := directScalar(fd, sanitize(value))
+		*v = scalar
+		return ok, err
+	}
+	if isWellKnown(fd.Message()) {
+		return true, s.wellKnownFromValue(fd, v.Message(), value)
+	}
+	sub, ok := asDoc(value)
+	if !ok {
+		return false, fmt.Errorf("field %s is no message, but %T", fd.FullName(), value)
+	}
+	return true, s.directFromMap(v.Message(), sub)
+}
+
+// wellKnownFromValue decodes the value of a field of a well-known type
+// into the message by its json representation.
+func (s *settings) wellKnownFromValue(fd protoreflect.FieldDescriptor, m protoreflect.Message, value interface{}) error {
+	value, err := fromBSONValue(fd, value)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(sanitize(value))
+	if err != nil {
+		return fmt.Errorf("could not reencode field %s as json: %w", fd.FullName(), err)
+	}
+	if err := s.unmarshalOptions().Unmarshal(encoded, m.Interface()); err != nil {
+		return fmt.Errorf("could not read field %s: %w", fd.FullName(), err)
+	}
+	return nil
+}
+
+func isValue(fd protoreflect.FieldDescriptor) bool {
+	return isMessage(fd) && fd.Message().FullName() == "google.protobuf.Value"
+}
+
+// directScalar converts a scalar value of the document into the value of
+// the field, like protojson does with its json representation.
+func directScalar(fd protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, bool, error) {
+	invalid := func(err error) (protoreflect.Value, bool, error) {
+		return protoreflect.Value{}, false, fmt.Errorf("invalid value %v for field %s: %w", value, fd.FullName(), err)
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		switch b := value.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(b), true, nil
+		case string: // the keys of maps
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return invalid(err)
+			}
+			return protoreflect.ValueOfBool(parsed), true, nil
+		}
+	case protoreflect.StringKind:
+		if str, ok := value.(string); ok {
+			return protoreflect.ValueOfString(str), true, nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := directInt(value, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt32(int32(n)), true, nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := directInt(value, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt64(n), true, nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := directUint(value, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint32(uint32(n)), true, nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := directUint(value, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint64(n), true, nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f, err := directFloat(value)
+		if err != nil {
+			return invalid(err)
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f)), true, nil
+		}
+		return protoreflect.ValueOfFloat64(f), true, nil
+	case protoreflect.BytesKind:
+		switch b := value.(type) {
+		case primitive.Binary:
+			return protoreflect.ValueOfBytes(b.Data), true, nil
+		case string:
+			data, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				data, err = base64.URLEncoding.DecodeString(b)
+			}
+			if err != nil {
+				return invalid(err)
+			}
+			return protoreflect.ValueOfBytes(data), true, nil
+		}
+	case protoreflect.EnumKind:
+		if name, ok := value.(string); ok {
+			enumValue := fd.Enum().Values().ByName(protoreflect.Name(name))
+			if enumValue == nil {
+				// protojson rejects unknown names, also when it discards
+				// unknown fields
+				return invalid(fmt.Errorf("unknown value of enum %s", fd.Enum().FullName()))
+			}
+			return protoreflect.ValueOfEnum(enumValue.Number()), true, nil
+		}
+		n, err := directInt(value, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), true, nil
+	}
+	return invalid(fmt.Errorf("unexpected type %T", value))
+}
+
+// directInt converts a number of the document, or the string protojson
+// writes for 64 bit integers, into an integer of the size.
+func directInt(value interface{}, bits int) (int64, error) {
+	switch n := value.(type) {
+	case int32:
+		return int64(n), nil
+	case int64:
+		if bits == 32 && (n < math.MinInt32 || n > math.MaxInt32) {
+			return 0, fmt.Errorf("%d overflows int32", n)
+		}
+		return n, nil
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("%v is no integer", n)
+		}
+		return strconv.ParseInt(strconv.FormatFloat(n, 'f', -1, 64), 10, bits)
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(n), 10, bits)
+	}
+	return 0, fmt.Errorf("unexpected type %T", value)
+}
+
+// directUint works like directInt for unsigned integers, which are
+// stored as decimals above the range of int64.
+func directUint(value interface{}, bits int) (uint64, error) {
+	switch n := value.(type) {
+	case int32:
+		return strconv.ParseUint(strconv.FormatInt(int64(n), 10), 10, bits)
+	case int64:
+		return strconv.ParseUint(strconv.FormatInt(n, 10), 10, bits)
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("%v is no integer", n)
+		}
+		return strconv.ParseUint(strconv.FormatFloat(n, 'f', -1, 64), 10, bits)
+	case primitive.Decimal128:
+		return strconv.ParseUint(n.String(), 10, bits)
+	case string:
+		return strconv.ParseUint(strings.TrimSpace(n), 10, bits)
+	}
+	return 0, fmt.Errorf("unexpected type %T", value)
+}
+
+// directFloat converts a number of the document into a float, including
+// the strings protojson writes for non-finite numbers.
+func directFloat(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		switch n {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T", value)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// conversions are the settings of the json and the direct conversion.
+func conversions(opts ...Option) (jsonPath, direct settings) {
+	opts = append(opts, WithTypeResolver(sampleTypes))
+	return newSettings(opts), newSettings(append(opts, WithDirectConversion()))
+}
+
+var conversionCases = []struct {
+	name string
+	json string
+}{
+	{"empty", `{}`},
+	{"signed", `{"int32Value": -2147483648, "int64Value": "9223372036854775807", "sint32Value": -1, "sint64Value": "-9223372036854775808", "sfixed32Value": 2147483647, "sfixed64Value": "-42"}`},
+	{"unsigned", `{"uint32Value": 4294967295, "uint64Value": "18446744073709551615", "fixed32Value": 7, "fixed64Value": "9223372036854775807"}`},
+	{"floats", `{"floatValue": 1.1, "doubleValue": 0.1}`},
+	{"float limits", `{"floatValue": 3.4028235e+38, "doubleValue": 5e-324}`},
+	{"nan", `{"floatValue": "NaN", "doubleValue": "NaN"}`},
+	{"infinity", `{"floatValue": "-Infinity", "doubleValue": "Infinity"}`},
+	{"non-finite lists", `{"scores": ["NaN", "Infinity", "-Infinity", 1.5]}`},
+	{"bool string bytes", `{"boolValue": true, "stringValue": "grüß 🌍", "bytesValue": "AAEC/w=="}`},
+	{"bytes lists and maps", `{"blobs": ["", "AAEC/w=="], "blobMap": {"a": "/w==", "": ""}}`},
+	{"enum", `{"status": "CLOSED", "statuses": ["ACTIVE", "STATUS_UNKNOWN"]}`},
+	{"enum without name", `{"status": 7}`},
+	{"lists", `{"tags": ["a", ""], "numbers": ["1", "-2"], "items": [{"name": "x"}, {}]}`},
+	{"maps", `{"counts": {"a": 1, "": -1}, "labels": {"-3": "minus", "0": "zero"}, "itemMap": {"k": {"name": "v", "quantity": 2}}}`},
+	{"oneof text", `{"text": "hi"}`},
+	{"oneof number", `{"number": "-5"}`},
+	{"oneof item", `{"item": {"quantity": 3}}`},
+	{"nested", `{"mainItem": {"name": "main"}, "location": {"lng": 13.4, "lat": 52.5}}`},
+	{"timestamps", `{"at": "2021-02-03T04:05:06.789Z", "history": ["1970-01-01T00:00:00Z", "2038-01-19T03:14:08Z"]}`},
+	{"duration wrapper", `{"wait": "-1.5s", "wrapped": "0"}`},
+	{"any", `{"extra": {"@type": "type.googleapis.com/storetest.Item", "name": "packed"}}`},
+	{"struct", `{"meta": {"null": null, "number": 1.5, "list": [true, "x", {"nested": {}}]}}`},
+}
+
+func TestDirectConversionMatchesJSON(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithEnumAsNumber()}} {
+		jsonPath, direct := conversions(opts...)
+		for _, c := range conversionCases {
+			t.Run(fmt.Sprintf("%s enumAsNumber=%t", c.name, jsonPath.enumAsNumber), func(t *testing.T) {
+				msg := newSample(t, c.json)
+				want, err := jsonPath.toMap(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := direct.toMap(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// %#v tells the types apart, sorts maps and prints NaN
+				// alike, unlike reflect.DeepEqual
+				if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", want) {
+					t.Errorf("direct conversion wrote\n%#v\nwant\n%#v", got, want)
+				}
+
+				// either path reads the documents of both
+				for _, read := range []settings{jsonPath, direct} {
+					for _, write := range []settings{jsonPath, direct} {
+						doc, err := write.toMap(msg)
+						if err != nil {
+							t.Fatal(err)
+						}
+						decoded := sample()
+						if err := read.fromMap(decoded, doc); err != nil {
+							t.Fatalf("direct=%t could not read the document of direct=%t: %v", read.directConversion, write.directConversion, err)
+						}
+						assertEqual(t, decoded, msg)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	jsonPath, direct := conversions()
+	for _, c := range []struct {
+		name string
+		doc  map[string]interface{}
+	}{
+		{"int32 overflow", map[string]interface{}{"int32Value": int64(1) << 40}},
+		{"fraction", map[string]interface{}{"int64Value": 1.5}},
+		{"number for string", map[string]interface{}{"stringValue": int32(1)}},
+		{"unknown enum name", map[string]interface{}{"status": "OPEN"}},
+		{"scalar for message", map[string]interface{}{"mainItem": "x"}},
+	} {
+		for _, s := range []settings{jsonPath, direct} {
+			if err := s.fromMap(sample(), c.doc); err == nil {
+				t.Errorf("%s: direct=%t read %v without an error", c.name, s.directConversion, c.doc)
+			}
+		}
+	}
+}
+
+// benchmarkMessages are the messages the conversions are benchmarked with.
+func benchmarkMessages(b *testing.B) map[string]protoreflect.ProtoMessage {
+	b.Helper()
+	node := newTestMessage(b, "Node",
+		sampleField{name: "name", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING},
+		sampleField{name: "child", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Node"},
+	)
+	deep := decodeTestMessage(b, node(), strings.Repeat(`{"name": "level", "child": `, 50)+`{}`+strings.Repeat(`}`, 50))
+
+	numbers := make([]string, 1000)
+	for i := range numbers {
+		numbers[i] = fmt.Sprintf(`"%d"`, i*i)
+	}
+	return map[string]protoreflect.ProtoMessage{
+		"small":    newSample(b, `{"stringValue": "small", "int32Value": 1, "status": "ACTIVE", "mainItem": {"name": "x"}}`),
+		"repeated": newSample(b, `{"numbers": [`+strings.Join(numbers, ",")+`]}`),
+		"nested":   deep,
+	}
+}
+
+func BenchmarkConversion(b *testing.B) {
+	jsonPath, direct := conversions()
+	for name, msg := range benchmarkMessages(b) {
+		for _, s := range []settings{jsonPath, direct} {
+			s, msg := s, msg
+			path := "json"
+			if s.directConversion {
+				path = "direct"
+			}
+			b.Run(fmt.Sprintf("toMap/%s/%s", name, path), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := s.toMap(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("fromMap/%s/%s", name, path), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					// fromMap changes the document, so every run reads a
+					// new one
+					b.StopTimer()
+					doc, err := s.toMap(msg)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+					if err := s.fromMap(msg.ProtoReflect().New().Interface(), doc); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Distinct returns the distinct values of the field among the documents
+// matching the filters, which are combined like in Filter. The field may
+// be a nested path like address.city. The values are returned as
+// protojson represents them, e.g. 64 bit integers and dates as strings.
+//
+// Repeated fields are unwound: the distinct values of a repeated string
+// field are the individual strings, not the distinct lists. This also
+// holds for paths through repeated messages, e.g. phones.type returns the
+// types of all phones of all matching documents.
+func (p *BoundProtoStore) Distinct(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ []interface{}, err error) {
+	p, done := p.operation("Distinct", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, field)
+	if err != nil {
+		return nil, err
+	}
+	fd := fields[len(fields)-1]
+	path := jsonPath(fields)
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Distinct()
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	values, err := coll.Distinct(p.ctx, path, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not get distinct values of field %s in collection %s with filter %v: %w", path, coll.Name(), filter, err)
+	}
+	// the server unwinds the arrays along the path, so the values are
+	// single elements of a repeated field
+	for i, value := range values {
+		if value, err = walkValue(fd, value, fromBSONValue); err != nil {
+			return nil, err
+		}
+		values[i] = sanitize(value)
+	}
+	return values, nil
+}
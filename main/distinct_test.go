@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sortedValues formats the values, sorted, as the server returns distinct
+// values in no particular order.
+func sortedValues(values []interface{}) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%#v", v)
+	}
+	sort.Strings(s)
+	return fmt.Sprint(s)
+}
+
+func TestDistinct(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, json := range []string{
+		`{"stringValue": "b", "status": "ACTIVE", "mainItem": {"name": "x"}, "int64Value": "9000000000", "at": "2021-01-01T00:00:00Z"}`,
+		`{"stringValue": "a", "status": "ACTIVE", "mainItem": {"name": "y"}, "int64Value": "1"}`,
+		`{"stringValue": "b", "status": "CLOSED", "mainItem": {"name": "x"}, "int64Value": "1"}`,
+		`{"stringValue": "c"}`,
+	} {
+		if _, _, err := bound.Store(newSample(t, json)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		field string
+		want  []interface{}
+	}{
+		{"string_value", []interface{}{"a", "b", "c"}},
+		{"status", []interface{}{"ACTIVE", "CLOSED"}},
+		{"main_item.name", []interface{}{"x", "y"}},
+		{"int64_value", []interface{}{"1", "9000000000"}},
+		{"at", []interface{}{"2021-01-01T00:00:00Z"}},
+	} {
+		got, err := bound.Distinct(sample, c.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sortedValues(got) != sortedValues(c.want) {
+			t.Errorf("distinct values of %s are %v, want %v", c.field, sortedValues(got), sortedValues(c.want))
+		}
+	}
+
+	got, err := bound.Distinct(sample, "string_value", Eq("status", status(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"a", "b"}; sortedValues(got) != sortedValues(want) {
+		t.Errorf("distinct values of the active samples are %v, want %v", got, want)
+	}
+	if _, err := bound.Distinct(sample, "unknown"); err == nil {
+		t.Error("got distinct values of an unknown field")
+	}
+}
+
+func TestDistinctRepeated(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, json := range []string{
+		`{"stringValue": "a", "tags": ["red", "green"], "items": [{"name": "x"}, {"name": "y"}], "statuses": ["ACTIVE"], "history": ["2021-01-01T00:00:00Z"]}`,
+		`{"stringValue": "b", "tags": ["green", "blue"], "items": [{"name": "y"}, {"name": "z"}], "statuses": ["ACTIVE", "CLOSED"]}`,
+		`{"stringValue": "c", "tags": ["red"]}`,
+		`{"stringValue": "d"}`,
+	} {
+		if _, _, err := bound.Store(newSample(t, json)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		field   string
+		filters []bson.D
+		want    []interface{}
+	}{
+		{"tags", nil, []interface{}{"red", "green", "blue"}},
+		{"tags", []bson.D{In("stringValue", "b", "c")}, []interface{}{"red", "green", "blue"}},
+		{"tags", []bson.D{Eq("stringValue", "c")}, []interface{}{"red"}},
+		{"tags", []bson.D{Eq("stringValue", "d")}, []interface{}{}},
+		{"items.name", nil, []interface{}{"x", "y", "z"}},
+		{"statuses", nil, []interface{}{"ACTIVE", "CLOSED"}},
+		{"history", nil, []interface{}{"2021-01-01T00:00:00Z"}},
+	} {
+		got, err := bound.Distinct(sample, c.field, c.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sortedValues(got) != sortedValues(c.want) {
+			t.Errorf("distinct values of %s with %v are %v, want %v", c.field, c.filters, sortedValues(got), sortedValues(c.want))
+		}
+	}
+	if _, err := bound.Distinct(sample, "items.unknown"); err == nil {
+		t.Error("got distinct values of an unknown nested field")
+	}
+	if _, err := bound.Distinct(sample, "tags.name"); err == nil {
+		t.Error("got distinct values of a path into a string field")
+	}
+}
+
+func TestDistinctPhoneTypes(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, p := range []*Person{
+		{Name: "Ada", Phones: []*Person_PhoneNumber{{Number: "1", Type: Person_MOBILE}, {Number: "2", Type: Person_WORK}}},
+		{Name: "Bob", Phones: []*Person_PhoneNumber{{Number: "3", Type: Person_WORK}}},
+		{Name: "Eve"},
+	} {
+		if _, _, err := bound.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := bound.Distinct(person, "phones.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// protojson omits the zero value MOBILE
+	if want := []interface{}{"WORK"}; sortedValues(got) != sortedValues(want) {
+		t.Errorf("distinct phone types are %v, want %v", sortedValues(got), sortedValues(want))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrNotFound is returned when no document matches the requested id.
+// Check for it with errors.Is, as it is usually wrapped with the
+// collection name and the id that was looked up.
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidID is returned when an id can not be converted into the id
+// of a document, e.g. because it is no valid ObjectId hex string. The
+// concrete error is an *InvalidIDError, which tells the offending value.
+var ErrInvalidID = errors.New("invalid id")
+
+// InvalidIDError describes an id that could not be used to address a
+// document. It matches ErrInvalidID with errors.Is.
+type InvalidIDError struct {
+	ID  interface{}
+	Err error
+}
+
+func (e *InvalidIDError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("invalid id %#v", e.ID)
+	}
+	return fmt.Sprintf("invalid id %#v: %v", e.ID, e.Err)
+}
+
+func (e *InvalidIDError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidIDError) Is(target error) bool {
+	return target == ErrInvalidID
+}
+
+// ErrStoreClosed is returned by every operation after the ProtoStore was
+// closed.
+var ErrStoreClosed = errors.New("store is closed")
+
+// ErrDataCorruption is returned when the stored data violates an
+// invariant of the store, e.g. two documents sharing a unique id.
+var ErrDataCorruption = errors.New("data corruption")
+
+// ErrDeleted is returned when storing a document that was soft-deleted.
+var ErrDeleted = errors.New("document is deleted")
+
+// ErrMultipleMatches is returned when a single document was expected, but
+// several match.
+var ErrMultipleMatches = errors.New("multiple matches")
+
+// ErrUnknownField is returned when a filter references a field the
+// message does not have, which would silently match nothing.
+var ErrUnknownField = errors.New("unknown field")
+
+// ErrNoTextIndex is returned by Search if the collection has no text
+// index. Create one with EnsureTextIndex.
+var ErrNoTextIndex = errors.New("no text index, create one with EnsureTextIndex")
+
+// ErrResultTruncated is returned along with the first results of a query
+// which matches more documents than WithMaxResults allows. Set a Limit or
+// paginate to get all of them.
+var ErrResultTruncated = errors.New("result truncated")
+
+// DecodeError is returned if some documents of a result could not be
+// decoded into messages with WithDecodeConcurrency.
+type DecodeError struct {
+	// Errors maps the index of a document in the result to why it could
+	// not be decoded.
+	Errors map[int]error
+}
+
+func (e *DecodeError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, i := range errorIndexes(e.Errors) {
+		msgs = append(msgs, fmt.Sprintf("%d: %v", i, e.Errors[i]))
+	}
+	return fmt.Sprintf("%d documents could not be decoded: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Is tells whether the error of any document is target, so errors.Is
+// finds e.g. ErrDataCorruption among them.
+func (e *DecodeError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the documents, by their index, which
+// matches target, see errors.As.
+func (e *DecodeError) As(target interface{}) bool {
+	for _, i := range errorIndexes(e.Errors) {
+		if errors.As(e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrWriteConcernTimeout is returned when a write was applied by the
+// primary, but not acknowledged by as many members as the write concern
+// demands in time. Unlike other failures, the write probably persists,
+// so it should not be retried blindly. See WithWriteConcern.
+var ErrWriteConcernTimeout = errors.New("write concern timeout")
+
+// writeConcernTimeoutError marks an error of the driver as a timeout of
+// the write concern.
+type writeConcernTimeoutError struct {
+	err error
+}
+
+func (e writeConcernTimeoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e writeConcernTimeoutError) Unwrap() error {
+	return e.err
+}
+
+func (e writeConcernTimeoutError) Is(target error) bool {
+	return target == ErrWriteConcernTimeout
+}
+
+// writeError marks the error of a write as ErrWriteConcernTimeout if the
+// write concern timed out.
+func writeError(err error) error {
+	var wce *mongo.WriteConcernError
+	var we mongo.WriteException
+	var bwe mongo.BulkWriteException
+	switch {
+	case errors.As(err, &we):
+		wce = we.WriteConcernError
+	case errors.As(err, &bwe):
+		wce = bwe.WriteConcernError
+	}
+	if wce != nil && wce.Code == writeConcernFailedCode {
+		return writeConcernTimeoutError{err}
+	}
+	return err
+}
+
+// writeConcernFailedCode is the server error code of a write concern which
+// was not satisfied in time.
+const writeConcernFailedCode = 64
+
+// ErrCircuitOpen is returned by the operations guarded by the circuit
+// breaker while the database is considered unavailable, see
+// WithCircuitBreaker.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// ErrInvalidPage is returned by Page for a page below 1 or a page size
+// below 1.
+var ErrInvalidPage = errors.New("invalid page")
+
+// ErrPageTooLarge is returned by Page for a page size above the cap of
+// WithMaxResults.
+var ErrPageTooLarge = errors.New("page too large")
+
+// ErrInvalidFieldKind is returned when an operation does not support the
+// kind of the field it is applied to, e.g. Increment of a double field.
+// The concrete error is a *FieldKindError.
+var ErrInvalidFieldKind = errors.New("invalid field kind")
+
+// FieldKindError describes a field whose kind does not fit the operation.
+// It matches ErrInvalidFieldKind with errors.Is.
+type FieldKindError struct {
+	Operation string
+	Message   protoreflect.FullName
+	Field     string
+	Kind      protoreflect.Kind
+	Repeated  bool
+}
+
+func (e *FieldKindError) Error() string {
+	kind := e.Kind.String()
+	if e.Repeated {
+		kind = "repeated " + kind
+	}
+	return fmt.Sprintf("%s does not support field %s of %s, which is %s", e.Operation, e.Field, e.Message, kind)
+}
+
+func (e *FieldKindError) Is(target error) bool {
+	return target == ErrInvalidFieldKind
+}
+
+// ErrOutOfRange is returned when the result of an operation does not fit
+// the field, e.g. an Increment beyond the largest int32.
+var ErrOutOfRange = errors.New("out of range")
+
+// ErrInvalidCursor is returned by FilterAfter for a token it did not
+// issue, e.g. one that was tampered with or belongs to another model or
+// sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Verbosity decides how much Explain finds out about a query.
+type Verbosity string
+
+const (
+	// QueryPlanner only plans the query, without running it. The stats
+	// of the ExplainResult are zero.
+	QueryPlanner Verbosity = "queryPlanner"
+	// ExecutionStats runs the winning plan and reports its stats.
+	ExecutionStats Verbosity = "executionStats"
+	// AllPlansExecution also runs the rejected plans, to compare them.
+	AllPlansExecution Verbosity = "allPlansExecution"
+)
+
+// ExplainResult tells how the database runs a query.
+type ExplainResult struct {
+	// Stage is the stage of the winning plan which produces the results,
+	// e.g. FETCH, or COLLSCAN if all documents are scanned.
+	Stage string
+	// Indexes are the names of the indexes the winning plan uses, empty
+	// for a collection scan.
+	Indexes []string
+	// KeysExamined, DocsExamined and Returned count the index keys and
+	// documents the query looked at and how many documents it returned.
+	KeysExamined int64
+	DocsExamined int64
+	Returned     int64
+	// ExecutionTime is how long the query ran on the server.
+	ExecutionTime time.Duration
+	// Raw is the whole output of the explain command.
+	Raw bson.M
+}
+
+// UsesIndex tells whether the winning plan uses an index, or the one of
+// the name if a name is given.
+func (r ExplainResult) UsesIndex(name ...string) bool {
+	if len(name) == 0 {
+		return len(r.Indexes) > 0
+	}
+	for _, index := range r.Indexes {
+		if index == name[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// Explain tells how the database runs the query of Filter with the
+// filters and the query options, e.g. to check it uses an index. The query
+// is run to gather the stats of the ExecutionStats verbosity, see
+// ExplainWith for the others.
+func (p *BoundProtoStore) Explain(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ ExplainResult, err error) {
+	p, done := p.operation("Explain", model)
+	defer done(&err)
+	return p.ExplainWith(model, ExecutionStats, filters...)
+}
+
+// ExplainWith works like Explain with the verbosity.
+func (p *BoundProtoStore) ExplainWith(model func() protoreflect.ProtoMessage, verbosity Verbosity, filters ...bson.D) (_ ExplainResult, err error) {
+	p, done := p.operation("ExplainWith", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	coll, err := p.collection(model)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+	opts, err := p.findOptions(md, filters)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+
+	find := bson.D{
+		bson.E{Key: "find", Value: coll.Name()},
+		bson.E{Key: "filter", Value: filter},
+	}
+	if opts.Sort != nil {
+		find = append(find, bson.E{Key: "sort", Value: opts.Sort})
+	}
+	if opts.Projection != nil {
+		find = append(find, bson.E{Key: "projection", Value: opts.Projection})
+	}
+	if opts.Limit != nil && *opts.Limit > 0 {
+		find = append(find, bson.E{Key: "limit", Value: *opts.Limit})
+	}
+	if opts.Skip != nil {
+		find = append(find, bson.E{Key: "skip", Value: *opts.Skip})
+	}
+	if opts.Collation != nil {
+		find = append(find, bson.E{Key: "collation", Value: bson.Raw(opts.Collation.ToDocument())})
+	}
+	if opts.Hint != nil {
+		find = append(find, bson.E{Key: "hint", Value: opts.Hint})
+	}
+	if opts.Comment != nil {
+		find = append(find, bson.E{Key: "comment", Value: *opts.Comment})
+	}
+	if opts.MaxTime != nil {
+		find = append(find, bson.E{Key: "maxTimeMS", Value: opts.MaxTime.Milliseconds()})
+	}
+	cmd := bson.D{
+		bson.E{Key: "explain", Value: find},
+		bson.E{Key: "verbosity", Value: string(verbosity)},
+	}
+
+	explanation, err := coll.Database().RunCommand(p.ctx, cmd).DecodeBytes()
+	if err != nil {
+		return ExplainResult{}, fmt.Errorf("could not explain query of collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(explanation, &raw); err != nil {
+		return ExplainResult{}, fmt.Errorf("could not read explanation of collection %s: %w", coll.Name(), err)
+	}
+	var stats struct {
+		ExecutionStats struct {
+			NReturned           int64 `bson:"nReturned"`
+			ExecutionTimeMillis int64 `bson:"executionTimeMillis"`
+			TotalKeysExamined   int64 `bson:"totalKeysExamined"`
+			TotalDocsExamined   int64 `bson:"totalDocsExamined"`
+		} `bson:"executionStats"`
+	}
+	if err := bson.Unmarshal(explanation, &stats); err != nil {
+		return ExplainResult{}, fmt.Errorf("could not read explanation of collection %s: %w", coll.Name(), err)
+	}
+
+	res := ExplainResult{
+		KeysExamined:  stats.ExecutionStats.TotalKeysExamined,
+		DocsExamined:  stats.ExecutionStats.TotalDocsExamined,
+		Returned:      stats.ExecutionStats.NReturned,
+		ExecutionTime: time.Duration(stats.ExecutionStats.ExecutionTimeMillis) * time.Millisecond,
+		Raw:           raw,
+	}
+	if planner, ok := raw["queryPlanner"].(bson.M); ok {
+		if plan, ok := planner["winningPlan"].(bson.M); ok {
+			// the slot based engine nests the plan one level deeper
+			if queryPlan, ok := plan["queryPlan"].(bson.M); ok {
+				plan = queryPlan
+			}
+			res.Stage, _ = plan["stage"].(string)
+			res.Indexes = planIndexes(plan, nil)
+		}
+	}
+	return res, nil
+}
+
+// planIndexes appends the names of the indexes the stages of the plan
+// scan to indexes.
+func planIndexes(stage bson.M, indexes []string) []string {
+	name, _ := stage["stage"].(string)
+	switch {
+	case name == "IDHACK":
+		indexes = append(indexes, "_id_")
+	case strings.Contains(name, "IXSCAN"):
+		if index, ok := stage["indexName"].(string); ok {
+			indexes = append(indexes, index)
+		}
+	}
+	if input, ok := stage["inputStage"].(bson.M); ok {
+		indexes = planIndexes(input, indexes)
+	}
+	if inputs, ok := stage["inputStages"].(bson.A); ok {
+		for _, input := range inputs {
+			if input, ok := input.(bson.M); ok {
+				indexes = planIndexes(input, indexes)
+			}
+		}
+	}
+	return indexes
+}
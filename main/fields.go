@@ -0,0 +1,68 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Field references a field of the stored documents by its path, e.g.
+// phones.number. The constants generated by cmd/protofields are Fields,
+// so a renamed proto field breaks the build instead of silently matching
+// nothing.
+type Field string
+
+func (f Field) String() string {
+	return string(f)
+}
+
+// Eq works like the function Eq on the field.
+func (f Field) Eq(value interface{}) bson.D {
+	return Eq(string(f), value)
+}
+
+// Ne works like the function Ne on the field.
+func (f Field) Ne(value interface{}) bson.D {
+	return Ne(string(f), value)
+}
+
+// Gt works like the function Gt on the field.
+func (f Field) Gt(value interface{}) bson.D {
+	return Gt(string(f), value)
+}
+
+// Gte works like the function Gte on the field.
+func (f Field) Gte(value interface{}) bson.D {
+	return Gte(string(f), value)
+}
+
+// Lt works like the function Lt on the field.
+func (f Field) Lt(value interface{}) bson.D {
+	return Lt(string(f), value)
+}
+
+// Lte works like the function Lte on the field.
+func (f Field) Lte(value interface{}) bson.D {
+	return Lte(string(f), value)
+}
+
+// In works like the function In on the field.
+func (f Field) In(values ...interface{}) bson.D {
+	return In(string(f), values...)
+}
+
+// Exists works like FieldExists on the field.
+func (f Field) Exists(exists bool) bson.D {
+	return FieldExists(string(f), exists)
+}
+
+// ListField references a repeated message field. The generated
+// references of its element fields hold their full path, like
+// phones.number, while those below Elem are relative to the element and
+// meant for ElemMatch.
+type ListField struct {
+	Field
+}
+
+// ElemMatch works like the function ElemMatch on the field.
+func (f ListField) ElemMatch(conditions ...bson.D) bson.D {
+	return ElemMatch(string(f.Field), conditions...)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Eq matches the documents whose field col equals the value. For
+// repeated fields, it matches if any element equals the value. Enums are
+// best passed as their Go constants, e.g. Person_HOME, which are compared
+// in the representation the store writes them with, their name or, with
+// WithEnumAsNumber, their number. Documents written with the other
+// representation, e.g. before the option changed, do not match.
+func Eq(col string, value interface{}) bson.D {
+	return compare(col, "$eq", value)
+}
+
+// EqFold works like Eq, but ignores the case of strings. The query it is
+// part of runs with a case-insensitive collation, which applies to all of
+// its string comparisons and its sort order, unless WithCollation sets
+// another one.
+func EqFold(col string, value interface{}) bson.D {
+	return bson.D{
+		bson.E{Key: col, Value: bson.D{bson.E{Key: "$eq", Value: value}}},
+		bson.E{Key: foldMarker, Value: true},
+	}
+}
+
+// foldMarker marks a filter built by EqFold. It is removed from the
+// filter before it is sent to the database.
+const foldMarker = "$fold"
+
+// foldCollation is the collation of the queries with EqFold.
+var foldCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// hasFold tells whether the filter contains EqFold.
+func hasFold(value interface{}) bool {
+	switch v := value.(type) {
+	case bson.D:
+		for _, e := range v {
+			if e.Key == foldMarker || hasFold(e.Value) {
+				return true
+			}
+		}
+	case []bson.D:
+		for _, d := range v {
+			if hasFold(d) {
+				return true
+			}
+		}
+	case bson.A:
+		for _, e := range v {
+			if hasFold(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Ne matches the documents whose field col does not equal the value,
+// including those where it is not set.
+func Ne(col string, value interface{}) bson.D {
+	return compare(col, "$ne", value)
+}
+
+// Gt matches the documents whose field col is greater than the value.
+func Gt(col string, value interface{}) bson.D {
+	return compare(col, "$gt", value)
+}
+
+// Gte matches the documents whose field col is greater than or equal to
+// the value.
+func Gte(col string, value interface{}) bson.D {
+	return compare(col, "$gte", value)
+}
+
+// Lt matches the documents whose field col is less than the value.
+func Lt(col string, value interface{}) bson.D {
+	return compare(col, "$lt", value)
+}
+
+// Lte matches the documents whose field col is less than or equal to the
+// value.
+func Lte(col string, value interface{}) bson.D {
+	return compare(col, "$lte", value)
+}
+
+// In matches the documents whose field col equals one of the values.
+func In(col string, values ...interface{}) bson.D {
+	return compare(col, "$in", bson.A(values))
+}
+
+func compare(col string, operator string, value interface{}) bson.D {
+	return bson.D{bson.E{Key: col, Value: bson.D{bson.E{Key: operator, Value: value}}}}
+}
+
+// MapEq matches the documents whose map field has the key with the value,
+// e.g. MapEq("labels", "env", "prod"). Maps are stored as documents, with
+// the keys written like protojson does, so integer keys are passed as Go
+// integers and bool keys as Go bools. Keys containing a dot can not be
+// matched this way.
+func MapEq(field string, key interface{}, value interface{}) bson.D {
+	return Eq(field+"."+mapKey(key), value)
+}
+
+// mapKey returns how protojson writes the key of a map.
+func mapKey(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case bool:
+		return strconv.FormatBool(k)
+	case int:
+		return strconv.FormatInt(int64(k), 10)
+	case int32:
+		return strconv.FormatInt(int64(k), 10)
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case uint32:
+		return strconv.FormatUint(uint64(k), 10)
+	case uint64:
+		return strconv.FormatUint(k, 10)
+	}
+	return fmt.Sprint(key)
+}
+
+// FieldExists matches the documents which have the field col, or those
+// which do not. Note that protojson omits fields holding the zero value
+// of proto3, like "" or 0, so they are missing from the documents: to
+// find the messages without a nickname, use FieldExists("nickname",
+// false) instead of Eq("nickname", "").
+func FieldExists(col string, exists bool) bson.D {
+	return compare(col, "$exists", exists)
+}
+
+// IsNull matches the documents whose field col is explicitly null, but
+// not those which miss the field. The store never writes null, so this is
+// for documents written by other tools.
+func IsNull(col string) bson.D {
+	return compare(col, "$type", "null")
+}
+
+// EqOrMissing matches the documents whose field col equals the value or
+// is missing. Use it instead of Eq when the value may be a proto3 zero
+// value, which is not stored, see FieldExists.
+func EqOrMissing(col string, value interface{}) bson.D {
+	return Or(Eq(col, value), FieldExists(col, false))
+}
+
+// ElemMatch matches the documents with an element in the repeated field
+// col that matches all conditions at once. The conditions refer to the
+// fields of the element, e.g. ElemMatch("addresses", Eq("city",
+// "Berlin"), Eq("country", "DE")). Unlike Eq("addresses.city", "Berlin")
+// combined with Eq("addresses.country", "DE"), it does not match a person
+// with an address in Berlin and another one in DE.
+func ElemMatch(col string, conditions ...bson.D) bson.D {
+	merged := bson.D{}
+	keys := map[string]bool{}
+	for _, condition := range conditions {
+		for _, e := range condition {
+			if keys[e.Key] {
+				// merging would overwrite the earlier condition on the
+				// same key
+				return compare(col, "$elemMatch", And(conditions...))
+			}
+			keys[e.Key] = true
+			merged = append(merged, e)
+		}
+	}
+	return compare(col, "$elemMatch", merged)
+}
+
+// Between matches the documents whose field col is within the range from
+// low to high, both included. If low is greater than high, it matches no
+// document.
+func Between(col string, low, high interface{}) bson.D {
+	return between(col, "$gte", low, "$lte", high)
+}
+
+// BetweenExclusive works like Between, but excludes low and high.
+func BetweenExclusive(col string, low, high interface{}) bson.D {
+	return between(col, "$gt", low, "$lt", high)
+}
+
+func between(col string, lowOperator string, low interface{}, highOperator string, high interface{}) bson.D {
+	if greater(low, high) {
+		return Not(bson.D{})
+	}
+	return bson.D{bson.E{Key: col, Value: bson.D{
+		bson.E{Key: lowOperator, Value: low},
+		bson.E{Key: highOperator, Value: high},
+	}}}
+}
+
+// greater tells whether a is greater than b. Values which can not be
+// compared in Go, e.g. of different types, are left to the database.
+func greater(a, b interface{}) bool {
+	switch a := a.(type) {
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.After(b)
+		}
+	case *timestamppb.Timestamp:
+		if b, ok := b.(*timestamppb.Timestamp); ok {
+			return a.AsTime().After(b.AsTime())
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return a > b
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return a > b
+		}
+	case uint64:
+		if b, ok := b.(uint64); ok {
+			return a > b
+		}
+	}
+	x, xok := number(a)
+	y, yok := number(b)
+	return xok && yok && x > y
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// CreatedBy matches the documents created by the user with the id.
+func CreatedBy(userID uuid.UUID) bson.D {
+	return bson.D{bson.E{Key: fieldCreatedBy, Value: userID}}
+}
+
+// CreatedBetween matches the documents created within the range from
+// from to to, both included. Documents stored by versions of the store
+// which did not record the creation time never match.
+func CreatedBetween(from, to time.Time) bson.D {
+	return Between(fieldCreatedAt, from, to)
+}
+
+// And matches the documents matching all filters, like the filters passed
+// to Filter. Without filters, it matches all documents.
+func And(filters ...bson.D) bson.D {
+	filters = flattenAnd(filters)
+	switch len(filters) {
+	case 0:
+		return bson.D{}
+	case 1:
+		return filters[0]
+	}
+	return bson.D{bson.E{Key: "$and", Value: filterList(filters)}}
+}
+
+// Or matches the documents matching at least one of the filters. Without
+// filters, it matches no document.
+func Or(filters ...bson.D) bson.D {
+	if len(filters) == 0 {
+		return Not(bson.D{})
+	}
+	return bson.D{bson.E{Key: "$or", Value: filterList(filters)}}
+}
+
+// Not matches the documents not matching the filter. Unlike $not, which
+// only negates the operators of a single field, it takes any filter,
+// e.g. Not(Or(Eq("name", "a"), Eq("name", "b"))).
+func Not(filter bson.D) bson.D {
+	return bson.D{bson.E{Key: "$nor", Value: bson.A{filter}}}
+}
+
+// flattenAnd replaces the filters which are a $and by the filters they
+// combine, and leaves out empty filters, which match all documents. This
+// keeps combined filters flat, which is easier to read in logs and to
+// plan for the server.
+func flattenAnd(filters []bson.D) []bson.D {
+	res := make([]bson.D, 0, len(filters))
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+		if parts, ok := andParts(filter); ok {
+			res = append(res, flattenAnd(parts)...)
+			continue
+		}
+		res = append(res, filter)
+	}
+	return res
+}
+
+// andParts returns the filters combined by a filter which is nothing but
+// a $and of them.
+func andParts(filter bson.D) ([]bson.D, bool) {
+	if len(filter) != 1 || filter[0].Key != "$and" {
+		return nil, false
+	}
+	list, ok := asFilterList(filter[0].Value)
+	if !ok || len(list) == 0 { // an empty $and is an error, which is kept
+		return nil, false
+	}
+	parts := make([]bson.D, len(list))
+	for i, part := range list {
+		if parts[i], ok = part.(bson.D); !ok {
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+func filterList(filters []bson.D) bson.A {
+	list := make(bson.A, len(filters))
+	for i, filter := range filters {
+		list[i] = filter
+	}
+	return list
+}
+
+// translateFilter replaces the values within a filter with the
+// representation they are stored with. This way, a Go enum constant can
+// be passed to Eq, whether the store writes enums as names or, with
+// WithEnumAsNumber, as numbers. Times and unsigned integers are converted
+// like on the write path, so comparisons with them match the stored
+// values.
+func (s *settings) translateFilter(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case protoreflect.Enum:
+		return s.enumValue(v)
+	case time.Time:
+		return primitive.NewDateTimeFromTime(v), nil
+	case *timestamppb.Timestamp:
+		return primitive.NewDateTimeFromTime(v.AsTime()), nil
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+		d, _ := primitive.ParseDecimal128(strconv.FormatUint(v, 10))
+		return d, nil
+	case bson.D:
+		res := make(bson.D, 0, len(v))
+		for _, e := range v {
+			if e.Key == foldMarker {
+				continue
+			}
+			translated, err := s.translateFilter(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, bson.E{Key: e.Key, Value: translated})
+		}
+		return res, nil
+	case []bson.D:
+		res := make([]bson.D, len(v))
+		for i, d := range v {
+			translated, err := s.translateFilter(d)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = translated.(bson.D)
+		}
+		return res, nil
+	case bson.M:
+		res := make(bson.M, len(v))
+		for key, e := range v {
+			translated, err := s.translateFilter(e)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = translated
+		}
+		return res, nil
+	case bson.A:
+		return s.translateList(v)
+	case []interface{}:
+		return s.translateList(v)
+	}
+	return value, nil
+}
+
+func (s *settings) translateList(list []interface{}) (bson.A, error) {
+	res := make(bson.A, len(list))
+	for i, e := range list {
+		translated, err := s.translateFilter(e)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = translated
+	}
+	return res, nil
+}
+
+// enumValue returns how the enum value is stored. Numbers without a name,
+// e.g. from a conversion like Person_PhoneType(42), are rejected, as they
+// are most likely a mistake.
+func (s *settings) enumValue(e protoreflect.Enum) (interface{}, error) {
+	value := e.Descriptor().Values().ByNumber(e.Number())
+	if value == nil {
+		return nil, fmt.Errorf("enum %s has no value with number %d", e.Descriptor().FullName(), e.Number())
+	}
+	if s.enumAsNumber {
+		return int32(e.Number()), nil
+	}
+	return string(value.Name()), nil
+}
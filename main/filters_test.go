@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func status(n protoreflect.EnumNumber) protoreflect.Enum {
+	return dynamicpb.NewEnumType(sampleFile.Enums().ByName("Status")).New(n)
+}
+
+func TestEqEnum(t *testing.T) {
+	for _, asNumber := range []bool{false, true} {
+		t.Run(fmt.Sprintf("asNumber=%t", asNumber), func(t *testing.T) {
+			var opts []Option
+			if asNumber {
+				opts = append(opts, WithEnumAsNumber())
+			}
+			_, bound := newTestStore(t, opts...)
+			if _, _, err := bound.Store(newSample(t, `{"status": "ACTIVE"}`)); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := bound.Store(newSample(t, `{"status": "CLOSED"}`)); err != nil {
+				t.Fatal(err)
+			}
+			// written with the other representation
+			var other interface{} = int32(1)
+			if asNumber {
+				other = "ACTIVE"
+			}
+			coll := bound.db(bound.user.Realm).Collection("storetest.Sample")
+			if _, err := coll.InsertOne(bound.ctx, bson.D{
+				bson.E{Key: fieldType, Value: "storetest.Sample"},
+				bson.E{Key: "status", Value: other},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := bound.Count(sample, Eq("status", status(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Errorf("Eq on the enum matched %d documents, want 1", n)
+			}
+		})
+	}
+}
+
+func TestEqEnumWithoutName(t *testing.T) {
+	_, bound := newTestStore(t)
+	if _, err := bound.Filter(sample, Eq("status", status(42))); err == nil {
+		t.Error("filtering by an enum number without a name succeeded")
+	}
+}
+
+func TestTranslateFilterEnum(t *testing.T) {
+	byName, err := (&settings{}).translateFilter(Eq("status", status(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byNumber, err := (&settings{enumAsNumber: true}).translateFilter(Eq("status", status(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := func(v interface{}) bson.D {
+		return bson.D{bson.E{Key: "status", Value: bson.D{bson.E{Key: "$eq", Value: v}}}}
+	}
+	if !reflect.DeepEqual(byName, want("CLOSED")) {
+		t.Errorf("translated to %v, want %v", byName, want("CLOSED"))
+	}
+	if !reflect.DeepEqual(byNumber, want(int32(2))) {
+		t.Errorf("translated to %v, want %v", byNumber, want(int32(2)))
+	}
+	if _, err := (&settings{}).translateFilter(In("status", status(1), status(42))); err == nil {
+		t.Error("translated an enum number without a name")
+	}
+}
+
+// seedSamples stores a sample for each json and returns a function which
+// tells the stringValue of the samples the filters match, sorted.
+func seedSamples(t *testing.T, bound *BoundProtoStore, jsons ...string) func(filters ...bson.D) []string {
+	t.Helper()
+	for _, json := range jsons {
+		if _, _, err := bound.Store(newSample(t, json)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func(filters ...bson.D) []string {
+		t.Helper()
+		found, err := bound.Filter(sample, filters...)
+		if err != nil {
+			t.Fatalf("could not filter by %v: %v", filters, err)
+		}
+		names := stringValues(found)
+		sort.Strings(names)
+		return names
+	}
+}
+
+// stringValues returns the stringValue of each sample, in order.
+func stringValues(samples []protoreflect.ProtoMessage) []string {
+	values := make([]string, 0, len(samples))
+	for _, m := range samples {
+		values = append(values, m.ProtoReflect().Get(m.ProtoReflect().Descriptor().Fields().ByName("string_value")).String())
+	}
+	return values
+}
+
+func TestComparisonFilters(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "a", "int32Value": 1, "int64Value": "-5", "uint64Value": "1", "at": "2020-01-01T00:00:00Z"}`,
+		`{"stringValue": "b", "int32Value": 2, "int64Value": "10", "uint64Value": "7", "at": "2021-01-01T00:00:00Z"}`,
+		`{"stringValue": "c", "int32Value": 3, "int64Value": "9000000000", "at": "2022-01-01T00:00:00Z"}`,
+		`{"stringValue": "d"}`,
+	)
+	at := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{Gt("int32Value", 1), []string{"b", "c"}},
+		{Gte("int32Value", int32(2)), []string{"b", "c"}},
+		{Lt("int32Value", 2.5), []string{"a", "b"}},
+		{Lte("int32Value", int64(1)), []string{"a"}},
+		{Ne("int32Value", 2), []string{"a", "c", "d"}},
+		{Gt("int64Value", int64(9)), []string{"b", "c"}},
+		{Lt("int64Value", 0), []string{"a"}},
+		{Gt("uint64Value", uint64(1)), []string{"b"}},
+		{Gt("at", at), []string{"c"}},
+		{Gte("at", timestamppb.New(at)), []string{"b", "c"}},
+		{Lt("at", at), []string{"a"}},
+		{Lte("at", at), []string{"a", "b"}},
+		{Ne("at", at), []string{"a", "c", "d"}},
+	} {
+		if got := match(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v matched %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestComparisonFilterShape(t *testing.T) {
+	for operator, filter := range map[string]func(string, interface{}) bson.D{"$gt": Gt, "$gte": Gte, "$lt": Lt, "$lte": Lte, "$ne": Ne, "$eq": Eq} {
+		want := bson.D{bson.E{Key: "a.b", Value: bson.D{bson.E{Key: operator, Value: 1}}}}
+		if got := filter("a.b", 1); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s built %v, want %v", operator, got, want)
+		}
+	}
+}
+
+func TestLogicalFilterShape(t *testing.T) {
+	a, b, c := Eq("a", 1), Eq("b", 2), Gt("c", 3)
+	for _, tc := range []struct {
+		got, want bson.D
+	}{
+		{Or(a, And(b, c)), bson.D{bson.E{Key: "$or", Value: bson.A{a, bson.D{bson.E{Key: "$and", Value: bson.A{b, c}}}}}}},
+		{Not(Or(a, b)), bson.D{bson.E{Key: "$nor", Value: bson.A{bson.D{bson.E{Key: "$or", Value: bson.A{a, b}}}}}}},
+		{And(a), a},
+		{And(), bson.D{}},
+		{Or(), Not(bson.D{})},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("built %v, want %v", tc.got, tc.want)
+		}
+	}
+}
+
+// legacyEq is the filter Eq used to build, a $and around the equality.
+func legacyEq(col string, value interface{}) bson.D {
+	return bson.D{bson.E{Key: "$and", Value: bson.A{bson.D{bson.E{Key: col, Value: bson.D{bson.E{Key: "$eq", Value: value}}}}}}}
+}
+
+func TestFlattenAnd(t *testing.T) {
+	a, b, c := Eq("a", 1), Eq("b", 2), Gt("c", 3)
+	emptyAnd := bson.D{bson.E{Key: "$and", Value: bson.A{}}}
+	andWithOther := bson.D{bson.E{Key: "$and", Value: bson.A{a}}, bson.E{Key: "d", Value: 4}}
+	for _, tc := range []struct {
+		name      string
+		got, want bson.D
+	}{
+		{"nested", And(And(a, b), c), bson.D{bson.E{Key: "$and", Value: bson.A{a, b, c}}}},
+		{"deeply nested", And(a, And(And(b), And(c))), bson.D{bson.E{Key: "$and", Value: bson.A{a, b, c}}}},
+		{"legacy", And(legacyEq("a", 1), legacyEq("b", 2)), bson.D{bson.E{Key: "$and", Value: bson.A{a, b}}}},
+		{"single legacy", And(legacyEq("a", 1)), a},
+		{"empty filters", And(bson.D{}, a, bson.D{}), a},
+		{"only empty filters", And(bson.D{}, And()), bson.D{}},
+		// an empty $and is an error, which is kept, as is a $and beside
+		// other keys
+		{"empty $and", And(emptyAnd, a), bson.D{bson.E{Key: "$and", Value: bson.A{emptyAnd, a}}}},
+		{"$and and more", And(andWithOther, b), bson.D{bson.E{Key: "$and", Value: bson.A{andWithOther, b}}}},
+		{"within $or", Or(And(a, And(b, c))), bson.D{bson.E{Key: "$or", Value: bson.A{bson.D{bson.E{Key: "$and", Value: bson.A{a, b, c}}}}}}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: built %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestCombineFilters(t *testing.T) {
+	_, bound := newOfflineStore(t, context.Background())
+	md := sample().ProtoReflect().Descriptor()
+	a, b := Eq("stringValue", "a"), Gt("int32Value", int32(1))
+	for _, tc := range []struct {
+		name    string
+		filters []bson.D
+		want    bson.D
+	}{
+		{"none", nil, bson.D{}},
+		{"one", []bson.D{a}, a},
+		{"two", []bson.D{a, b}, bson.D{bson.E{Key: "$and", Value: []bson.D{a, b}}}},
+		{"nested", []bson.D{And(a, b)}, bson.D{bson.E{Key: "$and", Value: []bson.D{a, b}}}},
+		{"legacy", []bson.D{legacyEq("stringValue", "a"), bson.D{}, b}, bson.D{bson.E{Key: "$and", Value: []bson.D{a, b}}}},
+	} {
+		got, err := bound.combineFilters(md, tc.filters)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: combined %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if _, err := bound.combineFilters(md, []bson.D{And(a, Eq("nowhere", 1))}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("combining a filter of an unknown field returned %v, want ErrUnknownField", err)
+	}
+}
+
+func TestCanonicalFiltersMatchLegacy(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "a", "int32Value": 1, "tags": ["x"]}`,
+		`{"stringValue": "b", "int32Value": 2, "tags": ["x", "y"]}`,
+		`{"stringValue": "c", "int32Value": 2}`,
+	)
+	for _, tc := range []struct {
+		canonical, legacy []bson.D
+	}{
+		{[]bson.D{Eq("int32Value", int32(2))}, []bson.D{legacyEq("int32Value", int32(2))}},
+		{[]bson.D{Eq("tags", "x")}, []bson.D{legacyEq("tags", "x")}},
+		{[]bson.D{Eq("int32Value", int32(2)), Eq("tags", "y")}, []bson.D{legacyEq("int32Value", int32(2)), legacyEq("tags", "y")}},
+		{[]bson.D{And(Eq("int32Value", int32(2)), Ne("stringValue", "b"))}, []bson.D{{bson.E{Key: "$and", Value: bson.A{legacyEq("int32Value", int32(2)), Ne("stringValue", "b")}}}}},
+		{[]bson.D{Or(Eq("stringValue", "a"), Eq("stringValue", "c"))}, []bson.D{Or(legacyEq("stringValue", "a"), legacyEq("stringValue", "c"))}},
+		{[]bson.D{Not(Eq("tags", "x"))}, []bson.D{Not(legacyEq("tags", "x"))}},
+	} {
+		got, want := match(tc.canonical...), match(tc.legacy...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v matched %v, but %v matched %v", tc.canonical, got, tc.legacy, want)
+		}
+		if len(got) == 0 {
+			t.Errorf("%v matched nothing", tc.canonical)
+		}
+	}
+}
+
+func TestLogicalFilters(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "a", "int32Value": 1, "status": "ACTIVE"}`,
+		`{"stringValue": "b", "int32Value": 2, "status": "CLOSED"}`,
+		`{"stringValue": "c", "int32Value": 3, "status": "ACTIVE"}`,
+		`{"stringValue": "d", "int32Value": 4}`,
+	)
+	for _, c := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{Or(Eq("stringValue", "a"), Eq("stringValue", "b")), []string{"a", "b"}},
+		{Or(Eq("stringValue", "a"), And(Eq("status", status(1)), Gt("int32Value", 2))), []string{"a", "c"}},
+		{Not(Or(Eq("stringValue", "a"), In("int32Value", 2, 3))), []string{"d"}},
+		{Not(Eq("status", status(1))), []string{"b", "d"}},
+		{And(Or(Eq("stringValue", "a"), Eq("stringValue", "d")), Not(Lt("int32Value", 2))), []string{"d"}},
+		{Or(), []string{}},
+		{Not(Or()), []string{"a", "b", "c", "d"}},
+	} {
+		if got := match(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v matched %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "a", "int64Value": "1", "doubleValue": 0.5, "at": "2020-01-01T00:00:00Z"}`,
+		`{"stringValue": "b", "int64Value": "2", "doubleValue": 1.5, "at": "2021-01-01T00:00:00Z"}`,
+		`{"stringValue": "c", "int64Value": "3", "doubleValue": 2.5, "at": "2022-01-01T00:00:00Z"}`,
+	)
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{Between("int64Value", int64(1), int64(2)), []string{"a", "b"}},
+		{BetweenExclusive("int64Value", int64(1), int64(3)), []string{"b"}},
+		{Between("doubleValue", 0.5, 2.0), []string{"a", "b"}},
+		{BetweenExclusive("doubleValue", 0.5, 2.5), []string{"b"}},
+		{Between("at", from, to), []string{"a", "b"}},
+		{BetweenExclusive("at", from, to.Add(time.Hour)), []string{"b"}},
+		{Between("at", timestamppb.New(from), timestamppb.New(to)), []string{"a", "b"}},
+		// low above high
+		{Between("int64Value", int64(3), int64(1)), []string{}},
+		{Between("doubleValue", 2.5, 0.5), []string{}},
+		{Between("at", to, from), []string{}},
+	} {
+		if got := match(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v matched %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestFieldExistsAndIsNull(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "missing"}`,
+		// the empty text is not stored, like any proto3 zero value
+		`{"stringValue": "empty", "tags": [""]}`,
+		`{"stringValue": "set", "mainItem": {"name": "x"}, "tags": ["x"]}`,
+	)
+	// written by another tool
+	coll := bound.db(bound.user.Realm).Collection("storetest.Sample")
+	if _, err := coll.InsertOne(bound.ctx, bson.D{
+		bson.E{Key: fieldType, Value: "storetest.Sample:1"},
+		bson.E{Key: "stringValue", Value: "null"},
+		bson.E{Key: "mainItem", Value: nil},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{FieldExists("mainItem", true), []string{"null", "set"}},
+		{FieldExists("mainItem", false), []string{"empty", "missing"}},
+		{IsNull("mainItem"), []string{"null"}},
+		{FieldExists("tags", true), []string{"empty", "set"}},
+		{Eq("mainItem.name", ""), []string{}},
+		{EqOrMissing("mainItem.name", ""), []string{"empty", "missing", "null"}},
+		{EqOrMissing("mainItem.name", "x"), []string{"empty", "missing", "null", "set"}},
+	} {
+		if got := match(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v matched %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestElemMatch(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "one item", "items": [{"name": "berlin", "quantity": 2}]}`,
+		`{"stringValue": "two items", "items": [{"name": "berlin", "quantity": 1}, {"name": "paris", "quantity": 2}]}`,
+		`{"stringValue": "none"}`,
+	)
+	for _, c := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{ElemMatch("items", Eq("name", "berlin"), Eq("quantity", 2)), []string{"one item"}},
+		// the conditions may hold for different elements
+		{And(Eq("items.name", "berlin"), Eq("items.quantity", 2)), []string{"one item", "two items"}},
+		{ElemMatch("items", Eq("name", "berlin"), Gte("quantity", 1), Lt("quantity", 2)), []string{"two items"}},
+		{ElemMatch("items", Eq("name", "paris"), Eq("quantity", 1)), []string{}},
+		{Not(ElemMatch("items", Eq("name", "paris"))), []string{"none", "one item"}},
+	} {
+		if got := match(c.filter); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v matched %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestElemMatchShape(t *testing.T) {
+	got := ElemMatch("items", Eq("name", "x"), Gt("quantity", 1))
+	want := bson.D{bson.E{Key: "items", Value: bson.D{bson.E{Key: "$elemMatch", Value: bson.D{
+		bson.E{Key: "name", Value: bson.D{bson.E{Key: "$eq", Value: "x"}}},
+		bson.E{Key: "quantity", Value: bson.D{bson.E{Key: "$gt", Value: 1}}},
+	}}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("built %v, want %v", got, want)
+	}
+	// conditions on the same field are not merged, which would drop one
+	got = ElemMatch("items", Gte("quantity", 1), Lt("quantity", 2))
+	want = bson.D{bson.E{Key: "items", Value: bson.D{bson.E{Key: "$elemMatch", Value: And(Gte("quantity", 1), Lt("quantity", 2))}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("built %v, want %v", got, want)
+	}
+}
+
+func TestElemMatchValidation(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, filter := range []bson.D{
+		ElemMatch("stringValue", Eq("name", "x")),
+		ElemMatch("items", Eq("unknown", "x")),
+	} {
+		if _, err := bound.Filter(sample, filter); !errors.Is(err, ErrUnknownField) {
+			t.Errorf("filtering by %v returned %v, want ErrUnknownField", filter, err)
+		}
+	}
+}
+
+func TestCollationOfQuery(t *testing.T) {
+	_, bound := newOfflineStore(t, context.Background())
+	if c := bound.collation([]bson.D{Eq("a", "x")}); c != nil {
+		t.Errorf("a query without EqFold has the collation %v", c)
+	}
+	if c := bound.collation([]bson.D{Eq("a", "x"), Or(Eq("b", 1), EqFold("c", "x"))}); c != foldCollation {
+		t.Errorf("a query with a nested EqFold has the collation %v", c)
+	}
+	if c := bound.With(WithCollation("de", 1)).collation([]bson.D{EqFold("c", "x")}); c == nil || c.Locale != "de" || c.Strength != 1 {
+		t.Errorf("WithCollation did not take precedence over EqFold: %v", c)
+	}
+	translated, err := bound.protoStore.settings.translateFilter(EqFold("c", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(translated, Eq("c", "x")) {
+		t.Errorf("EqFold was sent as %v", translated)
+	}
+}
+
+func TestCaseInsensitiveEquality(t *testing.T) {
+	_, bound := newTestStore(t)
+	match := seedSamples(t, bound,
+		`{"stringValue": "Ada@Example.com"}`,
+		`{"stringValue": "ada@example.com"}`,
+		`{"stringValue": "ADA@EXAMPLE.COM"}`,
+		`{"stringValue": "grace@example.com"}`,
+	)
+	want := []string{"ADA@EXAMPLE.COM", "Ada@Example.com", "ada@example.com"}
+	if got := match(EqFold("stringValue", "aDa@example.COM")); !reflect.DeepEqual(got, want) {
+		t.Errorf("EqFold matched %v, want %v", got, want)
+	}
+	found, err := bound.With(WithCollation("en", 2)).Filter(sample, Eq("stringValue", "aDa@example.COM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(want) {
+		t.Errorf("Eq with WithCollation matched %d samples, want %d", len(found), len(want))
+	}
+	if got := match(Eq("stringValue", "ada@example.com")); !reflect.DeepEqual(got, []string{"ada@example.com"}) {
+		t.Errorf("Eq without collation matched %v", got)
+	}
+
+	// an index with the same collation serves the query
+	coll := bound.db(bound.user.Realm).Collection("storetest.Sample")
+	_, err = coll.Indexes().CreateOne(bound.ctx, mongo.IndexModel{
+		Keys:    bson.D{bson.E{Key: "stringValue", Value: 1}},
+		Options: options.Index().SetName("stringValue_ci").SetCollation(foldCollation),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	explained, err := bound.Explain(sample, EqFold("stringValue", "ada@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !explained.UsesIndex("stringValue_ci") {
+		t.Errorf("EqFold does not use the index with its collation, but %s %v", explained.Stage, explained.Indexes)
+	}
+}
+
+func TestMapKey(t *testing.T) {
+	for _, tc := range []struct {
+		key  interface{}
+		want string
+	}{
+		{"env", "env"},
+		{true, "true"},
+		{false, "false"},
+		{42, "42"},
+		{int32(-7), "-7"},
+		{int64(9007199254740993), "9007199254740993"},
+		{uint32(7), "7"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+	} {
+		if got := mapKey(tc.key); got != tc.want {
+			t.Errorf("the key %#v is written as %q, want %q", tc.key, got, tc.want)
+		}
+	}
+	if got, want := MapEq("labels", 42, "x"), Eq("labels.42", "x"); !reflect.DeepEqual(got, want) {
+		t.Errorf("MapEq built %v, want %v", got, want)
+	}
+}
+
+func TestMapPathValidation(t *testing.T) {
+	md := sample().ProtoReflect().Descriptor()
+	for _, path := range []string{"counts.env", "labels.-7", "flags.true", "flags.false", "sizes.9007199254740993", "itemMap.a.name"} {
+		if _, err := validatePath(md, path); err != nil {
+			t.Errorf("path %s is invalid: %v", path, err)
+		}
+	}
+	for _, path := range []string{"labels.env", "labels.4294967296", "flags.yes", "flags.1", "sizes.1.5", "sizes.99999999999999999999", "itemMap.a.nowhere"} {
+		if _, err := validatePath(md, path); !errors.Is(err, ErrUnknownField) {
+			t.Errorf("path %s is valid, validation returned %v", path, err)
+		}
+	}
+}
+
+func TestMapEq(t *testing.T) {
+	_, bound := newTestStore(t)
+	jsons := []string{
+		`{"stringValue": "a", "counts": {"env": 1}, "labels": {"1": "prod"}, "flags": {"true": "on"}, "sizes": {"9007199254740993": 3}}`,
+		`{"stringValue": "b", "counts": {"env": 2}, "labels": {"2": "prod"}, "flags": {"false": "on"}, "sizes": {"9007199254740992": 3}}`,
+		`{"stringValue": "c", "labels": {"-1": "dev"}, "itemMap": {"env": {"name": "x"}}}`,
+	}
+	match := seedSamples(t, bound, jsons...)
+	for _, tc := range []struct {
+		filter bson.D
+		want   []string
+	}{
+		{MapEq("counts", "env", int32(2)), []string{"b"}},
+		{MapEq("labels", 1, "prod"), []string{"a"}},
+		{MapEq("labels", int32(-1), "dev"), []string{"c"}},
+		{MapEq("labels", 2, "dev"), []string{}},
+		{MapEq("flags", true, "on"), []string{"a"}},
+		{MapEq("flags", false, "on"), []string{"b"}},
+		{MapEq("sizes", int64(9007199254740993), int32(3)), []string{"a"}},
+		{Eq("itemMap.env.name", "x"), []string{"c"}},
+	} {
+		if got := match(tc.filter); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v matched %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+	for _, filter := range []bson.D{MapEq("flags", "yes", "on"), MapEq("sizes", "big", int32(3)), MapEq("labels", 1.5, "prod")} {
+		if _, err := bound.Filter(sample, filter); !errors.Is(err, ErrUnknownField) {
+			t.Errorf("filtering by %v returned %v, want ErrUnknownField", filter, err)
+		}
+	}
+
+	// the maps are read back with keys of their kinds
+	found, err := bound.Filter(sample, Eq("stringValue", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d samples a", len(found))
+	}
+	want := newSample(t, jsons[0])
+	id := want.ProtoReflect().Descriptor().Fields().ByName("id")
+	want.ProtoReflect().Set(id, found[0].ProtoReflect().Get(id))
+	assertEqual(t, found[0], want)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Messages with a latitude and a longitude, like
+//
+//	message Location {
+//	  double lat = 1;
+//	  double lng = 2;
+//	}
+//
+// are stored as GeoJSON points in the fields set with WithGeoField, so
+// the database can index and query them. The latitude field may be named
+// lat or latitude, the longitude field lng, lon or longitude.
+
+var (
+	latitudeNames  = []string{"lat", "latitude"}
+	longitudeNames = []string{"lng", "lon", "longitude"}
+)
+
+// geoField returns the json names of the latitude and longitude fields
+// of the message at the path, if it is one of the geo fields.
+func (s *settings) geoField(md protoreflect.MessageDescriptor, path string) (lat string, lng string, ok bool) {
+	if !s.geoFields[path] {
+		return "", "", false
+	}
+	fields, err := resolvePath(md, path)
+	if err != nil {
+		return "", "", false
+	}
+	fd := fields[len(fields)-1]
+	if !isMessage(fd) || fd.IsList() || fd.IsMap() {
+		return "", "", false
+	}
+	lat, latOK := coordinateField(fd.Message(), latitudeNames)
+	lng, lngOK := coordinateField(fd.Message(), longitudeNames)
+	return lat, lng, latOK && lngOK
+}
+
+func coordinateField(md protoreflect.MessageDescriptor, names []string) (string, bool) {
+	for _, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd != nil && (fd.Kind() == protoreflect.DoubleKind || fd.Kind() == protoreflect.FloatKind) && !fd.IsList() {
+			return fd.JSONName(), true
+		}
+	}
+	return "", false
+}
+
+// toGeoJSON replaces the values of the geo fields in the document with
+// GeoJSON points.
+func (s *settings) toGeoJSON(md protoreflect.MessageDescriptor, doc map[string]interface{}) {
+	for path := range s.geoFields {
+		lat, lng, ok := s.geoField(md, path)
+		if !ok {
+			continue
+		}
+		value, ok := lookupPath(doc, path)
+		if !ok {
+			continue
+		}
+		location, ok := asDoc(value)
+		if !ok {
+			continue
+		}
+		// protojson omits zero values, so a missing coordinate is 0
+		x, _ := number(location[lng])
+		y, _ := number(location[lat])
+		setPath(doc, path, bson.M{"type": "Point", "coordinates": bson.A{x, y}})
+	}
+}
+
+// fromGeoJSON replaces the GeoJSON points of the geo fields in the
+// document with the latitude and longitude the message expects.
+func (s *settings) fromGeoJSON(md protoreflect.MessageDescriptor, doc map[string]interface{}) {
+	for path := range s.geoFields {
+		lat, lng, ok := s.geoField(md, path)
+		if !ok {
+			continue
+		}
+		value, ok := lookupPath(doc, path)
+		if !ok {
+			continue
+		}
+		point, ok := asDoc(value)
+		if !ok || point["type"] != "Point" {
+			continue
+		}
+		coordinates, ok := asList(point["coordinates"])
+		if !ok || len(coordinates) != 2 {
+			continue
+		}
+		x, _ := number(coordinates[0])
+		y, _ := number(coordinates[1])
+		setPath(doc, path, map[string]interface{}{lng: x, lat: y})
+	}
+}
+
+// setPath sets the value at the dot-separated path within the document,
+// whose parent has to exist.
+func setPath(doc map[string]interface{}, path string, value interface{}) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		doc[path] = value
+		return
+	}
+	parent, ok := lookupPath(doc, path[:i])
+	if !ok {
+		return
+	}
+	if parent, ok := asDoc(parent); ok {
+		parent[path[i+1:]] = value
+	}
+}
+
+// EnsureGeoIndex creates the 2dsphere index NearSphere and WithinPolygon
+// need on the geo field of the model, unless this store already did so.
+// The field has to be set with WithGeoField.
+func (p *BoundProtoStore) EnsureGeoIndex(model func() protoreflect.ProtoMessage, field string) (err error) {
+	p, done := p.operation("EnsureGeoIndex", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, field)
+	if err != nil {
+		return err
+	}
+	path := jsonPath(fields)
+	if _, _, ok := p.protoStore.settings.geoField(md, path); !ok {
+		return fmt.Errorf("field %s of %s is not stored as GeoJSON, see WithGeoField", field, md.FullName())
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	index := mongo.IndexModel{Keys: bson.D{bson.E{Key: path, Value: "2dsphere"}}}
+	return p.ensureIndex(coll, "2dsphere:"+path, index)
+}
+
+// NearSphere matches the documents whose geo field col is at most
+// maxMeters away from the point, the nearest first. It needs the index of
+// EnsureGeoIndex and can not be used with Count or within Or and Not.
+func NearSphere(col string, lng, lat, maxMeters float64) bson.D {
+	return compare(col, "$nearSphere", bson.D{
+		bson.E{Key: "$geometry", Value: bson.D{
+			bson.E{Key: "type", Value: "Point"},
+			bson.E{Key: "coordinates", Value: bson.A{lng, lat}},
+		}},
+		bson.E{Key: "$maxDistance", Value: maxMeters},
+	})
+}
+
+// WithinPolygon matches the documents whose geo field col is within the
+// polygon. The ring lists its corners as [lng, lat] pairs, it is closed
+// if its last corner is not its first.
+func WithinPolygon(col string, ring [][2]float64) bson.D {
+	corners := make(bson.A, 0, len(ring)+1)
+	for _, corner := range ring {
+		corners = append(corners, bson.A{corner[0], corner[1]})
+	}
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		corners = append(corners, bson.A{ring[0][0], ring[0][1]})
+	}
+	return compare(col, "$geoWithin", bson.D{
+		bson.E{Key: "$geometry", Value: bson.D{
+			bson.E{Key: "type", Value: "Polygon"},
+			bson.E{Key: "coordinates", Value: bson.A{corners}},
+		}},
+	})
+}
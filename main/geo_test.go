@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGeoJSONConversion(t *testing.T) {
+	md := sample().ProtoReflect().Descriptor()
+	s := newSettings([]Option{WithGeoField("location")})
+	doc := map[string]interface{}{"location": map[string]interface{}{"lng": 13.4, "lat": 52.5}}
+	s.toGeoJSON(md, doc)
+	if want := (bson.M{"type": "Point", "coordinates": bson.A{13.4, 52.5}}); !reflect.DeepEqual(doc["location"], want) {
+		t.Errorf("stored the location as %v, want %v", doc["location"], want)
+	}
+	s.fromGeoJSON(md, doc)
+	if want := map[string]interface{}{"lng": 13.4, "lat": 52.5}; !reflect.DeepEqual(doc["location"], want) {
+		t.Errorf("read the location back as %v, want %v", doc["location"], want)
+	}
+
+	// protojson omits zero coordinates
+	doc = map[string]interface{}{"location": map[string]interface{}{"lat": 52.5}}
+	s.toGeoJSON(md, doc)
+	if want := (bson.M{"type": "Point", "coordinates": bson.A{0.0, 52.5}}); !reflect.DeepEqual(doc["location"], want) {
+		t.Errorf("stored the location on the meridian as %v, want %v", doc["location"], want)
+	}
+
+	plain := newSettings(nil)
+	doc = map[string]interface{}{"location": map[string]interface{}{"lng": 13.4, "lat": 52.5}}
+	plain.toGeoJSON(md, doc)
+	if want := map[string]interface{}{"lng": 13.4, "lat": 52.5}; !reflect.DeepEqual(doc["location"], want) {
+		t.Errorf("stored the location as %v without WithGeoField", doc["location"])
+	}
+}
+
+func TestGeoQueries(t *testing.T) {
+	_, bound := newTestStore(t, WithGeoField("location"))
+	if err := bound.EnsureGeoIndex(sample, "location"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.EnsureGeoIndex(sample, "mainItem"); err == nil {
+		t.Error("created a geo index on a field which is not stored as GeoJSON")
+	}
+	if err := bound.EnsureGeoIndex(sample, "nowhere"); err == nil {
+		t.Error("created a geo index on an unknown field")
+	}
+
+	// around the Brandenburg Gate at 13.3777, 52.5163
+	names := seedSamples(t, bound,
+		`{"stringValue": "reichstag", "location": {"lng": 13.3761, "lat": 52.5186}}`,
+		`{"stringValue": "alexanderplatz", "location": {"lng": 13.4132, "lat": 52.5219}}`,
+		`{"stringValue": "tiergarten", "location": {"lng": 13.3500, "lat": 52.5145}}`,
+		`{"stringValue": "potsdam", "location": {"lng": 13.0645, "lat": 52.3906}}`,
+	)
+
+	found, err := bound.Filter(sample, NearSphere("location", 13.3777, 52.5163, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stringValues(found), []string{"reichstag", "tiergarten", "alexanderplatz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("within 5km are %v, want %v, the nearest first", got, want)
+	}
+
+	// the ring around the Tiergarten is closed implicitly
+	ring := [][2]float64{{13.30, 52.50}, {13.39, 52.50}, {13.39, 52.53}, {13.30, 52.53}}
+	if got, want := names(WithinPolygon("location", ring)), []string{"reichstag", "tiergarten"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("within the polygon are %v, want %v", got, want)
+	}
+
+	want := newSample(t, `{"stringValue": "spandau", "location": {"lng": 13.2, "lat": 52.53}}`)
+	id, _, err := bound.Store(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := bson.M{"type": "Point", "coordinates": bson.A{13.2, 52.53}}
+	if got := rawDoc(t, bound, sample, id)["location"]; !reflect.DeepEqual(got, point) {
+		t.Errorf("stored the location as %v, want %v", got, point)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, want)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MissingKey is the key of CountBy for the documents without the field.
+// It only occurs for fields which track their presence, like optional or
+// message fields. Other fields are missing if they hold their zero value,
+// which protojson omits, so they are counted under the zero value, e.g.
+// "false" or "".
+const MissingKey = "<missing>"
+
+// CountBy counts the documents matching the filters, which are combined
+// like in Filter, per value of the field. The field may be a nested path
+// like address.city, but no repeated or message field. The values are
+// the keys of the result as protojson writes them, e.g. "true" for bools
+// and the names of enum values.
+func (p *BoundProtoStore) CountBy(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ map[string]int64, err error) {
+	p, done := p.operation("CountBy", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, field)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range fields {
+		if fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("could not count by field %s of %s, as %s is repeated", field, md.FullName(), fd.Name())
+		}
+	}
+	fd := fields[len(fields)-1]
+	if isMessage(fd) && !isWellKnown(fd.Message()) {
+		return nil, fmt.Errorf("could not count by field %s of %s, which is a message", field, md.FullName())
+	}
+	path := jsonPath(fields)
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := mongo.Pipeline{
+		{bson.E{Key: "$match", Value: filter}},
+		{bson.E{Key: "$group", Value: bson.D{
+			bson.E{Key: "_id", Value: "$" + path},
+			bson.E{Key: "count", Value: bson.D{bson.E{Key: "$sum", Value: 1}}},
+		}}},
+	}
+	opts := options.Aggregate()
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	opts.SetComment(p.comment(md))
+	rows, err := coll.Aggregate(p.ctx, pipeline, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not count documents of collection %s by field %s: %w", coll.Name(), path, err)
+	}
+	var buckets []struct {
+		Value interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err := rows.All(p.ctx, &buckets); err != nil {
+		return nil, fmt.Errorf("could not fetch counts of collection %s by field %s: %w", coll.Name(), path, err)
+	}
+
+	counts := make(map[string]int64, len(buckets))
+	for _, bucket := range buckets {
+		key, err := groupKey(fd, bucket.Value)
+		if err != nil {
+			return nil, err
+		}
+		// e.g. enums stored by name and by number end up in one bucket
+		counts[key] += bucket.Count
+	}
+	return counts, nil
+}
+
+// groupKey returns the value of the field as a string, like protojson
+// writes it.
+func groupKey(fd protoreflect.FieldDescriptor, value interface{}) (string, error) {
+	if value == nil {
+		if fd.HasPresence() {
+			return MissingKey, nil
+		}
+		value = fd.Default().Interface()
+		if fd.Kind() == protoreflect.EnumKind {
+			value = int32(fd.Default().Enum())
+		}
+	}
+	value, err := walkValue(fd, value, fromBSONValue)
+	if err != nil {
+		return "", err
+	}
+	switch v := sanitize(value).(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int32:
+		if fd.Kind() == protoreflect.EnumKind {
+			if enumValue := fd.Enum().Values().ByNumber(protoreflect.EnumNumber(v)); enumValue != nil {
+				return string(enumValue.Name()), nil
+			}
+		}
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []byte:
+		return string(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
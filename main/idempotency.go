@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// idempotencyCollection holds the idempotency keys of a realm.
+const idempotencyCollection = "_idempotency"
+
+// idempotencyRecord is what is stored per idempotency key.
+type idempotencyRecord struct {
+	Key        string      `bson:"_id"`
+	Collection string      `bson:"collection"`
+	DocumentID interface{} `bson:"documentId"`
+	CreatedAt  time.Time   `bson:"createdAt"`
+}
+
+// StoreIdempotent stores the message like Store, but only once per
+// idempotency key. If the key was used before, the id of the document
+// stored back then is returned and nothing is written. This makes
+// retries of clients safe, which would otherwise create duplicates of new
+// messages. The keys expire after the TTL set with WithIdempotencyTTL.
+//
+// If the server supports transactions, the key and the document are
+// written in one. Otherwise, the key is written first and released again
+// if the document could not be written.
+func (p *BoundProtoStore) StoreIdempotent(message protoreflect.ProtoMessage, idempotencyKey string) (_ string, err error) {
+	p, done := p.operation("StoreIdempotent", modelOf(message))
+	defer done(&err)
+	if idempotencyKey == "" {
+		return "", errors.New("the idempotency key is empty")
+	}
+	if err := p.ready(); err != nil {
+		return "", err
+	}
+	doc, err := p.document(message)
+	if err != nil {
+		return "", err
+	}
+
+	keys := p.db(p.user.Realm).Collection(idempotencyCollection, p.collectionOptions())
+	ttl := mongo.IndexModel{
+		Keys:    bson.D{bson.E{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(p.protoStore.settings.idempotencyTTL.Seconds())),
+	}
+	if err := p.ensureIndex(keys, "ttl", ttl); err != nil {
+		return "", err
+	}
+	record := idempotencyRecord{
+		Key:        idempotencyKey,
+		Collection: string(message.ProtoReflect().Descriptor().FullName()),
+		DocumentID: doc[fieldID],
+		CreatedAt:  time.Now(),
+	}
+
+	transactions, err := p.protoStore.supportsTransactions(p.ctx)
+	if err != nil {
+		return "", err
+	}
+	if transactions {
+		session, err := p.protoStore.client.StartSession()
+		if err != nil {
+			return "", fmt.Errorf("could not start a session: %w", err)
+		}
+		defer session.EndSession(p.ctx)
+		id, err := session.WithTransaction(p.ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			id, _, err := p.storeOnce(sc, keys, record, message, doc)
+			return id, err
+		})
+		if err != nil {
+			return "", err
+		}
+		return id.(string), nil
+	}
+
+	id, inserted, err := p.storeOnce(p.ctx, keys, record, message, doc)
+	if err != nil && inserted {
+		// release the key, so a retry can store the message
+		if _, delErr := keys.DeleteOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: idempotencyKey}}); delErr != nil {
+			return "", fmt.Errorf("%v, and could not release idempotency key %s: %w", err, idempotencyKey, delErr)
+		}
+	}
+	return id, err
+}
+
+// storeOnce records the idempotency key and writes the document, unless
+// the key is already recorded. The bool tells whether the key was
+// recorded by this call.
+func (p *BoundProtoStore) storeOnce(ctx context.Context, keys *mongo.Collection, record idempotencyRecord, message protoreflect.ProtoMessage, doc map[string]interface{}) (string, bool, error) {
+	_, err := keys.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		var existing idempotencyRecord
+		if err := keys.FindOne(ctx, bson.D{bson.E{Key: fieldID, Value: record.Key}}).Decode(&existing); err != nil {
+			return "", false, fmt.Errorf("could not read idempotency key %s: %w", record.Key, err)
+		}
+		if existing.Collection != record.Collection {
+			return "", false, fmt.Errorf("idempotency key %s was used for %s before, not for %s", record.Key, existing.Collection, record.Collection)
+		}
+		id, err := p.protoStore.settings.setID(message, map[string]interface{}{fieldID: existing.DocumentID})
+		return id, false, err
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("could not record idempotency key %s: %w", record.Key, err)
+	}
+
+	bound := *p
+	bound.ctx = ctx
+	id, _, err := bound.write(message, doc, newStoreConfig(nil))
+	return id, true, err
+}
+
+// transactionSupport caches whether the server supports transactions,
+// which requires a replica set or a sharded cluster.
+type transactionSupport struct {
+	mu        sync.Mutex
+	checked   bool
+	supported bool
+}
+
+func (p *ProtoStore) supportsTransactions(ctx context.Context) (bool, error) {
+	p.transactions.mu.Lock()
+	defer p.transactions.mu.Unlock()
+	if p.transactions.checked {
+		return p.transactions.supported, nil
+	}
+	var res bson.M
+	err := p.client.Database("admin").RunCommand(ctx, bson.D{bson.E{Key: "isMaster", Value: 1}}).Decode(&res)
+	if err != nil {
+		return false, fmt.Errorf("could not determine the topology of the database: %w", err)
+	}
+	_, replicaSet := res["setName"]
+	p.transactions.supported = replicaSet || res["msg"] == "isdbgrid"
+	p.transactions.checked = true
+	return p.transactions.supported, nil
+}
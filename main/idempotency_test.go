@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStoreIdempotent(t *testing.T) {
+	for _, transactions := range []bool{false, true} {
+		t.Run(fmt.Sprintf("transactions=%t", transactions), func(t *testing.T) {
+			store, bound := newTestStore(t)
+			supported, err := store.supportsTransactions(bound.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transactions && !supported {
+				t.Skip("the database does not support transactions")
+			}
+			// a replica set also takes the path of a standalone server
+			store.transactions.supported = transactions
+
+			first, err := bound.StoreIdempotent(&Person{Name: "Ada"}, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			retried := &Person{Name: "Ada again"}
+			second, err := bound.StoreIdempotent(retried, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if second != first || retried.Id != first {
+				t.Errorf("retry stored %s onto %s, want %s", second, retried.Id, first)
+			}
+			n, err := bound.Count(person)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Errorf("stored %d documents, want 1", n)
+			}
+			got, err := bound.Get(person, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := got.(*Person).Name; name != "Ada" {
+				t.Errorf("retry overwrote the name with %q", name)
+			}
+
+			if _, err := bound.StoreIdempotent(&AddressBook{}, "key"); err == nil {
+				t.Error("reusing the key for another collection succeeded")
+			}
+			if _, err := bound.StoreIdempotent(&Person{}, ""); err == nil {
+				t.Error("storing with an empty key succeeded")
+			}
+		})
+	}
+}
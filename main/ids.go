@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDCodec converts between the string ids of messages and the _id values
+// of documents. Get, Filter and all other operations convert ids through
+// the codec configured with WithIDCodec.
+type IDCodec interface {
+	// EncodeToBSON converts the id of a message into the _id of its
+	// document. It returns an error if the id is malformed.
+	EncodeToBSON(id string) (interface{}, error)
+	// DecodeToString converts the _id of a document into the id of its
+	// message.
+	DecodeToString(value interface{}) (string, error)
+}
+
+// ObjectIDCodec stores ids as ObjectIds, with the hex as id of the
+// messages. It is the default.
+type ObjectIDCodec struct{}
+
+func (ObjectIDCodec) EncodeToBSON(id string) (interface{}, error) {
+	return primitive.ObjectIDFromHex(id)
+}
+
+func (ObjectIDCodec) DecodeToString(value interface{}) (string, error) {
+	oid, ok := value.(primitive.ObjectID)
+	if !ok {
+		return "", fmt.Errorf("%v is no ObjectId, but %T", value, value)
+	}
+	return oid.Hex(), nil
+}
+
+func (ObjectIDCodec) NewID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// UUIDStringCodec stores ids, which have to be UUIDs, as they are. This
+// keeps ids minted by other services as primary key.
+type UUIDStringCodec struct{}
+
+func (UUIDStringCodec) EncodeToBSON(id string) (interface{}, error) {
+	if _, err := uuid.FromString(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (UUIDStringCodec) DecodeToString(value interface{}) (string, error) {
+	id, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%v is no string, but %T", value, value)
+	}
+	return id, nil
+}
+
+func (UUIDStringCodec) NewID() string {
+	return uuid.NewV4().String()
+}
+
+// idGenerator is implemented by codecs which know how new ids look like.
+// Otherwise, new ids are ObjectId hex strings.
+type idGenerator interface {
+	NewID() string
+}
+
+// encodeID converts the id of a message into the _id of its document in
+// the given collection.
+func (s *settings) encodeID(collection string, id string) (interface{}, error) {
+	value, err := s.idCodec.EncodeToBSON(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode id for collection %s: %w", collection, &InvalidIDError{ID: id, Err: err})
+	}
+	return value, nil
+}
+
+// decodeID converts the _id of a document in the given collection into
+// the id of its message.
+func (s *settings) decodeID(collection string, value interface{}) (string, error) {
+	id, err := s.idCodec.DecodeToString(value)
+	if err != nil {
+		return "", fmt.Errorf("could not read id of document in collection %s: %w", collection, err)
+	}
+	return id, nil
+}
+
+// newID returns the id for a new document. It is passed through the
+// codec like the ids of messages are.
+func (s *settings) newID() string {
+	if s.idGenerator != nil {
+		return s.idGenerator()
+	}
+	if g, ok := s.idCodec.(idGenerator); ok {
+		return g.NewID()
+	}
+	return primitive.NewObjectID().Hex()
+}
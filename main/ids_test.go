@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestIDGenerator(t *testing.T) {
+	var generated []string
+	_, bound := newTestStore(t, WithIDGenerator(func() string {
+		id := primitive.NewObjectID().Hex()
+		generated = append(generated, id)
+		return id
+	}))
+	assertGenerated := func(n int) {
+		t.Helper()
+		if len(generated) != n {
+			t.Fatalf("the generator was called %d times, want %d", len(generated), n)
+		}
+	}
+
+	ada := &Person{Name: "Ada", Email: "ada@example.com"}
+	id, _, err := bound.Store(ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGenerated(1)
+	if id != generated[0] {
+		t.Errorf("stored with id %s, want the generated %s", id, generated[0])
+	}
+	if _, _, err := bound.Store(ada); err != nil {
+		t.Fatal(err)
+	}
+	assertGenerated(1)
+
+	if _, created, err := bound.GetOrCreate(&Person{Email: "ada@example.com"}, "email"); err != nil || created {
+		t.Fatalf("GetOrCreate of an existing person created %t: %v", created, err)
+	}
+	assertGenerated(1)
+	grace, created, err := bound.GetOrCreate(&Person{Name: "Grace", Email: "grace@example.com"}, "email")
+	if err != nil || !created {
+		t.Fatalf("GetOrCreate of a new person created %t: %v", created, err)
+	}
+	assertGenerated(2)
+	if got := grace.(*Person).Id; got != generated[1] {
+		t.Errorf("created with id %s, want the generated %s", got, generated[1])
+	}
+
+	ids, err := bound.StoreMany([]protoreflect.ProtoMessage{&Person{Name: "Alan"}, &Person{Name: "Edsger"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGenerated(4)
+	if fmt.Sprint(ids) != fmt.Sprint(generated[2:]) {
+		t.Errorf("stored with ids %v, want the generated %v", ids, generated[2:])
+	}
+}
+
+func TestIDGeneratorRejectedByCodec(t *testing.T) {
+	_, bound := newTestStore(t, WithIDGenerator(func() string { return "no-object-id" }))
+	if _, _, err := bound.Store(&Person{Name: "Ada"}); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("storing with an id the codec rejects returned %v, want ErrInvalidID", err)
+	}
+}
+
+func TestIDCodecs(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		codec IDCodec
+		id    string
+		valid func(interface{}) bool
+	}{
+		{"ObjectID", ObjectIDCodec{}, primitive.NewObjectID().Hex(), func(v interface{}) bool {
+			_, ok := v.(primitive.ObjectID)
+			return ok
+		}},
+		{"UUIDString", UUIDStringCodec{}, uuid.NewV4().String(), func(v interface{}) bool {
+			_, ok := v.(string)
+			return ok
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, bound := newTestStore(t, WithIDCodec(c.codec))
+			generated, _, err := bound.Store(&Person{Name: "Ada"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.codec.EncodeToBSON(generated); err != nil {
+				t.Errorf("the generated id %q is invalid for the codec: %v", generated, err)
+			}
+			given, _, err := bound.Store(&Person{Id: c.id, Name: "Grace"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if given != c.id {
+				t.Errorf("stored with id %q, want the given %q", given, c.id)
+			}
+			if doc := rawDoc(t, bound, person, given); !c.valid(doc[fieldID]) {
+				t.Errorf("stored _id %#v", doc[fieldID])
+			}
+
+			got, err := bound.Get(person, given)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, got, &Person{Id: c.id, Name: "Grace"})
+			found, err := bound.Filter(person, Eq("name", "Ada"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 1 || found[0].(*Person).Id != generated {
+				t.Errorf("Filter found %v, want Ada with id %s", found, generated)
+			}
+			if _, err := bound.Get(person, "not-an-id"); !errors.Is(err, ErrInvalidID) {
+				t.Errorf("Get of an invalid id returned %v, want ErrInvalidID", err)
+			}
+		})
+	}
+}
+
+func TestUUIDStringCodec(t *testing.T) {
+	codec := UUIDStringCodec{}
+	id := uuid.NewV4().String()
+	value, err := codec.EncodeToBSON(id)
+	if err != nil || value != id {
+		t.Errorf("encoded %q into %#v, %v", id, value, err)
+	}
+	if decoded, err := codec.DecodeToString(value); err != nil || decoded != id {
+		t.Errorf("decoded %#v into %q, %v", value, decoded, err)
+	}
+	for _, invalid := range []string{"", "abc", primitive.NewObjectID().Hex()} {
+		if _, err := codec.EncodeToBSON(invalid); err == nil {
+			t.Errorf("encoded the invalid id %q", invalid)
+		}
+	}
+	if _, err := codec.DecodeToString(primitive.NewObjectID()); err == nil {
+		t.Error("decoded an ObjectId")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ensureIndex creates the index on the collection, unless this store
+// already did so. The name identifies the index within the collection
+// for this check.
+func (p *BoundProtoStore) ensureIndex(coll *mongo.Collection, name string, index mongo.IndexModel) error {
+	cacheKey := p.user.Realm + "/" + coll.Name() + "/" + name
+	if _, ok := p.protoStore.indexes.Load(cacheKey); ok {
+		return nil
+	}
+	if _, err := coll.Indexes().CreateOne(p.ctx, index); err != nil {
+		return fmt.Errorf("could not create index %s of collection %s: %w", name, coll.Name(), err)
+	}
+	p.protoStore.indexes.Store(cacheKey, true)
+	return nil
+}
+
+// forgetIndexes drops what ensureIndex remembers about the collection,
+// e.g. because it was dropped along with its indexes.
+func (p *BoundProtoStore) forgetIndexes(coll *mongo.Collection) {
+	prefix := p.user.Realm + "/" + coll.Name() + "/"
+	p.protoStore.indexes.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			p.protoStore.indexes.Delete(key)
+		}
+		return true
+	})
+}
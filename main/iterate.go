@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package main
+
+import (
+	"io"
+	"iter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Iterate runs the query of FilterStream and yields its results, e.g.
+//
+//	for msg, err := range store.Iterate(model, Eq("city", "Berlin")) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// An error is yielded once and ends the iteration. This is also how the
+// iteration ends if the context of the store is canceled while it runs.
+// The cursor is closed when the loop ends, breaks or panics.
+func (p *BoundProtoStore) Iterate(model func() protoreflect.ProtoMessage, filters ...bson.D) iter.Seq2[protoreflect.ProtoMessage, error] {
+	return func(yield func(protoreflect.ProtoMessage, error) bool) {
+		stream, err := p.FilterStream(model, filters...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer stream.Close()
+		for {
+			m, err := stream.Next(p.ctx)
+			if err == io.EOF {
+				return
+			}
+			if !yield(m, err) || err != nil {
+				return
+			}
+		}
+	}
+}
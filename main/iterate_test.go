@@ -0,0 +1,93 @@
+//go:build go1.23
+
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestIterate(t *testing.T) {
+	store, bound := newTestStore(t)
+	log := monitorCommands(t, store)
+	seedSamples(t, bound, `{"stringValue": "a"}`, `{"stringValue": "b"}`, `{"stringValue": "c"}`)
+	sorted := bound.With(SortBy("stringValue", true), BatchSize(1))
+
+	var names []string
+	for m, err := range sorted.Iterate(sample) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, stringValues([]protoreflect.ProtoMessage{m})...)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("iterated %v, want %v", names, want)
+	}
+	if n := log.count("killCursors"); n != 0 {
+		t.Errorf("killed %d cursors of an exhausted iteration", n)
+	}
+
+	// the cursor of a loop which breaks early is closed
+	for _, err := range sorted.Iterate(sample) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if n := log.count("killCursors"); n != 1 {
+		t.Errorf("killed %d cursors after the loop broke, want 1", n)
+	}
+
+	// and so is the one of a loop which panics
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic of the loop", r)
+			}
+		}()
+		for range sorted.Iterate(sample) {
+			panic("boom")
+		}
+	}()
+	if n := log.count("killCursors"); n != 2 {
+		t.Errorf("killed %d cursors after the loop panicked, want 2", n)
+	}
+}
+
+func TestIterateErrors(t *testing.T) {
+	store, bound := newTestStore(t)
+	seedSamples(t, bound, `{"stringValue": "a"}`, `{"stringValue": "b"}`, `{"stringValue": "c"}`)
+
+	n := 0
+	for m, err := range bound.Iterate(sample, Eq("nowhere", 1)) {
+		n++
+		if m != nil || !errors.Is(err, ErrUnknownField) {
+			t.Errorf("iterating by an unknown field yielded %v, %v, want ErrUnknownField", m, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("iterating by an unknown field yielded %d times, want once", n)
+	}
+
+	// canceling the context of the store ends the iteration with an error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canceled := store.Bind(ctx, bound.user)
+	var errs []error
+	n = 0
+	for _, err := range canceled.With(BatchSize(1)).Iterate(sample) {
+		n++
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cancel()
+	}
+	if n != 2 || len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("iterating while canceling yielded %d times with errors %v, want a result and context.Canceled", n, errs)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// GetOrCreate returns the document whose key fields equal those of the
+// message, or inserts the message if there is none. The bool tells
+// whether it was created. The key fields are business identifiers like
+// email and have to be set on the message. To make sure concurrent
+// callers end up with a single document, a unique index on the key fields
+// is created on first use. A new id is only generated if no document was
+// found.
+func (p *BoundProtoStore) GetOrCreate(message protoreflect.ProtoMessage, keyFields ...string) (_ protoreflect.ProtoMessage, _ bool, err error) {
+	p, done := p.operation("GetOrCreate", modelOf(message))
+	defer done(&err)
+	md := message.ProtoReflect().Descriptor()
+	doc, err := p.content(message)
+	if err != nil {
+		return nil, false, err
+	}
+	filter, paths, err := keyFilter(md, doc, keyFields)
+	if err != nil {
+		return nil, false, err
+	}
+	coll, err := p.collection(func() protoreflect.ProtoMessage { return message })
+	if err != nil {
+		return nil, false, err
+	}
+	if err := p.ensureUniqueKey(coll, paths); err != nil {
+		return nil, false, err
+	}
+	model := func() protoreflect.ProtoMessage { return message.ProtoReflect().New().Interface() }
+
+	var stored bson.M
+	err = coll.FindOne(p.ctx, append(filter, notDeleted)).Decode(&stored)
+	if err == nil {
+		m, err := p.protoStore.settings.fromDoc(model, stored)
+		if err != nil {
+			return nil, false, err
+		}
+		return m.Message, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, fmt.Errorf("could not read document in collection %s with key %v: %w", coll.Name(), filter, err)
+	}
+
+	if err := p.addMetadata(md.FullName(), doc); err != nil {
+		return nil, false, err
+	}
+	update := bson.D{bson.E{Key: "$setOnInsert", Value: orderedFields(md, doc)}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = coll.FindOneAndUpdate(p.ctx, append(filter, notDeleted), update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent caller inserted the document in between, which is
+		// found now
+		err = coll.FindOneAndUpdate(p.ctx, append(filter, notDeleted), update, opts).Decode(&stored)
+		if mongo.IsDuplicateKeyError(err) && p.isDeleted(coll, filter) {
+			err = ErrDeleted
+		}
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get or create document in collection %s with key %v: %w", coll.Name(), filter, err)
+	}
+	// a concurrent caller may have inserted the document in between
+	created := reflect.DeepEqual(stored[fieldID], doc[fieldID])
+	m, err := p.protoStore.settings.fromDoc(model, stored)
+	if err != nil {
+		return nil, false, err
+	}
+	return m.Message, created, nil
+}
+
+// keyFilter returns a filter matching the values of the key fields in the
+// document. The key fields have to be populated scalar fields. It also
+// returns the paths of the key fields within the documents.
+func keyFilter(md protoreflect.MessageDescriptor, doc map[string]interface{}, keyFields []string) (bson.D, []string, error) {
+	if len(keyFields) == 0 {
+		return nil, nil, errors.New("no key fields given")
+	}
+	filter := bson.D{}
+	paths := make([]string, 0, len(keyFields))
+	for _, keyField := range keyFields {
+		fields, err := resolvePath(md, keyField)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, fd := range fields {
+			if fd.IsList() || fd.IsMap() {
+				return nil, nil, fmt.Errorf("key field %s of %s is repeated", keyField, md.FullName())
+			}
+		}
+		if isMessage(fields[len(fields)-1]) {
+			return nil, nil, fmt.Errorf("key field %s of %s is no scalar", keyField, md.FullName())
+		}
+		path := jsonPath(fields)
+		value, ok := lookupPath(doc, path)
+		if !ok {
+			return nil, nil, fmt.Errorf("key field %s of %s is not set", keyField, md.FullName())
+		}
+		filter = append(filter, bson.E{Key: path, Value: value})
+		paths = append(paths, path)
+	}
+	return filter, paths, nil
+}
+
+// ensureUniqueKey creates a unique index on the paths, unless this store
+// already did so.
+func (p *BoundProtoStore) ensureUniqueKey(coll *mongo.Collection, paths []string) error {
+	keys := bson.D{}
+	for _, path := range paths {
+		keys = append(keys, bson.E{Key: path, Value: 1})
+	}
+	index := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(true)}
+	return p.ensureIndex(coll, "unique:"+strings.Join(paths, ","), index)
+}
+
+// UpsertByKey stores the message onto the document whose key fields equal
+// those of the message, or inserts it if there is none. This is for
+// syncing data from external systems, which know their own ids, but not
+// the ids of the store. The existing id and creation metadata are kept,
+// the id of the message is ignored. It returns the id of the document and
+// whether it was created. The key fields have to be populated scalar
+// fields.
+func (p *BoundProtoStore) UpsertByKey(message protoreflect.ProtoMessage, keyFields ...string) (_ string, _ bool, err error) {
+	p, done := p.operation("UpsertByKey", modelOf(message))
+	defer done(&err)
+	md := message.ProtoReflect().Descriptor()
+	// the driver encodes the document, so it is reused afterwards
+	doc := getDoc()
+	defer putDoc(doc)
+	if err := p.documentInto(message, doc); err != nil {
+		return "", false, err
+	}
+	filter, paths, err := keyFilter(md, doc, keyFields)
+	if err != nil {
+		return "", false, err
+	}
+	coll, err := p.collection(func() protoreflect.ProtoMessage { return message })
+	if err != nil {
+		return "", false, err
+	}
+	if err := p.ensureUniqueKey(coll, paths); err != nil {
+		return "", false, err
+	}
+
+	onInsert := bson.D{
+		bson.E{Key: fieldID, Value: doc[fieldID]},
+		bson.E{Key: fieldCreatedBy, Value: doc[fieldCreatedBy]},
+		bson.E{Key: fieldCreatedAt, Value: doc[fieldCreatedAt]},
+	}
+	delete(doc, fieldID)
+	delete(doc, fieldCreatedBy)
+	delete(doc, fieldCreatedAt)
+	delete(doc, "id")
+	update := bson.D{
+		bson.E{Key: "$set", Value: orderedFields(md, doc)},
+		bson.E{Key: "$setOnInsert", Value: onInsert},
+	}
+	// the stored id is kept like _id, so it is not unset either
+	unset := bson.D{}
+	for _, field := range absentFields(md, doc) {
+		if field.Key != "id" {
+			unset = append(unset, field)
+		}
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{bson.E{Key: fieldID, Value: 1}})
+	var stored bson.M
+	err = coll.FindOneAndUpdate(p.ctx, append(filter, notDeleted), update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent caller inserted the document in between, which is
+		// updated now
+		err = coll.FindOneAndUpdate(p.ctx, append(filter, notDeleted), update, opts).Decode(&stored)
+		if mongo.IsDuplicateKeyError(err) && p.isDeleted(coll, filter) {
+			err = ErrDeleted
+		}
+	}
+	p.invalidateDocs(md.FullName(), stored[fieldID])
+	if err != nil {
+		return "", false, fmt.Errorf("could not upsert document in collection %s with key %v: %w", coll.Name(), filter, err)
+	}
+	created := reflect.DeepEqual(stored[fieldID], onInsert[0].Value)
+	id, err := p.protoStore.settings.setID(message, stored)
+	if err != nil {
+		return "", false, err
+	}
+	return id, created, nil
+}
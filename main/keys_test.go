@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetOrCreate(t *testing.T) {
+	_, bound := newTestStore(t)
+	ada, created, err := bound.GetOrCreate(&Person{Name: "Ada", Email: "ada@example.com"}, "email")
+	if err != nil || !created {
+		t.Fatalf("GetOrCreate of a new person created %t: %v", created, err)
+	}
+	id := ada.(*Person).Id
+	if id == "" {
+		t.Fatal("the created person has no id")
+	}
+	got, created, err := bound.GetOrCreate(&Person{Name: "Ada Lovelace", Email: "ada@example.com"}, "email")
+	if err != nil || created {
+		t.Fatalf("GetOrCreate of an existing person created %t: %v", created, err)
+	}
+	// the stored person is returned, not the message
+	assertEqual(t, got, &Person{Id: id, Name: "Ada", Email: "ada@example.com"})
+	if n, err := bound.Count(person); err != nil || n != 1 {
+		t.Errorf("stored %d persons: %v", n, err)
+	}
+}
+
+func TestGetOrCreateInvalidKey(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, keys := range [][]string{nil, {"unknown"}, {"id"}, {"phones"}, {"phones.number"}} {
+		if _, _, err := bound.GetOrCreate(&Person{Name: "Ada", Phones: []*Person_PhoneNumber{{Number: "1"}}}, keys...); err == nil {
+			t.Errorf("GetOrCreate with the key fields %v succeeded", keys)
+		}
+	}
+	if n, err := bound.Count(person); err != nil || n != 0 {
+		t.Errorf("stored %d persons with invalid keys: %v", n, err)
+	}
+}
+
+func TestGetOrCreateConcurrent(t *testing.T) {
+	_, bound := newTestStore(t)
+	const callers = 20
+	var created int32
+	ids := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, ok, err := bound.GetOrCreate(&Person{Name: "Ada", Email: "ada@example.com"}, "email")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				atomic.AddInt32(&created, 1)
+			}
+			ids[i] = m.(*Person).Id
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if created != 1 {
+		t.Errorf("%d concurrent callers created the person", created)
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("the callers got the ids %v", ids)
+		}
+	}
+	if n, err := bound.Count(person); err != nil || n != 1 {
+		t.Errorf("stored %d persons: %v", n, err)
+	}
+}
+
+func TestUpsertByKey(t *testing.T) {
+	_, bound := newTestStore(t)
+	ada := &Person{Name: "Ada", Email: "ada@example.com"}
+	id, created, err := bound.UpsertByKey(ada, "email")
+	if err != nil || !created {
+		t.Fatalf("UpsertByKey of a new person created %t: %v", created, err)
+	}
+	if id == "" || ada.Id != id {
+		t.Fatalf("UpsertByKey returned the id %q and set %q", id, ada.Id)
+	}
+	before := rawDoc(t, bound, person, id)
+
+	// the id of the message is ignored, the one of the document is kept
+	other := otherUser(bound)
+	lovelace := &Person{Id: primitive.NewObjectID().Hex(), Name: "Ada Lovelace", Email: "ada@example.com"}
+	got, created, err := other.UpsertByKey(lovelace, "email")
+	if err != nil || created {
+		t.Fatalf("UpsertByKey of an existing person created %t: %v", created, err)
+	}
+	if got != id || lovelace.Id != id {
+		t.Errorf("UpsertByKey returned the id %q and set %q, want %q", got, lovelace.Id, id)
+	}
+	after := rawDoc(t, bound, person, id)
+	for _, field := range []string{fieldID, fieldCreatedBy, fieldCreatedAt} {
+		if !reflect.DeepEqual(after[field], before[field]) {
+			t.Errorf("UpsertByKey changed %s from %v to %v", field, before[field], after[field])
+		}
+	}
+	stored, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, stored, &Person{Id: id, Name: "Ada Lovelace", Email: "ada@example.com"})
+	if n, err := bound.Count(person); err != nil || n != 1 {
+		t.Errorf("stored %d persons: %v", n, err)
+	}
+}
+
+func TestUpsertByKeyKeepsStoredID(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Id: primitive.NewObjectID().Hex(), Name: "Ada", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bound.UpsertByKey(&Person{Name: "Ada Lovelace", Email: "ada@example.com"}, "email"); err != nil {
+		t.Fatal(err)
+	}
+	if doc := rawDoc(t, bound, person, id); doc["id"] != id {
+		t.Errorf("UpsertByKey stored the id field %v, want %s", doc["id"], id)
+	}
+}
+
+func TestUpsertByKeyInvalidKey(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, keys := range [][]string{nil, {"unknown"}, {"email"}, {"phones"}, {"phones.number"}} {
+		if _, _, err := bound.UpsertByKey(&Person{Name: "Ada", Phones: []*Person_PhoneNumber{{Number: "1"}}}, keys...); err == nil {
+			t.Errorf("UpsertByKey with the key fields %v succeeded", keys)
+		}
+	}
+	if n, err := bound.Count(person); err != nil || n != 0 {
+		t.Errorf("stored %d persons with invalid keys: %v", n, err)
+	}
+}
+
+func TestUpsertByKeyConcurrent(t *testing.T) {
+	_, bound := newTestStore(t)
+	const callers = 20
+	var created int32
+	ids := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, ok, err := bound.UpsertByKey(&Person{Name: "Ada", Email: "ada@example.com"}, "email")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				atomic.AddInt32(&created, 1)
+			}
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if created != 1 {
+		t.Errorf("%d concurrent callers created the person", created)
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("the callers got the ids %v", ids)
+		}
+	}
+	if n, err := bound.Count(person); err != nil || n != 1 {
+		t.Errorf("stored %d persons: %v", n, err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+)
+
+// Logger receives the diagnostic messages of the store, see WithLogger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// StdLogger returns a Logger writing to l, with the level as prefix of
+// every message.
+func StdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) {
+	s.l.Printf("DEBUG "+format, args...)
+}
+
+func (s stdLogger) Warnf(format string, args ...interface{}) {
+	s.l.Printf("WARN "+format, args...)
+}
+
+// nopLogger discards all messages, which is the default.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+
+func (nopLogger) Warnf(string, ...interface{}) {}
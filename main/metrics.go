@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Metrics receives measurements of the store, e.g. to export them for
+// alerting, see WithMetrics. The methods are called concurrently by the
+// driver and must not block.
+type Metrics interface {
+	// ConnectionsInUse reports how many connections to the server at the
+	// address are checked out of the pool, whenever that changes. Once it
+	// reaches the max pool size, operations queue for a connection.
+	ConnectionsInUse(address string, n int)
+	// PoolExhausted reports that an operation got no connection to the
+	// server at the address in time, because all of them were in use.
+	PoolExhausted(address string)
+	// Retried reports that the operation, like find, count or store, is
+	// retried after the transient error, see WithRetry.
+	Retried(operation string, err error)
+	// CacheHit and CacheMiss report whether a document of the collection
+	// was found in the cache, see WithCache.
+	CacheHit(collection string)
+	CacheMiss(collection string)
+}
+
+// nopMetrics discards all measurements, which is the default.
+type nopMetrics struct{}
+
+func (nopMetrics) ConnectionsInUse(string, int) {}
+
+func (nopMetrics) PoolExhausted(string) {}
+
+func (nopMetrics) Retried(string, error) {}
+
+func (nopMetrics) CacheHit(string) {}
+
+func (nopMetrics) CacheMiss(string) {}
+
+// poolMonitor reports the events of the connection pools of the driver to
+// the metrics.
+type poolMonitor struct {
+	metrics Metrics
+	mu      sync.Mutex
+	inUse   map[string]int
+}
+
+func newPoolMonitor(metrics Metrics) *event.PoolMonitor {
+	m := &poolMonitor{metrics: metrics, inUse: map[string]int{}}
+	return &event.PoolMonitor{Event: m.event}
+}
+
+func (m *poolMonitor) event(e *event.PoolEvent) {
+	switch e.Type {
+	case event.GetSucceeded:
+		m.report(e.Address, 1)
+	case event.ConnectionReturned:
+		m.report(e.Address, -1)
+	case event.GetFailed:
+		if e.Reason == event.ReasonTimedOut {
+			m.metrics.PoolExhausted(e.Address)
+		}
+	}
+}
+
+func (m *poolMonitor) report(address string, delta int) {
+	m.mu.Lock()
+	m.inUse[address] += delta
+	n := m.inUse[address]
+	m.mu.Unlock()
+	m.metrics.ConnectionsInUse(address, n)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MigrationError is returned by MigrateCollection if some documents could
+// not be migrated. They are left in the old collection, all others were
+// migrated nonetheless.
+type MigrationError struct {
+	// Failed maps the id of a document to why it was not migrated.
+	Failed map[string]error
+}
+
+func (e *MigrationError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	msgs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", id, e.Failed[id]))
+	}
+	return fmt.Sprintf("%d documents could not be migrated: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+// MigrateCollection moves the documents of a renamed message from the
+// collection of its old full name to the collection of the model within
+// the realm of the user, and returns how many were moved. The type field
+// is rewritten to the new name, the version of the schema is kept.
+// Soft-deleted documents are moved as well.
+//
+// Every document is checked to decode into the model before it is moved.
+// Those that do not are left in the old collection and reported by a
+// *MigrationError. A migration that was interrupted can be run again: the
+// moved documents are removed from the old collection one by one, and
+// documents already in the new collection are not overwritten. If such a
+// document has another type or creation metadata, it is not the moved one:
+// the document is left in the old collection and reported, too.
+func (p *BoundProtoStore) MigrateCollection(oldFullName string, model func() protoreflect.ProtoMessage) (_ int64, err error) {
+	p, done := p.operation("MigrateCollection", model)
+	defer done(&err)
+	to, err := p.collection(model)
+	if err != nil {
+		return 0, err
+	}
+	if oldFullName == to.Name() {
+		return 0, fmt.Errorf("could not migrate collection %s onto itself", oldFullName)
+	}
+	from := p.db(p.user.Realm).Collection(oldFullName)
+	defer p.invalidateCollection(protoreflect.FullName(to.Name()))
+
+	cursor, err := from.Find(p.ctx, bson.D{})
+	if err != nil {
+		return 0, fmt.Errorf("could not read collection %s: %w", oldFullName, err)
+	}
+	defer cursor.Close(p.ctx)
+
+	migrationErr := &MigrationError{Failed: map[string]error{}}
+	var migrated int64
+	for cursor.Next(p.ctx) {
+		// fromDoc changes the document it decodes, so the check gets a
+		// copy of its own
+		var doc, check bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, fmt.Errorf("could not decode document of collection %s: %w", oldFullName, err)
+		}
+		if err := cursor.Decode(&check); err != nil {
+			return migrated, fmt.Errorf("could not decode document of collection %s: %w", oldFullName, err)
+		}
+		id := fmt.Sprint(sanitize(doc[fieldID]))
+
+		if _, err := p.protoStore.settings.fromDoc(model, check); err != nil {
+			migrationErr.Failed[id] = err
+			continue
+		}
+		version := 1
+		if t, ok := doc[fieldType].(string); ok {
+			if _, v, err := parseTypeValue(t); err == nil {
+				version = v
+			}
+		}
+		doc[fieldType] = formatTypeValue(protoreflect.FullName(to.Name()), version)
+
+		// a duplicate key may mean the document was moved by an interrupted
+		// run, which did not get to remove it from the old collection
+		if _, err := to.InsertOne(p.ctx, doc); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				err = p.checkMoved(to, doc, err)
+			}
+			if err != nil {
+				migrationErr.Failed[id] = fmt.Errorf("could not insert into collection %s: %w", to.Name(), err)
+				continue
+			}
+		}
+		if _, err := from.DeleteOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: doc[fieldID]}}); err != nil {
+			migrationErr.Failed[id] = fmt.Errorf("could not remove from collection %s: %w", oldFullName, err)
+			continue
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return migrated, fmt.Errorf("could not read collection %s: %w", oldFullName, err)
+	}
+	if len(migrationErr.Failed) > 0 {
+		return migrated, migrationErr
+	}
+	return migrated, nil
+}
+
+// checkMoved tells whether the document of collection to with the id of
+// doc is doc, moved there by an earlier run, after inserting doc failed
+// with the duplicate key error insertErr. The moved document may have
+// been updated since, so only its type and creation metadata have to
+// match. Otherwise, the old document must not be removed.
+func (p *BoundProtoStore) checkMoved(to *mongo.Collection, doc bson.M, insertErr error) error {
+	var moved bson.M
+	err := to.FindOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: doc[fieldID]}}).Decode(&moved)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the duplicate key is the one of another unique index
+		return insertErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not read the document with the same id: %w", err)
+	}
+	if name, _, err := parseTypeValue(fmt.Sprint(moved[fieldType])); err != nil || name != protoreflect.FullName(to.Name()) {
+		return fmt.Errorf("another document with the same id has the type %v", moved[fieldType])
+	}
+	for _, field := range []string{fieldCreatedBy, fieldCreatedAt} {
+		if !reflect.DeepEqual(moved[field], doc[field]) {
+			return fmt.Errorf("another document with the same id has the %s %v instead of %v", field, moved[field], doc[field])
+		}
+	}
+	return nil
+}
+
+// systemDatabases are the databases of the server itself, which are no
+// realms.
+var systemDatabases = bson.A{"admin", "config", "local"}
+
+// MigrateCollection runs MigrateCollection of BoundProtoStore in every
+// realm that has a collection of the old full name, and returns how many
+// documents were moved in total. The ids of the documents reported by a
+// *MigrationError are prefixed with their realm, e.g. acme/<id>.
+func (p *ProtoStore) MigrateCollection(ctx context.Context, oldFullName string, model func() protoreflect.ProtoMessage) (int64, error) {
+	if err := p.checkOpen(); err != nil {
+		return 0, err
+	}
+	filter := bson.D{bson.E{Key: "name", Value: bson.D{bson.E{Key: "$nin", Value: systemDatabases}}}}
+	realms, err := p.client.ListDatabaseNames(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("could not list databases: %w", err)
+	}
+	sort.Strings(realms)
+
+	migrationErr := &MigrationError{Failed: map[string]error{}}
+	var migrated int64
+	for _, realm := range realms {
+		names, err := p.client.Database(realm).ListCollectionNames(ctx, bson.D{bson.E{Key: "name", Value: oldFullName}})
+		if err != nil {
+			return migrated, fmt.Errorf("could not list collections of database %s: %w", realm, err)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		store := p.Bind(ctx, &User{Realm: realm})
+		n, err := store.MigrateCollection(oldFullName, model)
+		migrated += n
+		var realmErr *MigrationError
+		switch {
+		case err == nil:
+		case errors.As(err, &realmErr):
+			for id, cause := range realmErr.Failed {
+				migrationErr.Failed[realm+"/"+id] = cause
+			}
+		default:
+			return migrated, fmt.Errorf("could not migrate realm %s: %w", realm, err)
+		}
+	}
+	if len(migrationErr.Failed) > 0 {
+		return migrated, migrationErr
+	}
+	return migrated, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// oldPerson is the collection of Person before it was renamed.
+const oldPerson = "old.Person"
+
+// storeAsOld stores the person and moves its document into the collection
+// oldPerson, as if it was stored before the message was renamed. It returns
+// the id and the document.
+func storeAsOld(t *testing.T, bound *BoundProtoStore, p *Person) (string, bson.M) {
+	t.Helper()
+	id, _, err := bound.Store(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := rawDoc(t, bound, person, id)
+	doc[fieldType] = formatTypeValue(oldPerson, 1)
+	db := bound.db(bound.user.Realm)
+	if _, err := db.Collection(oldPerson).InsertOne(bound.ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Collection("main.Person").DeleteOne(bound.ctx, bson.D{bson.E{Key: fieldID, Value: doc[fieldID]}}); err != nil {
+		t.Fatal(err)
+	}
+	return id, doc
+}
+
+func countOld(t *testing.T, bound *BoundProtoStore) int64 {
+	t.Helper()
+	n, err := bound.db(bound.user.Realm).Collection(oldPerson).CountDocuments(bound.ctx, bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMigrateCollection(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _ := storeAsOld(t, bound, &Person{Name: "Ada"})
+	n, err := bound.MigrateCollection(oldPerson, person)
+	if err != nil || n != 1 {
+		t.Fatalf("migrated %d documents: %v", n, err)
+	}
+	got, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, &Person{Id: id, Name: "Ada"})
+	if typ := rawDoc(t, bound, person, id)[fieldType]; typ != "main.Person:1" {
+		t.Errorf("migrated the type %v", typ)
+	}
+	if n := countOld(t, bound); n != 0 {
+		t.Errorf("left %d documents in the old collection", n)
+	}
+}
+
+func TestMigrateCollectionMovedBefore(t *testing.T) {
+	_, bound := newTestStore(t)
+	// an interrupted run moved the document, which was updated since
+	id, doc := storeAsOld(t, bound, &Person{Name: "Ada"})
+	if _, err := bound.db(bound.user.Realm).Collection("main.Person").InsertOne(bound.ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bound.Store(&Person{Id: id, Name: "Ada Lovelace"}); err != nil {
+		t.Fatal(err)
+	}
+	// another document has the id of one which was not moved
+	otherID, other := storeAsOld(t, bound, &Person{Name: "Grace"})
+	other[fieldCreatedAt] = primitive.NewDateTimeFromTime(time.Now().Add(-time.Hour))
+	if _, err := bound.db(bound.user.Realm).Collection("main.Person").InsertOne(bound.ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := bound.MigrateCollection(oldPerson, person)
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || len(migrationErr.Failed) != 1 || migrationErr.Failed[otherID] == nil {
+		t.Fatalf("migration returned %v, want a MigrationError for %s", err, otherID)
+	}
+	if n != 1 {
+		t.Errorf("migrated %d documents, want 1", n)
+	}
+	got, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, &Person{Id: id, Name: "Ada Lovelace"})
+	if n := countOld(t, bound); n != 1 {
+		t.Errorf("left %d documents in the old collection, want the one of %s", n, otherID)
+	}
+}
@@ -0,0 +1,64 @@
+// Code generated by protofields. DO NOT EDIT.
+
+package main
+
+// PersonFields references the fields of main.Person in filters.
+var PersonFields = newPersonFields("")
+
+// Person_PhoneNumberFields references the fields of main.Person.PhoneNumber in filters.
+var Person_PhoneNumberFields = newPerson_PhoneNumberFields("")
+
+// AddressBookFields references the fields of main.AddressBook in filters.
+var AddressBookFields = newAddressBookFields("")
+
+type personFields struct {
+	Name   Field
+	Id     Field
+	Email  Field
+	Phones person_PhoneNumberFieldsList
+}
+
+func newPersonFields(prefix string) personFields {
+	return personFields{
+		Name:   Field(prefix + "name"),
+		Id:     Field(prefix + "id"),
+		Email:  Field(prefix + "email"),
+		Phones: person_PhoneNumberFieldsList{ListField: ListField{Field(prefix + "phones")}, person_PhoneNumberFields: newPerson_PhoneNumberFields(prefix + "phones" + "."), Elem: newPerson_PhoneNumberFields("")},
+	}
+}
+
+type person_PhoneNumberFields struct {
+	Number Field
+	Type   Field
+}
+
+func newPerson_PhoneNumberFields(prefix string) person_PhoneNumberFields {
+	return person_PhoneNumberFields{
+		Number: Field(prefix + "number"),
+		Type:   Field(prefix + "type"),
+	}
+}
+
+type addressBookFields struct {
+	People personFieldsList
+}
+
+func newAddressBookFields(prefix string) addressBookFields {
+	return addressBookFields{
+		People: personFieldsList{ListField: ListField{Field(prefix + "people")}, personFields: newPersonFields(prefix + "people" + "."), Elem: newPersonFields("")},
+	}
+}
+
+// personFieldsList references a repeated main.Person field.
+type personFieldsList struct {
+	ListField
+	personFields
+	Elem personFields
+}
+
+// person_PhoneNumberFieldsList references a repeated main.Person.PhoneNumber field.
+type person_PhoneNumberFieldsList struct {
+	ListField
+	person_PhoneNumberFields
+	Elem person_PhoneNumberFields
+}
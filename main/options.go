@@ -0,0 +1,637 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"google.golang.org/protobuf/encoding/protojson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// StoreMode decides how Store writes a message onto an existing document.
+type StoreMode int
+
+const (
+	// Merge sets all fields of the message and removes the fields of the
+	// message which are unset. Fields of the document the message does not
+	// know about, e.g. written by an older schema version or other writers,
+	// are kept.
+	Merge StoreMode = iota
+	// Replace replaces the whole document with the message. Only the
+	// metadata of the store (_id, type, createdBy and createdAt) is kept,
+	// all other fields the message does not know about are dropped.
+	Replace
+)
+
+// StoreOption configures a single call to Store.
+type StoreOption func(*storeConfig)
+
+type storeConfig struct {
+	mode    StoreMode
+	restore bool
+}
+
+// WithMode sets how the message is written onto an existing document.
+// The default is Merge.
+func WithMode(mode StoreMode) StoreOption {
+	return func(c *storeConfig) {
+		c.mode = mode
+	}
+}
+
+// WithRestore allows Store to overwrite a soft-deleted document, which
+// is visible again afterwards.
+func WithRestore() StoreOption {
+	return func(c *storeConfig) {
+		c.restore = true
+	}
+}
+
+func newStoreConfig(opts []StoreOption) storeConfig {
+	c := storeConfig{mode: Merge}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Option configures a ProtoStore on construction.
+type Option func(*settings)
+
+type settings struct {
+	enumAsNumber   bool
+	resolver       TypeResolver
+	idempotencyTTL time.Duration
+	idCodec        IDCodec
+	idGenerator    func() string
+	// skipFilterValidation disables checking the fields of filters
+	// against the message descriptor
+	skipFilterValidation bool
+	// geoFields are the json paths of the fields stored as GeoJSON
+	geoFields map[string]bool
+	// maxResults caps the results of queries without a Limit
+	maxResults int64
+	// cursorSecret signs the tokens of FilterAfter
+	cursorSecret []byte
+	logger       Logger
+	// typeVersions are the versions of the schemas messages are written
+	// with, by their full names
+	typeVersions map[protoreflect.FullName]int
+	// defaultMaxTime bounds queries without a MaxTime
+	defaultMaxTime time.Duration
+	// defaultBatchSize applies to queries without a BatchSize
+	defaultBatchSize int32
+	// directConversion converts messages without the detour over json
+	directConversion bool
+	// protoCodec registers a codec for messages with the client
+	protoCodec bool
+	// decodeConcurrency is how many documents of a result are decoded at
+	// once
+	decodeConcurrency int
+	// the options of the connection pool, unset ones are left to the
+	// connection string and the driver
+	maxPoolSize            *uint64
+	minPoolSize            *uint64
+	maxConnIdleTime        time.Duration
+	connectTimeout         time.Duration
+	serverSelectionTimeout time.Duration
+	metrics                Metrics
+	// connectAttempts and connectBackoff configure how NewProtoStore
+	// waits for the database, zero attempts do not wait at all
+	connectAttempts int
+	connectBackoff  time.Duration
+	// lazyConnect waits for the database on the first operation instead
+	lazyConnect bool
+	// readPref applies to queries without ReadFromSecondary or
+	// ReadFromPrimary
+	readPref *readpref.ReadPref
+	// writeConcern applies to writes without a WriteConcern
+	writeConcern *writeconcern.WriteConcern
+	// causalConsistency runs the operations of a bound store in a session
+	causalConsistency bool
+	// retryAttempts and retryBackoff configure how idempotent operations
+	// are retried, up to one attempt does not retry at all
+	retryAttempts int
+	retryBackoff  time.Duration
+	// breakerThreshold and breakerCooldown configure the circuit breaker,
+	// no threshold disables it
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	// singleflight shares the queries of concurrent Gets
+	singleflight bool
+	// cacheEntries and cacheTTL configure the cache of documents read by
+	// id, no entries disable it
+	cacheEntries int
+	cacheTTL     time.Duration
+	// defaultTimeout bounds operations whose context has no deadline
+	defaultTimeout time.Duration
+	// compressors compress the traffic with the database, in the order
+	// of preference
+	compressors []string
+	// slowQueryThreshold is the duration above which operations are
+	// logged, zero disables the log
+	slowQueryThreshold time.Duration
+	slowQueryValues    bool
+}
+
+// TypeResolver resolves the message types of google.protobuf.Any fields,
+// e.g. a *protoregistry.Types.
+type TypeResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// WithEnumAsNumber stores enums by their numeric value instead of their
+// name. This keeps documents small and survives renaming enum values.
+// Reading accepts both representations regardless of this option.
+func WithEnumAsNumber() Option {
+	return func(s *settings) {
+		s.enumAsNumber = true
+	}
+}
+
+// WithTypeResolver sets where the message types of google.protobuf.Any
+// fields are looked up, both when storing and reading. The default is the
+// global registry, which only knows the types linked into the binary.
+func WithTypeResolver(resolver TypeResolver) Option {
+	return func(s *settings) {
+		s.resolver = resolver
+	}
+}
+
+// WithIdempotencyTTL sets how long the keys of StoreIdempotent are kept.
+// The default is 24 hours. The expiry is applied when the index of the
+// keys of a realm is created, changing it later has no effect on
+// existing realms.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *settings) {
+		s.idempotencyTTL = ttl
+	}
+}
+
+// WithIDCodec sets how the ids of messages are stored as _id of the
+// documents. The default is ObjectIDCodec.
+func WithIDCodec(codec IDCodec) Option {
+	return func(s *settings) {
+		s.idCodec = codec
+	}
+}
+
+// WithIDGenerator sets how the ids of new documents are generated, e.g.
+// as ULIDs or prefixed ids. The ids have to be accepted by the IDCodec. By
+// default, the codec generates them if it knows how, otherwise they are
+// ObjectId hex strings.
+func WithIDGenerator(generate func() string) Option {
+	return func(s *settings) {
+		s.idGenerator = generate
+	}
+}
+
+// WithoutFilterValidation disables checking that the fields referenced by
+// filters exist on the message, which fails with ErrUnknownField
+// otherwise. Use it to query fields that are not part of the message,
+// e.g. written by other tools. The metadata fields of the store are
+// always allowed.
+func WithoutFilterValidation() Option {
+	return func(s *settings) {
+		s.skipFilterValidation = true
+	}
+}
+
+// WithGeoField stores the field as a GeoJSON point, so it can be indexed
+// with EnsureGeoIndex and queried with NearSphere and WithinPolygon. The
+// field is the json path of a message field with a latitude and a
+// longitude, e.g. location or address.location, and applies to all
+// messages which have such a field. Pass it once per field.
+func WithGeoField(field string) Option {
+	return func(s *settings) {
+		if s.geoFields == nil {
+			s.geoFields = map[string]bool{}
+		}
+		s.geoFields[field] = true
+	}
+}
+
+// WithMaxResults sets how many documents Filter and All return at most
+// if the query sets no Limit. If more documents match, the first max
+// ones are returned along with an error wrapping ErrResultTruncated. The
+// default is 10000, zero disables the cap. Single queries lift it with
+// Unlimited.
+func WithMaxResults(max int64) Option {
+	return func(s *settings) {
+		s.maxResults = max
+	}
+}
+
+// WithCursorSecret sets the key the tokens of FilterAfter are signed with,
+// so they can not be forged. By default, a random key is generated per
+// store, so a token is only accepted by the store that issued it. Set the
+// same secret on all instances of a service to accept the tokens of each
+// other.
+func WithCursorSecret(secret []byte) Option {
+	return func(s *settings) {
+		s.cursorSecret = secret
+	}
+}
+
+// WithTypeVersion sets the version of the schema the messages of the full
+// name are written with, which is part of the type field of the documents.
+// The default is 1. Bump it on incompatible changes of the message, so
+// the documents written before can be told apart, see WithTypeVersions
+// and StoredMessage.
+func WithTypeVersion(name protoreflect.FullName, version int) Option {
+	return func(s *settings) {
+		if s.typeVersions == nil {
+			s.typeVersions = map[protoreflect.FullName]int{}
+		}
+		s.typeVersions[name] = version
+	}
+}
+
+// WithDefaultMaxTime aborts queries on the server after the duration, if
+// they do not set a MaxTime of their own. By default, they run until they
+// are done or their context is canceled.
+func WithDefaultMaxTime(d time.Duration) Option {
+	return func(s *settings) {
+		s.defaultMaxTime = d
+	}
+}
+
+// WithDefaultBatchSize sets how many documents are fetched from the server
+// at once, if queries do not set a BatchSize of their own. By default,
+// the server decides.
+func WithDefaultBatchSize(n int32) Option {
+	return func(s *settings) {
+		s.defaultBatchSize = n
+	}
+}
+
+// WithDirectConversion converts messages to documents and back by walking
+// them with protoreflect, instead of encoding them to json and decoding
+// the json. This saves allocations and time, especially for large
+// repeated fields. The documents are the same, so stores with and without
+// the option can share a database.
+func WithDirectConversion() Option {
+	return func(s *settings) {
+		s.directConversion = true
+	}
+}
+
+// WithProtoCodec registers a codec for proto messages with the client of
+// the store, so messages can be passed to the driver directly, e.g. as
+// the value of a $set, and results decoded into them with Decode of a
+// cursor from Client. The codec converts them like the store does, so
+// documents written either way can be read either way. It only covers the
+// fields of the messages: the metadata of the store, like the type and
+// the creator, is not written, and the id field is read from the _id.
+func WithProtoCodec() Option {
+	return func(s *settings) {
+		s.protoCodec = true
+	}
+}
+
+// WithDecodeConcurrency sets how many goroutines decode the documents of
+// the results of Filter and the like into messages, which dominates the
+// latency of large results. The results keep their order. The default
+// is 1, which decodes them one after the other while they are read.
+func WithDecodeConcurrency(n int) Option {
+	return func(s *settings) {
+		s.decodeConcurrency = n
+	}
+}
+
+// WithReadPreference sets which members of the replica set queries read
+// from, unless they set ReadFromSecondary or ReadFromPrimary. The default
+// is the read preference of the connection string, which defaults to the
+// primary. Reads from secondaries may miss recent writes.
+func WithReadPreference(rp *readpref.ReadPref) Option {
+	return func(s *settings) {
+		s.readPref = rp
+	}
+}
+
+// WithWriteConcern sets how many members of the replica set have to
+// acknowledge writes, and whether they have to be journaled, unless the
+// call sets a WriteConcern. The default is the write concern of the
+// connection string, which defaults to that of the server. If the
+// acknowledgements do not arrive within the wtimeout of the write
+// concern, the write fails with ErrWriteConcernTimeout.
+func WithWriteConcern(wc *writeconcern.WriteConcern) Option {
+	return func(s *settings) {
+		s.writeConcern = wc
+	}
+}
+
+// WithLogger sets where the store logs to. By default, it does not log.
+func WithLogger(logger Logger) Option {
+	return func(s *settings) {
+		s.logger = logger
+	}
+}
+
+func newSettings(opts []Option) settings {
+	s := settings{
+		idempotencyTTL: 24 * time.Hour,
+		idCodec:        ObjectIDCodec{},
+		maxResults:     10000,
+		logger:         nopLogger{},
+		metrics:        nopMetrics{},
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.cursorSecret == nil {
+		s.cursorSecret = make([]byte, 32)
+		if _, err := rand.Read(s.cursorSecret); err != nil {
+			panic(fmt.Sprintf("could not generate the cursor secret: %v", err))
+		}
+	}
+	return s
+}
+
+// marshalOptions returns how messages are encoded to json, before they
+// are converted into documents.
+func (s *settings) marshalOptions() protojson.MarshalOptions {
+	return protojson.MarshalOptions{
+		UseEnumNumbers: s.enumAsNumber,
+		Resolver:       s.resolver,
+	}
+}
+
+// unmarshalOptions returns how json is decoded into messages, after the
+// documents were converted.
+func (s *settings) unmarshalOptions() protojson.UnmarshalOptions {
+	return protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+		Resolver:       s.resolver,
+	}
+}
+
+// QueryOption configures the queries of a BoundProtoStore, see
+// BoundProtoStore.With.
+type QueryOption func(*queryConfig)
+
+type queryConfig struct {
+	includeDeleted  bool
+	mineOnly        bool
+	typeVersions    []int
+	sort            bson.D
+	collation       *options.Collation
+	limit           *int64
+	skip            int64
+	projection      []string
+	maxTime         time.Duration
+	hint            string
+	comment         string
+	batchSize       int32
+	noCursorTimeout bool
+	unlimited       bool
+	readPref        *readpref.ReadPref
+	writeConcern    *writeconcern.WriteConcern
+}
+
+// IncludeDeleted makes soft-deleted documents visible to queries.
+func IncludeDeleted() QueryOption {
+	return func(c *queryConfig) {
+		c.includeDeleted = true
+	}
+}
+
+// MineOnly restricts queries to the documents created by the bound user,
+// so e.g. Get does not find the documents of other users. Like
+// IncludeDeleted, it applies to reads and FindAndUpdate, but not to the
+// updates and deletes by id. DeleteMany, DeleteAll and Purge only remove
+// the documents of the bound user as well.
+func MineOnly() QueryOption {
+	return func(c *queryConfig) {
+		c.mineOnly = true
+	}
+}
+
+// WithTypeVersions restricts queries to the documents written with one of
+// the versions of the schema, see WithTypeVersion. By default, documents
+// of all versions are read.
+func WithTypeVersions(versions ...int) QueryOption {
+	return func(c *queryConfig) {
+		c.typeVersions = versions
+	}
+}
+
+// SortBy sorts the results by the field. Pass it several times to sort
+// by several fields, the first one taking precedence. The field may be a
+// nested path like address.city, or one of the metadata fields MetaID
+// and MetaUpdatedAt. Documents equal in all fields are sorted by id.
+func SortBy(field string, ascending bool) QueryOption {
+	return func(c *queryConfig) {
+		direction := -1
+		if ascending {
+			direction = 1
+		}
+		c.sort = append(c.sort[:len(c.sort):len(c.sort)], bson.E{Key: field, Value: direction})
+	}
+}
+
+// Limit returns at most n documents. Zero means no limit, which also
+// lifts the cap of WithMaxResults.
+func Limit(n int64) QueryOption {
+	return func(c *queryConfig) {
+		c.limit = &n
+	}
+}
+
+// Unlimited lifts the cap of WithMaxResults, for callers who really want
+// all documents of a query, however many there are. Consider FilterStream
+// for large results instead.
+func Unlimited() QueryOption {
+	return func(c *queryConfig) {
+		c.unlimited = true
+	}
+}
+
+// Skip leaves out the first n documents.
+func Skip(n int64) QueryOption {
+	return func(c *queryConfig) {
+		c.skip = n
+	}
+}
+
+// ReadFromSecondary reads from a secondary of the replica set, to take
+// load like analytics off the primary. Secondaries replicate with a delay,
+// so the results may miss recent writes, also those of the same request.
+// If no secondary is available, the primary is read. It applies to the
+// reads, like Filter, Count and Aggregate, writes always go to the
+// primary.
+func ReadFromSecondary() QueryOption {
+	return func(c *queryConfig) {
+		c.readPref = readpref.SecondaryPreferred()
+	}
+}
+
+// ReadFromPrimary reads from the primary, which sees all writes
+// acknowledged before. Use it to read your own writes if the store reads
+// from secondaries by default, see WithReadPreference.
+func ReadFromPrimary() QueryOption {
+	return func(c *queryConfig) {
+		c.readPref = readpref.Primary()
+	}
+}
+
+// WriteConcern overrides the write concern of the store, see
+// WithWriteConcern, e.g. to write a financial record durably with
+// writeconcern.New(writeconcern.WMajority(), writeconcern.J(true)), or
+// telemetry without waiting for it with writeconcern.New(writeconcern.W(0)).
+// It applies to the writes, like Store, StoreMany and Delete. Without
+// acknowledgements, Store can not tell whether it created the document,
+// the updates and deletes of a single document can not tell whether
+// there was one, and Increment returns 0, as the new value is unknown.
+func WriteConcern(wc *writeconcern.WriteConcern) QueryOption {
+	return func(c *queryConfig) {
+		c.writeConcern = wc
+	}
+}
+
+// Project only reads the fields from the database, which may be nested
+// paths like address.city. The other fields of the returned messages are
+// unset, which proto3 can not tell apart from fields that are unset in
+// the database. See ProjectionMask for the fields that were read. The id
+// is always read.
+func Project(fields ...string) QueryOption {
+	return func(c *queryConfig) {
+		c.projection = append(c.projection[:len(c.projection):len(c.projection)], fields...)
+	}
+}
+
+// WithCollation compares strings by the rules of the locale, e.g. "en",
+// for filtering and sorting. A strength of 1 or 2 ignores case, so
+// Eq("email", "Foo@Bar.com") matches foo@bar.com. An index is only used
+// for such queries if it was created with the same collation.
+func WithCollation(locale string, strength int) QueryOption {
+	return func(c *queryConfig) {
+		c.collation = &options.Collation{Locale: locale, Strength: strength}
+	}
+}
+
+// MaxTime aborts queries on the server after the duration, so a query
+// which misses an index can not pin the database. It overrides the
+// default of WithDefaultMaxTime.
+func MaxTime(d time.Duration) QueryOption {
+	return func(c *queryConfig) {
+		c.maxTime = d
+	}
+}
+
+// Hint makes queries use the index of the name, in case the server picks
+// a worse one.
+func Hint(indexName string) QueryOption {
+	return func(c *queryConfig) {
+		c.hint = indexName
+	}
+}
+
+// Comment attaches the comment to queries, which shows up in the profiler
+// and the slow query log of the server. The default is the realm of the
+// user and the full name of the message, e.g. "acme main.Person".
+func Comment(comment string) QueryOption {
+	return func(c *queryConfig) {
+		c.comment = comment
+	}
+}
+
+// BatchSize sets how many documents are fetched from the server at once.
+// It overrides the default of WithDefaultBatchSize.
+func BatchSize(n int32) QueryOption {
+	return func(c *queryConfig) {
+		c.batchSize = n
+	}
+}
+
+// NoCursorTimeout keeps the server from closing the cursor of a query
+// which is idle for more than 10 minutes, e.g. while a slow consumer of
+// FilterStream or ForEachBatch processes a batch. Such a cursor only
+// goes away when it is exhausted or closed, so streams have to be closed
+// reliably, or the cursors pile up on the server. Sessions time out
+// nonetheless, after 30 minutes by default.
+func NoCursorTimeout() QueryOption {
+	return func(c *queryConfig) {
+		c.noCursorTimeout = true
+	}
+}
+
+// with returns a copy of the config with the options applied.
+func (c queryConfig) with(opts []QueryOption) queryConfig {
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// FindAndUpdateOption configures a single call to FindAndUpdate.
+type FindAndUpdateOption func(*findAndUpdateConfig)
+
+type findAndUpdateConfig struct {
+	returnAfter bool
+	sort        bson.D
+	upsert      bool
+}
+
+// ReturnBefore makes FindAndUpdate return the document as it was before
+// the update. This is the default.
+func ReturnBefore() FindAndUpdateOption {
+	return func(c *findAndUpdateConfig) {
+		c.returnAfter = false
+	}
+}
+
+// ReturnAfter makes FindAndUpdate return the document as it is after the
+// update.
+func ReturnAfter() FindAndUpdateOption {
+	return func(c *findAndUpdateConfig) {
+		c.returnAfter = true
+	}
+}
+
+// WithSort decides which document FindAndUpdate picks if several match,
+// e.g. bson.D{{Key: "priority", Value: -1}} for the highest priority.
+func WithSort(sort bson.D) FindAndUpdateOption {
+	return func(c *findAndUpdateConfig) {
+		c.sort = sort
+	}
+}
+
+// WithUpsert makes FindAndUpdate insert a new document if none matches.
+// Combine it with ReturnAfter, as there is nothing to return from before
+// an insert.
+func WithUpsert() FindAndUpdateOption {
+	return func(c *findAndUpdateConfig) {
+		c.upsert = true
+	}
+}
+
+// DestructiveOption configures operations which wipe the data of a model.
+type DestructiveOption func(*destructiveConfig)
+
+type destructiveConfig struct {
+	confirmed bool
+}
+
+// WithIUnderstandThisDeletesData confirms that a destructive operation
+// like Truncate or DropCollection shall really run. Without it, they fail.
+func WithIUnderstandThisDeletesData() DestructiveOption {
+	return func(c *destructiveConfig) {
+		c.confirmed = true
+	}
+}
+
+func confirmed(opts []DestructiveOption) bool {
+	c := destructiveConfig{}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c.confirmed
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// PageResult is a page of the documents matching a query.
+type PageResult struct {
+	Items []protoreflect.ProtoMessage
+	// Page is the number of the page, starting at 1.
+	Page     int
+	PageSize int
+	// TotalCount is the number of documents matching the query on all
+	// pages.
+	TotalCount int64
+	TotalPages int
+	HasNext    bool
+}
+
+// Page returns the documents of the page of the given size, counting
+// from 1, along with the total number of matching documents. The filters
+// are combined like in Filter, the options apply like those of With, but
+// without their Limit and Skip. Pass SortBy for pages that are stable.
+// An invalid page fails with ErrInvalidPage, a page size above the cap of
+// WithMaxResults with ErrPageTooLarge.
+func (p *BoundProtoStore) Page(model func() protoreflect.ProtoMessage, page, pageSize int, filters []bson.D, opts ...QueryOption) (_ PageResult, err error) {
+	p, done := p.operation("Page", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	if page < 1 || pageSize < 1 {
+		return PageResult{}, fmt.Errorf("page %d with size %d of collection %s: %w", page, pageSize, md.FullName(), ErrInvalidPage)
+	}
+	if max := p.protoStore.settings.maxResults; max > 0 && int64(pageSize) > max {
+		return PageResult{}, fmt.Errorf("page size %d of collection %s exceeds %d: %w", pageSize, md.FullName(), max, ErrPageTooLarge)
+	}
+	q := p.With(opts...).With(Skip(int64(page-1)*int64(pageSize)), Limit(int64(pageSize)))
+
+	coll, err := q.collection(model)
+	if err != nil {
+		return PageResult{}, err
+	}
+	// the count and the page use the same filter, so they agree
+	filter, err := q.queryFilter(md, filters)
+	if err != nil {
+		return PageResult{}, err
+	}
+	countOpts := options.Count()
+	if collation := q.collation(filters); collation != nil {
+		countOpts.SetCollation(collation)
+	}
+	if d := q.maxTime(); d > 0 {
+		countOpts.SetMaxTime(d)
+	}
+	if q.query.hint != "" {
+		countOpts.SetHint(q.query.hint)
+	}
+	total, err := coll.CountDocuments(q.ctx, filter, countOpts)
+	if err != nil {
+		return PageResult{}, fmt.Errorf("could not count documents of collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+	findOpts, err := q.findOptions(md, filters)
+	if err != nil {
+		return PageResult{}, err
+	}
+	found, err := q.find(model, filter, findOpts)
+	if err != nil {
+		return PageResult{}, err
+	}
+
+	res := PageResult{
+		Items:      make([]protoreflect.ProtoMessage, 0, len(found)),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalCount: total,
+		TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		HasNext:    int64(page)*int64(pageSize) < total,
+	}
+	for _, s := range found {
+		res.Items = append(res.Items, s.Message)
+	}
+	return res, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// resolvePath resolves a dot-separated field path, like address.city,
+// on the message descriptor. Each segment may be the proto name or the
+// json name of the field. It returns the descriptors of all fields along
+// the path.
+func resolvePath(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	if path == "" {
+		return nil, errors.New("empty field path")
+	}
+	var fields []protoreflect.FieldDescriptor
+	current := md
+	for _, segment := range strings.Split(path, ".") {
+		if current == nil {
+			return nil, fmt.Errorf("field path %s of message %s descends into %s, which is no message", path, md.FullName(), fields[len(fields)-1].Name())
+		}
+		fd := fieldByName(current, segment)
+		if fd == nil {
+			return nil, fmt.Errorf("message %s has no field %s of path %s", current.FullName(), segment, path)
+		}
+		fields = append(fields, fd)
+		current = nil
+		if isMessage(fd) && !fd.IsMap() {
+			current = fd.Message()
+		}
+	}
+	return fields, nil
+}
+
+// fieldByName finds a field by its proto or its json name.
+func fieldByName(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+// jsonPath returns the dot-separated path of the fields with their json
+// names, which is how they are named in the documents.
+func jsonPath(fields []protoreflect.FieldDescriptor) string {
+	names := make([]string, len(fields))
+	for i, fd := range fields {
+		names[i] = fd.JSONName()
+	}
+	return strings.Join(names, ".")
+}
+
+// lookupPath returns the value at the dot-separated path within the
+// document, and whether there is one.
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		value, ok := doc[segment]
+		if !ok {
+			return nil, false
+		}
+		if i == len(segments)-1 {
+			return value, true
+		}
+		if doc, ok = asDoc(value); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
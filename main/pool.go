@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which buffers are dropped instead
+// of pooled, so a single huge document does not stay in memory.
+const maxPooledBuffer = 1 << 20
+
+// bufferPool holds the buffers documents are encoded into as json on
+// their way into messages.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// docPool holds documents which are only needed during a call, e.g. to
+// be encoded by the driver, and are not referenced afterwards. Nested
+// documents are not reused.
+var docPool = sync.Pool{
+	New: func() interface{} {
+		return map[string]interface{}{}
+	},
+}
+
+func getDoc() map[string]interface{} {
+	return docPool.Get().(map[string]interface{})
+}
+
+func putDoc(doc map[string]interface{}) {
+	for key := range doc {
+		delete(doc, key)
+	}
+	docPool.Put(doc)
+}
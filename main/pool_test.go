@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPutDocClears(t *testing.T) {
+	doc := getDoc()
+	doc["name"] = "Ada"
+	putDoc(doc)
+	if len(doc) != 0 {
+		t.Errorf("pooled document still holds %v", doc)
+	}
+}
+
+// TestPooledDocsConcurrent encodes and decodes messages concurrently, so
+// -race reports a pooled document or buffer which is reused while it is
+// still in use, and the comparison one whose content leaked.
+func TestPooledDocsConcurrent(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDirectConversion()}} {
+		s := newSettings(opts)
+		registry := protoCodec{settings: s}.registry()
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					want := &Person{Name: fmt.Sprintf("person %d-%d", g, i), Email: fmt.Sprintf("%d@example.com", i)}
+					raw, err := bson.MarshalWithRegistry(registry, bson.D{bson.E{Key: "person", Value: want}})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					var got struct{ Person *Person }
+					if err := bson.UnmarshalWithRegistry(registry, raw, &got); err != nil {
+						t.Error(err)
+						return
+					}
+					if got.Person.GetName() != want.Name || got.Person.GetEmail() != want.Email {
+						t.Errorf("encoded %v, decoded %v", want, got.Person)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+func TestPooledDocsConcurrentListValues(t *testing.T) {
+	_, bound := newTestStore(t)
+	// a document per goroutine, so only the pool is shared
+	ids := make([]string, 8)
+	for g := range ids {
+		id, _, err := bound.Store(newSample(t, `{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[g] = id
+	}
+	var wg sync.WaitGroup
+	for g, id := range ids {
+		wg.Add(1)
+		go func(g int, id string) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := bound.PushToList(sample, id, "tags", fmt.Sprintf("%d-%d", g, i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g, id)
+	}
+	wg.Wait()
+
+	for g, id := range ids {
+		got, err := bound.Get(sample, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var json []string
+		for i := 0; i < 10; i++ {
+			json = append(json, fmt.Sprintf(`"%d-%d"`, g, i))
+		}
+		assertEqual(t, got, newSample(t, `{"id": "`+id+`", "tags": [`+strings.Join(json, ",")+`]}`))
+	}
+}
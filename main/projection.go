@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// projectionSpec returns the projection reading the fields of the
+// message, which may be given by their proto or json names, along with
+// the metadata needed to decode the documents.
+func projectionSpec(md protoreflect.MessageDescriptor, fields []string) (bson.D, error) {
+	spec := bson.D{bson.E{Key: fieldID, Value: 1}, bson.E{Key: fieldType, Value: 1}}
+	for _, field := range fields {
+		resolved, err := resolvePath(md, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not project %s: %w", field, err)
+		}
+		spec = append(spec, bson.E{Key: jsonPath(resolved), Value: 1})
+	}
+	return spec, nil
+}
+
+// ProjectionMask returns the fields of the model the queries of the
+// store read, as set with Project, or nil if they read all fields.
+func (p *BoundProtoStore) ProjectionMask(model func() protoreflect.ProtoMessage) (*fieldmaskpb.FieldMask, error) {
+	if len(p.query.projection) == 0 {
+		return nil, nil
+	}
+	md := model().ProtoReflect().Descriptor()
+	mask := &fieldmaskpb.FieldMask{}
+	hasID := false
+	for _, field := range p.query.projection {
+		resolved, err := resolvePath(md, field)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, len(resolved))
+		for i, fd := range resolved {
+			names[i] = string(fd.Name())
+		}
+		path := strings.Join(names, ".")
+		hasID = hasID || path == "id"
+		mask.Paths = append(mask.Paths, path)
+	}
+	if idField := md.Fields().ByName("id"); idField != nil && !hasID {
+		mask.Paths = append(mask.Paths, "id")
+	}
+	return mask, nil
+}
@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"google.golang.org/protobuf/encoding/protojson"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // ProtoStore is the gateway to the database and knows how to access
@@ -27,39 +35,276 @@ import (
 // request.
 type ProtoStore struct {
 	client *mongo.Client
+	closed *int32
+	// connected is set once the database answered, with WithLazyConnect
+	connected *int32
+	settings  settings
+	// indexes remembers the indexes this store already created, keyed by
+	// realm, collection and the indexed fields
+	indexes      *sync.Map
+	transactions *transactionSupport
+	// breaker is shared by the copies of the store, nil without
+	// WithCircuitBreaker
+	breaker *circuitBreaker
+	// flights are the Gets in flight, nil without WithSingleflight
+	flights *flightGroup
+	// cache holds the documents read by id, nil without WithCache
+	cache *docCache
 }
 
-func NewProtoStoreFromEnv() ProtoStore {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
+// NewProtoStoreFromEnv connects to the database configured by the
+// DB_PROTOCOL, DB_HOST, DB_PORT, DB_USER and DB_PASSWORD environment
+// variables. DB_HOST is required, as is DB_PORT unless the protocol is
+// mongodb+srv. DB_PASSWORD is required if DB_USER is set. The optional
+// DB_MAX_POOL_SIZE, DB_MIN_POOL_SIZE, DB_MAX_CONN_IDLE_TIME,
+// DB_CONNECT_TIMEOUT, DB_SERVER_SELECTION_TIMEOUT and DB_COMPRESSORS
+// configure the connection like the options of the same names, with
+// durations given like 30s and compressors like zstd,snappy.
+func NewProtoStoreFromEnv(ctx context.Context, opts ...Option) (ProtoStore, error) {
 	protocol := os.Getenv("DB_PROTOCOL")
+	host, err := requireEnv("DB_HOST")
+	if err != nil {
+		return ProtoStore{}, err
+	}
+	port := os.Getenv("DB_PORT")
+	if port == "" && protocol != "mongodb+srv" {
+		return ProtoStore{}, errMissingEnv("DB_PORT")
+	}
 	user := os.Getenv("DB_USER")
 	password := os.Getenv("DB_PASSWORD")
-	s := protocol + "://" + user + ":" + password + "@" + host + ":" + port
-	return NewProtoStore(s)
+	if user != "" && password == "" {
+		return ProtoStore{}, errMissingEnv("DB_PASSWORD")
+	}
+	envOpts, err := envOptions()
+	if err != nil {
+		return ProtoStore{}, err
+	}
+	// the options passed in take precedence over the environment
+	return NewProtoStore(ctx, connectionString(protocol, user, password, host, port), append(envOpts, opts...)...)
 }
 
-func NewProtoStore(dbConnectionString string) ProtoStore {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017").SetAuth(options.Credential{
-		Username: "admin",
-		Password: "admin",
-	}))
+// NewProtoStore connects to the database behind the connection string.
+// The context bounds the connect attempt. Like the driver, it does not
+// wait for the database to answer, unless WithConnectRetry is set.
+func NewProtoStore(ctx context.Context, dbConnectionString string, storeOpts ...Option) (ProtoStore, error) {
+	opts, err := clientOptions(dbConnectionString)
+	if err != nil {
+		return ProtoStore{}, err
+	}
+	settings := newSettings(storeOpts)
+	if err := settings.applyClientOptions(opts); err != nil {
+		return ProtoStore{}, err
+	}
+	if settings.protoCodec {
+		// the registry is fixed once the client is created
+		opts.SetRegistry(protoCodec{settings: settings}.registry())
+	}
 
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		panic(err)
+		return ProtoStore{}, fmt.Errorf("could not connect to the database: %w", err)
+	}
+
+	store := ProtoStore{
+		client:       client,
+		closed:       new(int32),
+		connected:    new(int32),
+		settings:     settings,
+		indexes:      &sync.Map{},
+		transactions: &transactionSupport{},
+		breaker:      newCircuitBreaker(settings.breakerThreshold, settings.breakerCooldown),
+		cache:        newDocCache(settings.cacheEntries, settings.cacheTTL),
+	}
+	if settings.singleflight {
+		store.flights = newFlightGroup()
+	}
+	if settings.connectAttempts > 0 && !settings.lazyConnect {
+		if err := store.connect(ctx); err != nil {
+			// the client is of no use, but would keep its monitors running
+			_ = client.Disconnect(context.Background())
+			return ProtoStore{}, err
+		}
+	}
+	return store, nil
+}
+
+func requireEnv(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", errMissingEnv(name)
 	}
+	return value, nil
+}
+
+func errMissingEnv(name string) error {
+	return fmt.Errorf("environment variable %s is not set", name)
+}
+
+// connectionString assembles a mongodb connection string from its
+// parts. The protocol defaults to "mongodb" and the credentials are
+// escaped, so passwords may contain characters like '@' or ':'.
+func connectionString(protocol, user, password, host, port string) string {
+	if protocol == "" {
+		protocol = "mongodb"
+	}
+	u := url.URL{
+		Scheme: protocol,
+		Host:   host,
+	}
+	if port != "" {
+		u.Host = host + ":" + port
+	}
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u.String()
+}
 
-	return ProtoStore{
-		client: client,
+// clientOptions parses the connection string into the options passed
+// to mongo.Connect. Credentials embedded in the string are applied as
+// well.
+func clientOptions(dbConnectionString string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(dbConnectionString)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database connection string: %w", err)
 	}
+	return opts, nil
 }
 
 func (p *ProtoStore) Bind(context context.Context, user *User) BoundProtoStore {
-	return BoundProtoStore{
+	b := BoundProtoStore{
 		protoStore: p,
 		ctx:        context,
 		user:       user,
 	}
+	if p.settings.causalConsistency {
+		b.startSession()
+	}
+	return b
+}
+
+// Close disconnects from the database. Afterwards, every operation on
+// the store and on stores bound to it fails with ErrStoreClosed, as does
+// a second call to Close.
+func (p *ProtoStore) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(p.closed, 0, 1) {
+		return ErrStoreClosed
+	}
+	if err := p.client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("could not disconnect from the database: %w", err)
+	}
+	return nil
+}
+
+// checkOpen returns ErrStoreClosed once Close was called.
+func (p *ProtoStore) checkOpen() error {
+	if atomic.LoadInt32(p.closed) != 0 {
+		return ErrStoreClosed
+	}
+	return nil
+}
+
+// Ping verifies that the database is reachable, using the read
+// preference the client was configured with. Connecting succeeds lazily
+// even if the server is down, so call Ping before serving traffic. The
+// context bounds how long to wait for the server.
+func (p *ProtoStore) Ping(ctx context.Context) error {
+	if err := p.checkOpen(); err != nil {
+		return err
+	}
+	if err := p.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("could not ping the database: %w", err)
+	}
+	return nil
+}
+
+// Healthy is a readiness check. Beyond Ping, it runs a ping command
+// against the database of the given realm on the primary, which is the
+// node accepting writes. The bool is only true if both succeed.
+func (p *ProtoStore) Healthy(ctx context.Context, realm string) (bool, error) {
+	if err := p.Ping(ctx); err != nil {
+		return false, err
+	}
+	opts := options.RunCmd().SetReadPreference(readpref.Primary())
+	err := p.client.Database(realm).RunCommand(ctx, bson.D{bson.E{Key: "ping", Value: 1}}, opts).Err()
+	if err != nil {
+		return false, fmt.Errorf("could not reach database %s on the primary: %w", realm, err)
+	}
+	return true, nil
+}
+
+// names of the metadata fields the store injects into every document
+const (
+	fieldID        = "_id"
+	fieldType      = "type"
+	fieldCreatedBy = "createdBy"
+	fieldCreatedAt = "createdAt"
+	fieldUpdatedAt = "updatedAt"
+	fieldDeletedAt = "deletedAt"
+	fieldDeletedBy = "deletedBy"
+)
+
+var metadataFields = []string{fieldID, fieldType, fieldCreatedBy, fieldCreatedAt, fieldUpdatedAt, fieldDeletedAt, fieldDeletedBy}
+
+// creationFields are the metadata fields which are only written when a
+// document is created.
+var creationFields = []string{fieldCreatedBy, fieldCreatedAt}
+
+func isCreationField(name string) bool {
+	for _, field := range creationFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isMetadataField(name string) bool {
+	for _, field := range metadataFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// The metadata fields to sort by, e.g. SortBy(MetaUpdatedAt, false) for
+// the most recently written documents first. Ids of the default
+// ObjectIDCodec start with their creation time, so MetaID sorts by when
+// the documents were created.
+const (
+	MetaID        = fieldID
+	MetaUpdatedAt = fieldUpdatedAt
+)
+
+// typeValue returns the value of the type field, which is the full name of
+// the message and the version of its schema, see WithTypeVersion.
+func (s *settings) typeValue(table protoreflect.FullName) string {
+	version, ok := s.typeVersions[table]
+	if !ok {
+		version = 1
+	}
+	return formatTypeValue(table, version)
+}
+
+// formatTypeValue returns the value of the type field for the full name
+// of a message and the version of its schema.
+func formatTypeValue(table protoreflect.FullName, version int) string {
+	return fmt.Sprintf("%s:%d", string(table), version)
+}
+
+// parseTypeValue splits the value of the type field into the full name of
+// the message and the version of its schema.
+func parseTypeValue(value string) (protoreflect.FullName, int, error) {
+	i := strings.LastIndex(value, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("type %q has no version", value)
+	}
+	version, err := strconv.Atoi(value[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("type %q has an invalid version: %w", value, err)
+	}
+	return protoreflect.FullName(value[:i]), version, nil
 }
 
 // BoundProtoStore is bound to a current user and context of a request by a
@@ -70,115 +315,791 @@ func (p *ProtoStore) Bind(context context.Context, user *User) BoundProtoStore {
 type BoundProtoStore struct {
 	protoStore *ProtoStore
 	ctx        context.Context
-	user       *User
+	// callerCtx is the context of the caller while ctx carries the
+	// deadline of WithDefaultTimeout, see callerDone
+	callerCtx context.Context
+	user      *User
+	query     queryConfig
+	// session is the causally consistent session of the operations, see
+	// WithCausalConsistency
+	session *boundSession
+	// trace collects the details of the current operation for the slow
+	// query log, nil if it is not logged
+	trace *opTrace
 }
 
-func (p *BoundProtoStore) Store(message protoreflect.ProtoMessage) (string, error) {
+// With returns a copy of the store, which applies the options to every
+// query it runs, e.g. store.With(IncludeDeleted()).Filter(...).
+func (p *BoundProtoStore) With(opts ...QueryOption) *BoundProtoStore {
+	c := *p
+	c.query = p.query.with(opts)
+	return &c
+}
+
+// Store upserts the message and returns its id. Storing a soft-deleted
+// document fails with ErrDeleted, unless WithRestore is passed. The bool
+// tells whether a new document was created, which is also the case if
+// the message carries an id that did not exist yet, or whether an
+// existing one was updated, except after a retry, see WithRetry. By
+// default, the message is merged into an existing document, see WithMode
+// for replacing it instead.
+func (p *BoundProtoStore) Store(message protoreflect.ProtoMessage, opts ...StoreOption) (_ string, _ bool, err error) {
+	p, done := p.operation("Store", modelOf(message))
+	defer done(&err)
+	cfg := newStoreConfig(opts)
+	if err := p.ready(); err != nil {
+		return "", false, err
+	}
 
-	doc := toMap(message)
+	// the driver encodes the document, so it is reused afterwards
+	doc := getDoc()
+	defer putDoc(doc)
+	if err := p.documentInto(message, doc); err != nil {
+		return "", false, err
+	}
+	return p.write(message, doc, cfg)
+}
 
+// write upserts the document of the message, see Store.
+func (p *BoundProtoStore) write(message protoreflect.ProtoMessage, doc map[string]interface{}, cfg storeConfig) (string, bool, error) {
 	table := message.ProtoReflect().Descriptor().FullName()
-	existingIdSet := false
-	if id, ok := doc["id"]; ok {
-		if idS, ok := id.(string); ok {
-			objectId, err := primitive.ObjectIDFromHex(idS)
-			if err != nil {
-				log.Fatalf("could not create ObjectId from %s: %v", idS, err)
-			}
-			doc["_id"] = objectId
-			existingIdSet = true
+	filter, update := upsert(message.ProtoReflect().Descriptor(), doc, cfg)
+
+	coll := p.db(p.user.Realm).Collection(string(table), p.collectionOptions())
+	var res *mongo.UpdateResult
+	var err error
+	// the upsert by _id can be repeated safely, only the result may tell
+	// an update for a document an earlier attempt created
+	err = p.retry("store", func() error {
+		var err error
+		res, err = coll.UpdateOne(p.ctx, filter, update, options.Update().SetUpsert(true))
+		return err
+	})
+	// also a failed write may have been applied
+	p.invalidateDocs(table, doc[fieldID])
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		// the write was sent, but whether it created the document is
+		// unknown
+		err = nil
+	}
+	if err != nil {
+		return "", false, p.storeError(coll, doc, table, err, cfg)
+	}
+
+	id, err := p.protoStore.settings.setID(message, doc)
+	if err != nil {
+		return "", false, err
+	}
+	return id, res.UpsertedID != nil, nil
+}
+
+// document converts the message into the document to store, including
+// the metadata of the store. If the message carries no id, a new one is
+// generated.
+func (p *BoundProtoStore) document(message protoreflect.ProtoMessage) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := p.documentInto(message, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// documentInto works like document, but writes into doc, which has to be
+// empty. If the document is not referenced after the write, it can come
+// from getDoc.
+func (p *BoundProtoStore) documentInto(message protoreflect.ProtoMessage, doc map[string]interface{}) error {
+	if err := p.contentInto(message, doc); err != nil {
+		return err
+	}
+	return p.addMetadata(message.ProtoReflect().Descriptor().FullName(), doc)
+}
+
+// content converts the message into the document to store, without the
+// metadata of the store.
+func (p *BoundProtoStore) content(message protoreflect.ProtoMessage) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := p.contentInto(message, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// contentInto works like content, but writes into doc, see documentInto.
+func (p *BoundProtoStore) contentInto(message protoreflect.ProtoMessage, doc map[string]interface{}) error {
+	if err := validateDescriptor(message.ProtoReflect().Descriptor()); err != nil {
+		return err
+	}
+	if err := p.protoStore.settings.toMapInto(message, doc); err != nil {
+		return err
+	}
+	p.protoStore.settings.toGeoJSON(message.ProtoReflect().Descriptor(), doc)
+	return nil
+}
+
+// addMetadata adds the metadata of the store to the document of a message
+// of the table. If the document carries no id, a new one is generated.
+func (p *BoundProtoStore) addMetadata(table protoreflect.FullName, doc map[string]interface{}) error {
+	var err error
+	id, ok := doc["id"]
+	if !ok {
+		id = p.protoStore.settings.newID()
+	}
+	idS, ok := id.(string)
+	if !ok {
+		return fmt.Errorf("the id of %s is no string: %w", table, &InvalidIDError{ID: id})
+	}
+	if doc[fieldID], err = p.protoStore.settings.encodeID(string(table), idS); err != nil {
+		return err
+	}
+
+	doc[fieldType] = p.protoStore.settings.typeValue(table)
+	now := primitive.NewDateTimeFromTime(time.Now())
+	doc[fieldCreatedBy] = p.user.ID
+	doc[fieldCreatedAt] = now
+	doc[fieldUpdatedAt] = now
+	return nil
+}
+
+// upsert returns the filter and the update to store the document. When
+// replacing, the update is a pipeline which replaces the document, but
+// keeps its creation metadata.
+func upsert(md protoreflect.MessageDescriptor, doc map[string]interface{}, cfg storeConfig) (bson.D, interface{}) {
+	// soft-deleted documents do not match the filter, so the upsert tries
+	// to insert a second document with the same id and fails
+	filter := bson.D{bson.E{Key: fieldID, Value: doc[fieldID]}}
+	if !cfg.restore {
+		filter = append(filter, notDeleted)
+	}
+	if cfg.mode == Replace {
+		return filter, replacement(md, doc)
+	}
+
+	// protojson omits unpopulated fields, so fields that were cleared on
+	// the message have to be removed explicitly. Otherwise, the old value
+	// survives the $set and is read again. This also removes the other
+	// members of a oneof once one of them is written.
+	set := make(map[string]interface{}, len(doc))
+	onInsert := bson.D{}
+	for key, value := range doc {
+		if isCreationField(key) {
+			onInsert = append(onInsert, bson.E{Key: key, Value: value})
+		} else if fd := md.Fields().ByJSONName(key); fd != nil {
+			set[key] = orderedField(fd, value)
 		} else {
-			log.Fatalf("the current id is no string: %v", id)
+			set[key] = value
 		}
 	}
+	update := bson.D{bson.E{Key: "$set", Value: set}}
+	if len(onInsert) > 0 {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: onInsert})
+	}
+	unset := absentFields(md, doc)
+	if cfg.restore {
+		unset = append(unset, bson.E{Key: fieldDeletedAt, Value: ""}, bson.E{Key: fieldDeletedBy, Value: ""})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return filter, update
+}
 
-	if !existingIdSet {
-		doc["_id"] = primitive.NewObjectID()
+// replacement returns the update pipeline which replaces a document by
+// doc. The creation metadata of an existing document is kept, the one of
+// doc only applies to a new document.
+func replacement(md protoreflect.MessageDescriptor, doc map[string]interface{}) mongo.Pipeline {
+	// the values are literals, so strings starting with $ are not taken
+	// for fields of the document
+	kept := bson.D{}
+	for _, field := range creationFields {
+		kept = append(kept, bson.E{Key: field, Value: bson.D{bson.E{Key: "$ifNull", Value: bson.A{
+			"$" + field,
+			bson.D{bson.E{Key: "$literal", Value: doc[field]}},
+		}}}})
 	}
+	merged := bson.D{bson.E{Key: "$mergeObjects", Value: bson.A{
+		bson.D{bson.E{Key: "$literal", Value: orderedFields(md, doc)}},
+		kept,
+	}}}
+	return mongo.Pipeline{bson.D{bson.E{Key: "$replaceWith", Value: merged}}}
+}
 
-	doc["type"] = fmt.Sprintf("%s:%d", string(table), 1)
-	doc["createdBy"] = p.user.ID
+// storeError describes why the document could not be stored in coll.
+func (p *BoundProtoStore) storeError(coll *mongo.Collection, doc map[string]interface{}, table protoreflect.FullName, err error, cfg storeConfig) error {
+	if mongo.IsDuplicateKeyError(err) && !cfg.restore && p.isDeleted(coll, bson.D{bson.E{Key: fieldID, Value: doc[fieldID]}}) {
+		err = ErrDeleted
+	}
+	return fmt.Errorf("could not store document %v in collection %s: %w", doc[fieldID], table, writeError(err))
+}
 
-	opts := options.Update().SetUpsert(true)
-	_, err := p.db(p.user.Realm).Collection(string(table)).UpdateByID(p.ctx, doc["_id"], bson.D{bson.E{Key: "$set", Value: doc}}, opts)
+// setID writes the id of the stored document back onto the message, so
+// the caller does not have to re-query to learn the id of a newly stored
+// message. It returns the id.
+func (s *settings) setID(message protoreflect.ProtoMessage, doc map[string]interface{}) (string, error) {
+	id, err := s.decodeID(string(message.ProtoReflect().Descriptor().FullName()), doc[fieldID])
 	if err != nil {
-		log.Fatalf("Could not insert document: %v", err)
+		return "", err
 	}
+	if idField := message.ProtoReflect().Descriptor().Fields().ByName("id"); idField != nil {
+		message.ProtoReflect().Set(idField, protoreflect.ValueOfString(id))
+	}
+	return id, nil
+}
 
-	id := doc["_id"]
+// StoredMessage is a message together with the id of the document it
+// was read from. Messages without an id field can not carry their id
+// themselves, so use FilterStored to still Get or Delete them later.
+type StoredMessage struct {
+	ID      string
+	Message protoreflect.ProtoMessage
+	// Version is the version of the schema the document was written with,
+	// see WithTypeVersion, so older documents can be upconverted. It is
+	// zero for documents without a valid type field, e.g. written by
+	// other tools.
+	Version int
+}
 
-	if r, ok := id.(primitive.ObjectID); ok {
-		return r.Hex(), nil
+func (p *BoundProtoStore) Filter(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("Filter", model)
+	defer done(&err)
+	stored, err := p.FilterStored(model, filters...)
+	if err != nil && !errors.Is(err, ErrResultTruncated) {
+		return nil, err
 	}
+	res := make([]protoreflect.ProtoMessage, 0, len(stored))
+	for _, s := range stored {
+		res = append(res, s.Message)
+	}
+	return res, err
+}
 
-	return "", fmt.Errorf("id was not of type []byte, but %v", id)
+// FilterStored works like Filter, but returns the id of every document
+// alongside its message.
+func (p *BoundProtoStore) FilterStored(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ []StoredMessage, err error) {
+	p, done := p.operation("FilterStored", model)
+	defer done(&err)
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.findOptions(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	return p.findCapped(model, filter, opts)
 }
 
-func (p *BoundProtoStore) Filter(model func() protoreflect.ProtoMessage, filters ...bson.D) []protoreflect.ProtoMessage {
-	tableName := model().ProtoReflect().Descriptor().FullName()
+// findCapped runs the query like find, but returns at most as many
+// documents as WithMaxResults allows if the query sets no Limit and is
+// not Unlimited.
+func (p *BoundProtoStore) findCapped(model func() protoreflect.ProtoMessage, filter interface{}, opts *options.FindOptions) ([]StoredMessage, error) {
+	max := p.protoStore.settings.maxResults
+	capped := p.query.limit == nil && !p.query.unlimited && max > 0
+	if capped {
+		// the extra document tells whether there are more
+		opts.SetLimit(max + 1)
+	}
+	found, err := p.find(model, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if capped && int64(len(found)) > max {
+		return found[:max], fmt.Errorf("more than %d documents of collection %s match the filters: %w", max, model().ProtoReflect().Descriptor().FullName(), ErrResultTruncated)
+	}
+	return found, nil
+}
 
-	filter := bson.D{}
-	if len(filters) > 1 { // a $and with Value: [] is always false
-		filter = bson.D{bson.E{Key: "$and", Value: filters}}
-	} else if len(filters) == 1 {
-		filter = filters[0]
+// find runs the query and decodes all documents found. It is retried
+// as a whole, see WithRetry.
+func (p *BoundProtoStore) find(model func() protoreflect.ProtoMessage, filter interface{}, opts *options.FindOptions) ([]StoredMessage, error) {
+	var res []StoredMessage
+	err := p.retry("find", func() error {
+		var err error
+		res, err = p.findOnce(model, filter, opts)
+		return err
+	})
+	p.traceResults(len(res))
+	return res, err
+}
+
+func (p *BoundProtoStore) findOnce(model func() protoreflect.ProtoMessage, filter interface{}, opts *options.FindOptions) ([]StoredMessage, error) {
+	stream, err := p.stream(model, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if workers := p.protoStore.settings.decodeConcurrency; workers > 1 {
+		return stream.decodeAll(p.ctx, workers)
+	}
+	res := make([]StoredMessage, 0)
+	for {
+		m, err := stream.NextStored(p.ctx)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+}
+
+// findOptions returns the options of the driver for the query options
+// and the filters.
+func (p *BoundProtoStore) findOptions(md protoreflect.MessageDescriptor, filters []bson.D) (*options.FindOptions, error) {
+	opts := options.Find()
+	if p.query.limit != nil {
+		if *p.query.limit < 0 {
+			return nil, fmt.Errorf("invalid limit %d, it must not be negative", *p.query.limit)
+		}
+		opts.SetLimit(*p.query.limit)
+	}
+	if p.query.skip < 0 {
+		return nil, fmt.Errorf("invalid skip %d, it must not be negative", p.query.skip)
+	}
+	if p.query.skip > 0 {
+		opts.SetSkip(p.query.skip)
+	}
+	if len(p.query.sort) > 0 {
+		sort, err := sortSpec(md, p.query.sort)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetSort(sort)
+	}
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if len(p.query.projection) > 0 {
+		projection, err := projectionSpec(md, p.query.projection)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetProjection(projection)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
 	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	opts.SetComment(p.comment(md))
+	if p.query.batchSize > 0 {
+		opts.SetBatchSize(p.query.batchSize)
+	} else if n := p.protoStore.settings.defaultBatchSize; n > 0 {
+		opts.SetBatchSize(n)
+	}
+	if p.query.noCursorTimeout {
+		opts.SetNoCursorTimeout(true)
+	}
+	return opts, nil
+}
+
+// sortSpec resolves the fields of the sort order on the message, which
+// may be given by their proto or json names. The id is appended as the
+// last key, so documents which are equal in all keys still come in a
+// stable order.
+func sortSpec(md protoreflect.MessageDescriptor, sort bson.D) (bson.D, error) {
+	spec := make(bson.D, 0, len(sort)+1)
+	sortsByID := false
+	for _, e := range sort {
+		path := e.Key
+		if isMetadataField(path) {
+			sortsByID = sortsByID || path == fieldID
+		} else {
+			fields, err := resolvePath(md, path)
+			if err != nil {
+				return nil, fmt.Errorf("could not sort by %s: %w", path, err)
+			}
+			path = jsonPath(fields)
+		}
+		spec = append(spec, bson.E{Key: path, Value: e.Value})
+	}
+	if !sortsByID {
+		spec = append(spec, bson.E{Key: fieldID, Value: 1})
+	}
+	return spec, nil
+}
+
+// collation returns the collation of a query with the filters: the one
+// set by WithCollation, or a case-insensitive one if the filters contain
+// EqFold.
+func (p *BoundProtoStore) collation(filters []bson.D) *options.Collation {
+	if p.query.collation != nil {
+		return p.query.collation
+	}
+	for _, filter := range filters {
+		if hasFold(filter) {
+			return foldCollation
+		}
+	}
+	return nil
+}
 
-	log.Println(filter)
+// maxTime returns how long queries may run on the server, zero meaning
+// unbounded.
+func (p *BoundProtoStore) maxTime() time.Duration {
+	if p.query.maxTime > 0 {
+		return p.query.maxTime
+	}
+	return p.protoStore.settings.defaultMaxTime
+}
 
-	db := p.db(p.user.Realm)
-	rows, err := db.Collection(string(tableName)).Find(p.ctx, filter)
+// comment returns the comment of the queries of the message, see Comment.
+func (p *BoundProtoStore) comment(md protoreflect.MessageDescriptor) string {
+	if p.query.comment != "" {
+		return p.query.comment
+	}
+	return fmt.Sprintf("%s %s", p.user.Realm, md.FullName())
+}
 
+// First returns the first document matching the filters, which are
+// combined like in Filter. Pass SortBy to decide which one is first, e.g.
+// the most recent one. If no document matches, an error wrapping
+// ErrNotFound is returned.
+func (p *BoundProtoStore) First(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("First", model)
+	defer done(&err)
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.findOptions(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	found, err := p.find(model, filter, opts.SetLimit(1))
 	if err != nil {
-		log.Fatalf("Could not read table %s: %v", tableName, err)
+		return nil, err
 	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no document in collection %s matches the filters: %w", model().ProtoReflect().Descriptor().FullName(), ErrNotFound)
+	}
+	return found[0].Message, nil
+}
 
-	protoReader := protojson.UnmarshalOptions{
-		DiscardUnknown: true,
+// FirstStrict works like First, but fails with ErrMultipleMatches if more
+// than one document matches.
+func (p *BoundProtoStore) FirstStrict(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("FirstStrict", model)
+	defer done(&err)
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.findOptions(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
 	}
+	found, err := p.find(model, filter, opts.SetLimit(2))
+	if err != nil {
+		return nil, err
+	}
+	tableName := model().ProtoReflect().Descriptor().FullName()
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no document in collection %s matches the filters: %w", tableName, ErrNotFound)
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("more than one document in collection %s matches the filters: %w", tableName, ErrMultipleMatches)
+	}
+	return found[0].Message, nil
+}
+
+func (p *BoundProtoStore) All(model func() protoreflect.ProtoMessage) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("All", model)
+	defer done(&err)
+	return p.Filter(model)
+}
+
+// Get returns the document with the given id. If there is no such
+// document, an error wrapping ErrNotFound is returned.
+func (p *BoundProtoStore) Get(model func() protoreflect.ProtoMessage, id string) (_ protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("Get", model)
+	defer done(&err)
+	return p.cachedGet(model, id, func() (protoreflect.ProtoMessage, error) {
+		if m, shared, err := p.sharedGet(model, id); shared {
+			return m, err
+		}
+		return p.get(model, id)
+	})
+}
 
-	res := make([]protoreflect.ProtoMessage, 0)
-	var results []bson.M
+func (p *BoundProtoStore) get(model func() protoreflect.ProtoMessage, id string) (protoreflect.ProtoMessage, error) {
+	tableName := model().ProtoReflect().Descriptor().FullName()
 
-	err = rows.All(p.ctx, &results)
+	docID, err := p.protoStore.settings.encodeID(string(tableName), id)
+	if err != nil {
+		return nil, err
+	}
+	models, err := p.byID().Filter(model, bson.D{bson.E{Key: fieldID, Value: docID}})
 	if err != nil {
-		log.Fatalf("could do a .All call to mongodb: %v", err)
+		return nil, err
 	}
+	if len(models) < 1 {
+		return nil, fmt.Errorf("no document with id %s in collection %s: %w", id, tableName, ErrNotFound)
+	}
+	if len(models) > 1 {
+		return nil, fmt.Errorf("found %d entries in collection %s for unique id %s: %w", len(models), tableName, id, ErrDataCorruption)
+	}
+	return models[0], nil
+}
 
-	for _, doc := range results {
-		doc["id"] = doc["_id"]
-		jsonEncoded, err := json.Marshal(doc)
+// byID returns a copy of the store for looking up documents by their
+// ids, which ignores the paging of the query options and the cap of
+// WithMaxResults.
+func (p *BoundProtoStore) byID() *BoundProtoStore {
+	c := *p
+	unlimited := int64(0)
+	c.query.limit = &unlimited
+	c.query.skip = 0
+	return &c
+}
+
+// GetMany fetches the documents with the given ids in a single query.
+// The results are in the order of the ids, ids without a document are
+// represented by nil. If some ids are invalid, the returned error lists
+// all of them.
+func (p *BoundProtoStore) GetMany(model func() protoreflect.ProtoMessage, ids []string) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("GetMany", model)
+	defer done(&err)
+	tableName := model().ProtoReflect().Descriptor().FullName()
+
+	docIDs := make(bson.A, 0, len(ids))
+	// the ids as the codec returns them, which may differ from the ones
+	// passed in, e.g. in the case of ObjectId hex
+	canonical := make([]string, len(ids))
+	var invalid []string
+	for i, id := range ids {
+		docID, err := p.protoStore.settings.idCodec.EncodeToBSON(id)
+		if err == nil {
+			canonical[i], err = p.protoStore.settings.idCodec.DecodeToString(docID)
+		}
 		if err != nil {
-			log.Fatalf("Could not reencode json")
+			invalid = append(invalid, id)
+			continue
 		}
+		docIDs = append(docIDs, docID)
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("could not decode ids for collection %s: %w", tableName, &InvalidIDError{ID: invalid})
+	}
+
+	byID := make(map[string]protoreflect.ProtoMessage, len(ids))
+	cache := p.cache()
+	var generation uint64
+	if cache != nil {
+		// only the documents which are not cached are read
+		generation = cache.currentGeneration()
 		m := model()
-		err = protoReader.Unmarshal(jsonEncoded, m)
+		missing := make(bson.A, 0, len(docIDs))
+		for i, docID := range docIDs {
+			if cached, ok := cache.get(cacheKey(p.user.Realm, tableName, canonical[i]), m); ok {
+				p.protoStore.settings.metrics.CacheHit(string(tableName))
+				byID[canonical[i]] = cached
+				continue
+			}
+			p.protoStore.settings.metrics.CacheMiss(string(tableName))
+			missing = append(missing, docID)
+		}
+		docIDs = missing
+	}
+	if len(docIDs) > 0 {
+		stored, err := p.byID().FilterStored(model, bson.D{bson.E{Key: fieldID, Value: bson.D{bson.E{Key: "$in", Value: docIDs}}}})
 		if err != nil {
-			log.Fatalf("could not read protobuf message: %v", err)
+			return nil, err
 		}
-		res = append(res, m)
+		for _, s := range stored {
+			byID[s.ID] = s.Message
+			if cache != nil {
+				cache.put(cacheKey(p.user.Realm, tableName, s.ID), s.Message, generation)
+			}
+		}
+	}
+	res := make([]protoreflect.ProtoMessage, len(ids))
+	for i := range ids {
+		res[i] = byID[canonical[i]]
 	}
-	return res
+	return res, nil
 }
 
-func (p *BoundProtoStore) All(model func() protoreflect.ProtoMessage) []protoreflect.ProtoMessage {
-	return p.Filter(model)
+// Delete removes the document with the given id. If there is no such
+// document, an error wrapping ErrNotFound is returned.
+func (p *BoundProtoStore) Delete(model func() protoreflect.ProtoMessage, id string) (err error) {
+	p, done := p.operation("Delete", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	res, err := coll.DeleteOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}})
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete document %s from collection %s: %w", id, coll.Name(), writeError(err))
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteMany removes all documents matching the filters, which are
+// combined like in Filter, and returns how many were removed. At least
+// one filter is required, so a forgotten filter can not wipe the whole
+// collection. Use DeleteAll for that.
+func (p *BoundProtoStore) DeleteMany(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ int64, err error) {
+	p, done := p.operation("DeleteMany", model)
+	defer done(&err)
+	if len(filters) == 0 {
+		return 0, errors.New("DeleteMany requires at least one filter, use DeleteAll to remove all documents")
+	}
+	return p.deleteMany(model, filters)
 }
 
-func (p *BoundProtoStore) Get(model func() protoreflect.ProtoMessage, id string) (protoreflect.ProtoMessage, bool) {
+// DeleteAll removes all documents of the model and returns how many were
+// removed.
+func (p *BoundProtoStore) DeleteAll(model func() protoreflect.ProtoMessage) (_ int64, err error) {
+	p, done := p.operation("DeleteAll", model)
+	defer done(&err)
+	return p.deleteMany(model, nil)
+}
 
-	oid, err := primitive.ObjectIDFromHex(id)
+func (p *BoundProtoStore) deleteMany(model func() protoreflect.ProtoMessage, filters []bson.D) (int64, error) {
+	coll, err := p.collection(model)
 	if err != nil {
-		log.Fatalf("Could not decode object-id %s: %v", id, err)
+		return 0, err
 	}
-	models := p.Filter(model, bson.D{bson.E{Key: "_id", Value: oid}})
-	if len(models) < 1 {
-		return nil, false
+	md := model().ProtoReflect().Descriptor()
+	filter, err := p.combineFilters(md, append(filters[:len(filters):len(filters)], p.deleteScope(md)...))
+	if err != nil {
+		return 0, err
 	}
-	if len(models) > 1 {
-		log.Fatalf("Found %d entries for unique id %s", len(models), id)
+	opts := options.Delete()
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	res, err := coll.DeleteMany(p.ctx, filter, opts)
+	p.invalidateCollection(protoreflect.FullName(coll.Name()))
+	if res != nil {
+		p.traceResults(int(res.DeletedCount))
+	}
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not delete documents from collection %s with filter %v: %w", coll.Name(), filter, writeError(err))
+	}
+	return res.DeletedCount, nil
+}
+
+// combineFilters joins the filters with $and into a single filter, nested
+// $and filters are flattened. The fields of the filters are validated
+// against the message, see WithoutFilterValidation.
+func (p *BoundProtoStore) combineFilters(md protoreflect.MessageDescriptor, filters []bson.D) (bson.D, error) {
+	if !p.protoStore.settings.skipFilterValidation {
+		for _, filter := range filters {
+			if err := validateFilter(md, filter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	filters = flattenAnd(filters)
+	filter := bson.D{}
+	if len(filters) > 1 { // a $and with Value: [] is always false
+		filter = bson.D{bson.E{Key: "$and", Value: filters}}
+	} else if len(filters) == 1 {
+		filter = filters[0]
+	}
+	translated, err := p.protoStore.settings.translateFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not translate filter %v: %w", filter, err)
+	}
+	p.traceFilter(translated)
+	return translated.(bson.D), nil
+}
+
+// queryFilter combines the filters like combineFilters, but also applies
+// the scope of the query options.
+func (p *BoundProtoStore) queryFilter(md protoreflect.MessageDescriptor, filters []bson.D) (bson.D, error) {
+	return p.combineFilters(md, append(filters[:len(filters):len(filters)], p.scope(md)...))
+}
+
+// scope returns the filters the query options add to every query: hiding
+// soft-deleted documents unless the query includes them, and those of
+// deleteScope.
+func (p *BoundProtoStore) scope(md protoreflect.MessageDescriptor) []bson.D {
+	var filters []bson.D
+	if !p.query.includeDeleted {
+		filters = append(filters, bson.D{notDeleted})
+	}
+	return append(filters, p.deleteScope(md)...)
+}
+
+// deleteScope returns the filters the query options add to the deletes of
+// many documents: hiding the documents of other users for MineOnly and
+// those of other versions for WithTypeVersions. Soft-deleted documents are
+// removed regardless, Purge only removes those.
+func (p *BoundProtoStore) deleteScope(md protoreflect.MessageDescriptor) []bson.D {
+	var filters []bson.D
+	if p.query.mineOnly {
+		filters = append(filters, CreatedBy(p.user.ID))
+	}
+	if p.query.typeVersions != nil {
+		types := make(bson.A, 0, len(p.query.typeVersions))
+		for _, version := range p.query.typeVersions {
+			types = append(types, formatTypeValue(md.FullName(), version))
+		}
+		filters = append(filters, In(fieldType, types...))
+	}
+	return filters
+}
+
+// collection returns the collection of the model within the database of
+// the realm of the user.
+func (p *BoundProtoStore) collection(model func() protoreflect.ProtoMessage) (*mongo.Collection, error) {
+	if err := p.ready(); err != nil {
+		return nil, err
+	}
+	tableName := model().ProtoReflect().Descriptor().FullName()
+	return p.db(p.user.Realm).Collection(string(tableName), p.collectionOptions()), nil
+}
+
+// collectionOptions returns the read preference and the write concern of
+// the query for a collection. Reads ignore the write concern and writes
+// the read preference.
+func (p *BoundProtoStore) collectionOptions() *options.CollectionOptions {
+	opts := options.Collection()
+	if rp := p.readPref(); rp != nil {
+		opts.SetReadPreference(rp)
 	}
-	return models[0], true
+	if wc := p.writeConcern(); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return opts
+}
+
+// writeConcern returns the write concern of the query, or the default of
+// the store, or nil to leave it to the client.
+func (p *BoundProtoStore) writeConcern() *writeconcern.WriteConcern {
+	if p.query.writeConcern != nil {
+		return p.query.writeConcern
+	}
+	return p.protoStore.settings.writeConcern
+}
+
+// readPref returns the read preference of the query, or the default of
+// the store, or nil to leave it to the client.
+func (p *BoundProtoStore) readPref() *readpref.ReadPref {
+	if p.query.readPref != nil {
+		return p.query.readPref
+	}
+	return p.protoStore.settings.readPref
 }
 
 // db returns the database with the given name. If it does not
@@ -188,24 +1109,138 @@ func (p *BoundProtoStore) db(name string) *mongo.Database {
 	return db
 }
 
-func toMap(message protoreflect.ProtoMessage) map[string]interface{} {
-	encoded, err := protojson.Marshal(message)
+// validateDescriptor checks that the message can be stored: an id field
+// has to be a string, as it holds the hex of the document id, and no
+// top-level field may collide with the metadata the store injects.
+func validateDescriptor(md protoreflect.MessageDescriptor) error {
+	if idField := md.Fields().ByName("id"); idField != nil {
+		if idField.Kind() != protoreflect.StringKind || idField.IsList() || idField.IsMap() {
+			kind := idField.Kind().String()
+			if idField.IsList() {
+				kind = "repeated " + kind
+			}
+			if idField.IsMap() {
+				kind = "map"
+			}
+			return fmt.Errorf("message %s declares id as %s; ProtoStore requires string", md.FullName(), kind)
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		for _, name := range metadataFields {
+			if string(field.Name()) == name || field.JSONName() == name {
+				return fmt.Errorf("message %s declares field %s, which collides with the metadata field %s of ProtoStore", md.FullName(), field.Name(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// fromDoc decodes a document read from the database into a message of
+// the model. If the message has an id field, it is set to the id of the
+// document.
+func (s *settings) fromDoc(model func() protoreflect.ProtoMessage, doc bson.M) (StoredMessage, error) {
+	m := model()
+	tableName := m.ProtoReflect().Descriptor().FullName()
+
+	id, err := s.decodeID(string(tableName), doc[fieldID])
 	if err != nil {
-		log.Fatalf("Could not encode proto-mesage: %v", err)
+		return StoredMessage{}, fmt.Errorf("%v: %w", err, ErrDataCorruption)
+	}
+	delete(doc, "id")
+	if m.ProtoReflect().Descriptor().Fields().ByName("id") != nil {
+		doc["id"] = id
+	}
+	version := 0
+	if t, ok := doc[fieldType].(string); ok {
+		if _, v, err := parseTypeValue(t); err == nil {
+			version = v
+		}
+	}
+
+	if err := s.fromMap(m, doc); err != nil {
+		return StoredMessage{}, fmt.Errorf("could not read document %s of collection %s: %w", id, tableName, err)
+	}
+	return StoredMessage{ID: id, Message: m, Version: version}, nil
+}
+
+// fromMap decodes the fields of the document into the message, the
+// reverse of toMap. The document is changed along the way.
+func (s *settings) fromMap(m protoreflect.ProtoMessage, doc map[string]interface{}) error {
+	md := m.ProtoReflect().Descriptor()
+	if err := checkOneofs(md, doc); err != nil {
+		return err
+	}
+	s.fromGeoJSON(md, doc)
+	if s.directConversion {
+		return s.directFromMap(m.ProtoReflect(), doc)
+	}
+	if err := fromBSONValues(md, doc); err != nil {
+		return err
+	}
+	sanitize(doc)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(doc); err != nil {
+		return fmt.Errorf("could not reencode document as json: %w", err)
+	}
+	if err := s.unmarshalOptions().Unmarshal(buf.Bytes(), m); err != nil {
+		return fmt.Errorf("could not read protobuf message %s: %w", md.FullName(), err)
+	}
+	return nil
+}
+
+// absentFields returns the top-level fields of the message which are
+// not part of the document as an $unset specification.
+func absentFields(md protoreflect.MessageDescriptor, doc map[string]interface{}) bson.D {
+	unset := bson.D{}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		name := fields.Get(i).JSONName()
+		if _, ok := doc[name]; !ok {
+			unset = append(unset, bson.E{Key: name, Value: ""})
+		}
+	}
+	return unset
+}
+
+// toMap converts the message into the document stored in the database.
+func (s *settings) toMap(message protoreflect.ProtoMessage) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := s.toMapInto(message, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// toMapInto works like toMap, but writes the top-level fields into doc,
+// which has to be empty. This reuses the document of a previous message,
+// see getDoc.
+func (s *settings) toMapInto(message protoreflect.ProtoMessage, doc map[string]interface{}) error {
+	if s.directConversion {
+		if _, err := s.directToMap(message.ProtoReflect(), doc); err != nil {
+			return s.encodeError(message, err)
+		}
+		return nil
+	}
+	encoded, err := s.marshalOptions().Marshal(message)
+	if err != nil {
+		return s.encodeError(message, err)
+	}
+	// decoding into a map adds to it instead of allocating a new one
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return fmt.Errorf("could not decode json of proto-message %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
 	}
-	var res map[string]interface{}
-	json.Unmarshal(encoded, &res)
-	return res
+	return toBSONValues(message.ProtoReflect().Descriptor(), doc)
 }
 
-func Eq(col string, value interface{}) bson.D {
-	return bson.D{
-		bson.E{Key: "$and",
-			Value: bson.A{
-				bson.D{
-					bson.E{Key: col, Value: bson.D{bson.E{Key: "$eq", Value: value}}},
-				},
-			},
-		},
+// encodeError explains why the message could not be encoded.
+func (s *settings) encodeError(message protoreflect.ProtoMessage, err error) error {
+	name := message.ProtoReflect().Descriptor().FullName()
+	if urls := unresolvedAnys(message.ProtoReflect(), s.resolver); len(urls) > 0 {
+		return fmt.Errorf("could not encode proto-message %s, the types of the Any fields %v are unknown, see WithTypeResolver: %w", name, urls, err)
 	}
+	return fmt.Errorf("could not encode proto-message %s: %w", name, err)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SortOrder is the direction of Query.Sort.
+type SortOrder bool
+
+const (
+	// Asc sorts the smallest values first.
+	Asc SortOrder = true
+	// Desc sorts the largest values first.
+	Desc SortOrder = false
+)
+
+// Query builds a query step by step, e.g.
+//
+//	store.Query(model).Where(Eq("name", "Tom")).Where(Gt("age", 18)).Sort("name", Asc).Limit(20).Find()
+//
+// A Query is immutable: every method returns a copy, so a partially built
+// query can be shared, also between goroutines, and refined differently.
+// It runs through the same methods of the store as the filters and
+// options it was built from, see Filters and Options.
+type Query struct {
+	store   *BoundProtoStore
+	model   func() protoreflect.ProtoMessage
+	filters []bson.D
+	opts    []QueryOption
+}
+
+// Query starts a query of the documents of the model, which matches all
+// of them. The query options of the store apply to it as well.
+func (p *BoundProtoStore) Query(model func() protoreflect.ProtoMessage) Query {
+	return Query{store: p, model: model}
+}
+
+// Where returns a copy of the query, which also has to match the filters.
+func (q Query) Where(filters ...bson.D) Query {
+	q.filters = append(q.filters[:len(q.filters):len(q.filters)], filters...)
+	return q
+}
+
+// With returns a copy of the query with the options applied, e.g.
+// IncludeDeleted.
+func (q Query) With(opts ...QueryOption) Query {
+	q.opts = append(q.opts[:len(q.opts):len(q.opts)], opts...)
+	return q
+}
+
+// Sort returns a copy of the query sorted by the field as well, see
+// SortBy.
+func (q Query) Sort(field string, order SortOrder) Query {
+	return q.With(SortBy(field, bool(order)))
+}
+
+// Limit returns a copy of the query returning at most n documents, see
+// the query option Limit.
+func (q Query) Limit(n int64) Query {
+	return q.With(Limit(n))
+}
+
+// Skip returns a copy of the query leaving out the first n documents.
+func (q Query) Skip(n int64) Query {
+	return q.With(Skip(n))
+}
+
+// Filters returns the filters of the query, which can be passed to the
+// methods of the store taking filters, e.g. Filter or Page.
+func (q Query) Filters() []bson.D {
+	return append([]bson.D(nil), q.filters...)
+}
+
+// Options returns the query options of the query, which can be passed to
+// With of the store.
+func (q Query) Options() []QueryOption {
+	return append([]QueryOption(nil), q.opts...)
+}
+
+func (q Query) bound() *BoundProtoStore {
+	return q.store.With(q.opts...)
+}
+
+// Find returns the matching documents, see Filter.
+func (q Query) Find() ([]protoreflect.ProtoMessage, error) {
+	return q.bound().Filter(q.model, q.filters...)
+}
+
+// First returns the first matching document, see First of the store.
+func (q Query) First() (protoreflect.ProtoMessage, error) {
+	return q.bound().First(q.model, q.filters...)
+}
+
+// Count returns how many documents match, see Count of the store.
+func (q Query) Count() (int64, error) {
+	return q.bound().Count(q.model, q.filters...)
+}
+
+// Exists tells whether any document matches, see ExistsWhere.
+func (q Query) Exists() (bool, error) {
+	return q.bound().ExistsWhere(q.model, q.filters...)
+}
+
+// Delete removes the matching documents and returns how many were
+// removed, see DeleteMany. A query without filters fails, so it can not
+// wipe the whole collection by accident.
+func (q Query) Delete() (int64, error) {
+	return q.bound().DeleteMany(q.model, q.filters...)
+}
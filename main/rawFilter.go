@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RawFilter returns the documents matching the filter, which is passed to
+// the database as it is, e.g. for operators like $expr or $jsonSchema the
+// helpers do not cover. Unlike Filter, neither are the fields validated,
+// nor are enums and times converted, nor are soft-deleted documents left
+// out. The options apply like those of With.
+func (p *BoundProtoStore) RawFilter(model func() protoreflect.ProtoMessage, filter interface{}, opts ...QueryOption) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("RawFilter", model)
+	defer done(&err)
+	q := p.With(opts...)
+	md := model().ProtoReflect().Descriptor()
+	findOpts, err := q.findOptions(md, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.protoStore.settings.logger.Debugf("raw filter on collection %s bypasses the validation of the filter", md.FullName())
+	stored, err := q.findCapped(model, filter, findOpts)
+	if err != nil && !errors.Is(err, ErrResultTruncated) {
+		return nil, err
+	}
+	res := make([]protoreflect.ProtoMessage, 0, len(stored))
+	for _, s := range stored {
+		res = append(res, s.Message)
+	}
+	return res, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithRetry retries the idempotent operations, which are the queries like
+// Get, Filter and Count, and Store, as it upserts by id, if they fail with
+// a transient error, e.g. of the network or while the replica set elects a
+// new primary. They are tried up to maxAttempts times, waiting
+// baseBackoff after the first failure and twice as long after each
+// further one, with some jitter. An operation is not retried once its
+// context is done. Every retry is reported to the metrics, see
+// WithMetrics. If an attempt of Store created the document before it
+// failed, the retry only updates it, so Store may report an existing
+// document although the call created it.
+func WithRetry(maxAttempts int, baseBackoff time.Duration) Option {
+	return func(s *settings) {
+		s.retryAttempts = maxAttempts
+		s.retryBackoff = baseBackoff
+	}
+}
+
+// retry runs fn until it succeeds, fails with an error which is not
+// transient or the attempts of WithRetry are used up, and returns its last
+// error. The attempts pass the circuit breaker, see WithCircuitBreaker.
+func (p *BoundProtoStore) retry(operation string, fn func() error) error {
+	s := &p.protoStore.settings
+	backoff := s.retryBackoff
+	var last error
+	for attempt := 1; ; attempt++ {
+		probe, err := p.protoStore.breaker.allow(operation)
+		if err != nil {
+			if last != nil {
+				// the breaker opened while retrying
+				return last
+			}
+			return err
+		}
+		err = fn()
+		p.protoStore.breaker.record(probe, err, p.callerDone())
+		last = err
+		if err == nil || attempt >= s.retryAttempts || p.ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		s.metrics.Retried(operation, err)
+		wait := jitter(backoff)
+		s.logger.Debugf("attempt %d of %d of %s failed, retrying in %v: %v", attempt, s.retryAttempts, operation, wait, err)
+		if !sleep(p.ctx, wait) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// The server error codes which tell that the primary changed or the
+// server is shutting down, so another attempt may reach a new one.
+var transientCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isTransient tells whether the error may go away by trying again, which
+// the cancellation of a context never does. A timeout may, e.g. of the
+// network, as long as the context of the operation is not done, which
+// the callers check.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range transientCodes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// jitter adds up to half of the backoff to it, which keeps clients that
+// failed together from retrying in lockstep.
+func jitter(backoff time.Duration) time.Duration {
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// sleep waits for the duration, unless the context is done first, which
+// it tells by returning false.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
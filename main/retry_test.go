@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordingMetrics counts the retries and cache lookups of the operations.
+type recordingMetrics struct {
+	nopMetrics
+	mu      sync.Mutex
+	retried map[string]int
+	hits    map[string]int
+	misses  map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{retried: map[string]int{}, hits: map[string]int{}, misses: map[string]int{}}
+}
+
+func (m *recordingMetrics) Retried(operation string, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retried[operation]++
+}
+
+func (m *recordingMetrics) CacheHit(collection string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits[collection]++
+}
+
+func (m *recordingMetrics) CacheMiss(collection string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses[collection]++
+}
+
+// failing is an attempt which fails with the errors, one per attempt, and
+// succeeds once they are used up.
+func failing(attempts *int, errs ...error) func() error {
+	return func() error {
+		*attempts++
+		if *attempts <= len(errs) {
+			return errs[*attempts-1]
+		}
+		return nil
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", mongo.CommandError{Labels: []string{"NetworkError"}}, true},
+		{"retryable write", mongo.WriteException{Labels: []string{"RetryableWriteError"}}, true},
+		{"transaction", mongo.CommandError{Labels: []string{"TransientTransactionError"}}, true},
+		{"not primary", mongo.CommandError{Code: 10107, Message: "not master"}, true},
+		{"election", fmt.Errorf("could not read: %w", mongo.CommandError{Code: 11602}), true},
+		{"deadline", fmt.Errorf("could not read: %w", context.DeadlineExceeded), true},
+		{"duplicate key", mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, false},
+		{"unauthorized", mongo.CommandError{Code: 13}, false},
+		{"canceled", fmt.Errorf("could not read: %w", context.Canceled), false},
+		{"not found", ErrNotFound, false},
+	} {
+		if got := isTransient(c.err); got != c.want {
+			t.Errorf("%s: isTransient(%v) = %t, want %t", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	// wrapped, as the errors of the driver can not be compared
+	transient := fmt.Errorf("could not read: %w", mongo.CommandError{Code: 189, Message: "primary stepped down"})
+	permanent := fmt.Errorf("could not read: %w", mongo.CommandError{Code: 13, Message: "unauthorized"})
+	for _, c := range []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", nil, 1, nil},
+		{"transient", []error{transient, transient}, 3, nil},
+		{"attempts used up", []error{transient, transient, transient, transient}, 3, transient},
+		{"permanent", []error{permanent, transient}, 1, permanent},
+		{"transient, then permanent", []error{transient, permanent}, 2, permanent},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			metrics := newRecordingMetrics()
+			_, bound := newOfflineStore(t, context.Background(), WithRetry(3, time.Millisecond), WithMetrics(metrics))
+			attempts := 0
+			err := bound.retry("find", failing(&attempts, c.errs...))
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("got %v, want %v", err, c.wantErr)
+			}
+			if attempts != c.wantAttempts {
+				t.Errorf("attempted %d times, want %d", attempts, c.wantAttempts)
+			}
+			if got := metrics.retried["find"]; got != c.wantAttempts-1 {
+				t.Errorf("reported %d retries, want %d", got, c.wantAttempts-1)
+			}
+		})
+	}
+
+	t.Run("without WithRetry", func(t *testing.T) {
+		_, bound := newOfflineStore(t, context.Background())
+		attempts := 0
+		if err := bound.retry("find", failing(&attempts, transient)); !errors.Is(err, transient) {
+			t.Errorf("got %v, want the error of the only attempt", err)
+		}
+		if attempts != 1 {
+			t.Errorf("attempted %d times, want once", attempts)
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		_, bound := newOfflineStore(t, context.Background(), WithRetry(3, 20*time.Millisecond))
+		attempts := 0
+		start := time.Now()
+		if err := bound.retry("find", failing(&attempts, transient, transient)); err != nil {
+			t.Fatal(err)
+		}
+		// 20ms and 40ms, each with up to half of it as jitter
+		if d := time.Since(start); d < 60*time.Millisecond || d > time.Second {
+			t.Errorf("retrying took %v, want at least 60ms", d)
+		}
+	})
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, bound := newOfflineStore(t, ctx, WithRetry(3, time.Hour))
+		attempts := 0
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		if err := bound.retry("find", failing(&attempts, transient, transient)); !errors.Is(err, transient) {
+			t.Errorf("got %v, want the error of the last attempt", err)
+		}
+		if attempts != 1 {
+			t.Errorf("attempted %d times after the context was canceled, want once", attempts)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, bound := newOfflineStore(t, ctx, WithRetry(3, time.Millisecond))
+		attempts := 0
+		bound.retry("find", failing(&attempts, transient, transient))
+		if attempts != 1 {
+			t.Errorf("attempted %d times with a canceled context, want once", attempts)
+		}
+	})
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(10 * time.Millisecond); d < 10*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("jitter of 10ms is %v, want between 10ms and 15ms", d)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// the well-known types the messages of the tests refer to
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The messages of model.proto lack most kinds of fields, so the tests use
+// the messages of the package storetest, which are built at runtime:
+//
+//	enum Status { STATUS_UNKNOWN = 0; ACTIVE = 1; CLOSED = 2; }
+//	message Item { string name = 1; int32 quantity = 2; }
+//	message Location { double lng = 1; double lat = 2; }
+//	message Sample {
+//		string id = 1;
+//		int32 int32_value = 2;
+//		...
+//		oneof choice { string text = 30; int64 number = 31; Item item = 32; }
+//	}
+//
+// See sampleFields for all fields of Sample.
+
+var (
+	sampleFile protoreflect.FileDescriptor
+	// testFiles resolves the well-known types and those of sampleFile, so
+	// the messages of newTestMessage may refer to them
+	testFiles = new(protoregistry.Files)
+	// sampleTypes resolves the messages of storetest, e.g. in Any fields
+	sampleTypes = new(protoregistry.Types)
+)
+
+func sample() protoreflect.ProtoMessage {
+	return dynamicpb.NewMessage(sampleFile.Messages().ByName("Sample"))
+}
+
+func item() protoreflect.ProtoMessage {
+	return dynamicpb.NewMessage(sampleFile.Messages().ByName("Item"))
+}
+
+// newSample decodes a Sample from its protojson.
+func newSample(t testing.TB, json string) protoreflect.ProtoMessage {
+	t.Helper()
+	return decodeTestMessage(t, sample(), json)
+}
+
+// newItem decodes an Item from its protojson.
+func newItem(t testing.TB, json string) protoreflect.ProtoMessage {
+	t.Helper()
+	return decodeTestMessage(t, item(), json)
+}
+
+func decodeTestMessage(t testing.TB, m protoreflect.ProtoMessage, json string) protoreflect.ProtoMessage {
+	t.Helper()
+	if err := (protojson.UnmarshalOptions{Resolver: sampleTypes}).Unmarshal([]byte(json), m); err != nil {
+		t.Fatalf("could not decode %s: %v", json, err)
+	}
+	return m
+}
+
+// assertEqual fails the test if the messages differ.
+func assertEqual(t testing.TB, got, want protoreflect.ProtoMessage) {
+	t.Helper()
+	if !proto.Equal(got, want) {
+		t.Errorf("got %v, want %v", formatTestMessage(got), formatTestMessage(want))
+	}
+}
+
+func formatTestMessage(m protoreflect.ProtoMessage) string {
+	if m == nil {
+		return "<nil>"
+	}
+	b, err := protojson.MarshalOptions{Resolver: sampleTypes}.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+type sampleField struct {
+	name     string
+	number   int32
+	kind     descriptorpb.FieldDescriptorProto_Type
+	typeName string
+	repeated bool
+	oneof    bool
+}
+
+// sampleFields are the fields of Sample.
+var sampleFields = []sampleField{
+	{name: "id", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING},
+	{name: "int32_value", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_INT32},
+	{name: "int64_value", number: 3, kind: descriptorpb.FieldDescriptorProto_TYPE_INT64},
+	{name: "uint32_value", number: 4, kind: descriptorpb.FieldDescriptorProto_TYPE_UINT32},
+	{name: "uint64_value", number: 5, kind: descriptorpb.FieldDescriptorProto_TYPE_UINT64},
+	{name: "sint32_value", number: 6, kind: descriptorpb.FieldDescriptorProto_TYPE_SINT32},
+	{name: "sint64_value", number: 7, kind: descriptorpb.FieldDescriptorProto_TYPE_SINT64},
+	{name: "fixed32_value", number: 8, kind: descriptorpb.FieldDescriptorProto_TYPE_FIXED32},
+	{name: "fixed64_value", number: 9, kind: descriptorpb.FieldDescriptorProto_TYPE_FIXED64},
+	{name: "sfixed32_value", number: 10, kind: descriptorpb.FieldDescriptorProto_TYPE_SFIXED32},
+	{name: "sfixed64_value", number: 11, kind: descriptorpb.FieldDescriptorProto_TYPE_SFIXED64},
+	{name: "float_value", number: 12, kind: descriptorpb.FieldDescriptorProto_TYPE_FLOAT},
+	{name: "double_value", number: 13, kind: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE},
+	{name: "bool_value", number: 14, kind: descriptorpb.FieldDescriptorProto_TYPE_BOOL},
+	{name: "string_value", number: 15, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING},
+	{name: "bytes_value", number: 16, kind: descriptorpb.FieldDescriptorProto_TYPE_BYTES},
+	{name: "status", number: 17, kind: descriptorpb.FieldDescriptorProto_TYPE_ENUM, typeName: ".storetest.Status"},
+	{name: "tags", number: 18, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated: true},
+	{name: "numbers", number: 19, kind: descriptorpb.FieldDescriptorProto_TYPE_INT64, repeated: true},
+	{name: "items", number: 20, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Item", repeated: true},
+	{name: "counts", number: 21, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.CountsEntry", repeated: true},
+	{name: "labels", number: 22, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.LabelsEntry", repeated: true},
+	{name: "item_map", number: 23, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.ItemMapEntry", repeated: true},
+	{name: "at", number: 24, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Timestamp"},
+	{name: "history", number: 25, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Timestamp", repeated: true},
+	{name: "extra", number: 26, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Any"},
+	{name: "meta", number: 27, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Struct"},
+	{name: "wait", number: 28, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Duration"},
+	{name: "wrapped", number: 29, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".google.protobuf.Int64Value"},
+	{name: "text", number: 30, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING, oneof: true},
+	{name: "number", number: 31, kind: descriptorpb.FieldDescriptorProto_TYPE_INT64, oneof: true},
+	{name: "item", number: 32, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Item", oneof: true},
+	{name: "main_item", number: 33, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Item"},
+	{name: "statuses", number: 34, kind: descriptorpb.FieldDescriptorProto_TYPE_ENUM, typeName: ".storetest.Status", repeated: true},
+	{name: "location", number: 35, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Location"},
+	{name: "blobs", number: 36, kind: descriptorpb.FieldDescriptorProto_TYPE_BYTES, repeated: true},
+	{name: "blob_map", number: 37, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.BlobMapEntry", repeated: true},
+	{name: "scores", number: 38, kind: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, repeated: true},
+	{name: "flags", number: 39, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.FlagsEntry", repeated: true},
+	{name: "sizes", number: 40, kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Sample.SizesEntry", repeated: true},
+}
+
+func (f sampleField) descriptor() *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if f.repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(f.name),
+		Number: proto.Int32(f.number),
+		Label:  label.Enum(),
+		Type:   f.kind.Enum(),
+	}
+	if f.typeName != "" {
+		fd.TypeName = proto.String(f.typeName)
+	}
+	if f.oneof {
+		fd.OneofIndex = proto.Int32(0)
+	}
+	return fd
+}
+
+// wellKnownImports are the files of the well-known types the messages of
+// the tests may refer to.
+var wellKnownImports = []string{
+	"google/protobuf/any.proto",
+	"google/protobuf/duration.proto",
+	"google/protobuf/struct.proto",
+	"google/protobuf/timestamp.proto",
+	"google/protobuf/wrappers.proto",
+}
+
+// newTestMessage builds a message of the package storetest with the
+// fields, for tests which need fields Sample does not have. The fields may
+// be of the types of sampleFile.
+func newTestMessage(t testing.TB, name string, fields ...sampleField) func() protoreflect.ProtoMessage {
+	t.Helper()
+	message := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	for _, f := range fields {
+		message.Field = append(message.Field, f.descriptor())
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("storetest/" + name + ".proto"),
+		Package:     proto.String("storetest"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  append(append([]string{}, wellKnownImports...), sampleFile.Path()),
+		MessageType: []*descriptorpb.DescriptorProto{message},
+	}, testFiles)
+	if err != nil {
+		t.Fatalf("could not build message %s: %v", name, err)
+	}
+	md := file.Messages().Get(0)
+	return func() protoreflect.ProtoMessage {
+		return dynamicpb.NewMessage(md)
+	}
+}
+
+func init() {
+	entry := func(name string, key, value sampleField) *descriptorpb.DescriptorProto {
+		key.name, key.number = "key", 1
+		value.name, value.number = "value", 2
+		return &descriptorpb.DescriptorProto{
+			Name:    proto.String(name),
+			Field:   []*descriptorpb.FieldDescriptorProto{key.descriptor(), value.descriptor()},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+	}
+
+	sampleProto := &descriptorpb.DescriptorProto{
+		Name: proto.String("Sample"),
+		NestedType: []*descriptorpb.DescriptorProto{
+			entry("CountsEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_INT32}),
+			entry("LabelsEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_INT32}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}),
+			entry("ItemMapEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName: ".storetest.Item"}),
+			entry("BlobMapEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_BYTES}),
+			entry("FlagsEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_BOOL}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}),
+			entry("SizesEntry", sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_INT64}, sampleField{kind: descriptorpb.FieldDescriptorProto_TYPE_INT32}),
+		},
+		OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("choice")}},
+	}
+	for _, f := range sampleFields {
+		sampleProto.Field = append(sampleProto.Field, f.descriptor())
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("storetest/sample.proto"),
+		Package:    proto.String("storetest"),
+		Syntax:     proto.String("proto3"),
+		Dependency: wellKnownImports,
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STATUS_UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+				{Name: proto.String("CLOSED"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					sampleField{name: "name", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_STRING}.descriptor(),
+					sampleField{name: "quantity", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_INT32}.descriptor(),
+				},
+			},
+			{
+				Name: proto.String("Location"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					sampleField{name: "lng", number: 1, kind: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE}.descriptor(),
+					sampleField{name: "lat", number: 2, kind: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE}.descriptor(),
+				},
+			},
+			sampleProto,
+		},
+	}
+
+	var err error
+	sampleFile, err = protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	for _, path := range wellKnownImports {
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			panic(err)
+		}
+		if err := testFiles.RegisterFile(fd); err != nil {
+			panic(err)
+		}
+	}
+	if err := testFiles.RegisterFile(sampleFile); err != nil {
+		panic(err)
+	}
+	messages := sampleFile.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		if err := sampleTypes.RegisterMessage(dynamicpb.NewMessageType(messages.Get(i))); err != nil {
+			panic(err)
+		}
+	}
+	// the well-known types may be nested into Any fields as well
+	protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+		_ = sampleTypes.RegisterMessage(mt)
+		return true
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// indexNotFoundCode is the server error code of a query that needs an
+// index which does not exist, e.g. $text without a text index.
+const indexNotFoundCode = 27
+
+// fieldScore is where the text score is projected to. It is removed
+// before the document is decoded.
+const fieldScore = "_score"
+
+// SearchOption configures Search.
+type SearchOption func(*searchConfig)
+
+type searchConfig struct {
+	language string
+	scores   *[]float64
+}
+
+// SearchLanguage sets the language of the query, which decides about
+// stemming and stop words. The default is the language of the index.
+func SearchLanguage(language string) SearchOption {
+	return func(c *searchConfig) {
+		c.language = language
+	}
+}
+
+// WithScores stores the text score of every result in scores, in the
+// order of the results.
+func WithScores(scores *[]float64) SearchOption {
+	return func(c *searchConfig) {
+		c.scores = scores
+	}
+}
+
+// Search returns the documents matching the words of the query in any of
+// the fields of the text index of the collection, the best matches first.
+// Words in quotes match as a phrase, words with a leading minus exclude
+// documents. If the collection has no text index, an error wrapping
+// ErrNoTextIndex is returned, see EnsureTextIndex.
+func (p *BoundProtoStore) Search(model func() protoreflect.ProtoMessage, query string, opts ...SearchOption) (_ []protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("Search", model)
+	defer done(&err)
+	cfg := searchConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+
+	text := bson.D{bson.E{Key: "$search", Value: query}}
+	if cfg.language != "" {
+		text = append(text, bson.E{Key: "$language", Value: cfg.language})
+	}
+	filters := []bson.D{{bson.E{Key: "$text", Value: text}}}
+	filter, err := p.queryFilter(model().ProtoReflect().Descriptor(), filters)
+	if err != nil {
+		return nil, err
+	}
+	score := bson.D{bson.E{Key: "$meta", Value: "textScore"}}
+	findOpts := options.Find().
+		SetProjection(bson.D{bson.E{Key: fieldScore, Value: score}}).
+		SetSort(bson.D{bson.E{Key: fieldScore, Value: score}})
+	if collation := p.collation(filters); collation != nil {
+		findOpts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		findOpts.SetMaxTime(d)
+	}
+	findOpts.SetComment(p.comment(model().ProtoReflect().Descriptor()))
+
+	rows, err := coll.Find(p.ctx, filter, findOpts)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexNotFoundCode {
+		return nil, fmt.Errorf("could not search collection %s: %w", coll.Name(), ErrNoTextIndex)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not search collection %s for %q: %w", coll.Name(), query, err)
+	}
+	var results []bson.M
+	if err := rows.All(p.ctx, &results); err != nil {
+		return nil, fmt.Errorf("could not fetch results of searching collection %s for %q: %w", coll.Name(), query, err)
+	}
+
+	res := make([]protoreflect.ProtoMessage, 0, len(results))
+	scores := make([]float64, 0, len(results))
+	for _, doc := range results {
+		s, _ := doc[fieldScore].(float64)
+		delete(doc, fieldScore)
+		m, err := p.protoStore.settings.fromDoc(model, doc)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m.Message)
+		scores = append(scores, s)
+	}
+	if cfg.scores != nil {
+		*cfg.scores = scores
+	}
+	return res, nil
+}
+
+// EnsureTextIndex creates the text index Search uses on the string fields
+// of the model, unless this store already did so. The fields may be
+// nested paths like address.city. A collection can only have one text
+// index, so creating it with other fields fails until the old one is
+// dropped.
+func (p *BoundProtoStore) EnsureTextIndex(model func() protoreflect.ProtoMessage, fields ...string) (err error) {
+	p, done := p.operation("EnsureTextIndex", model)
+	defer done(&err)
+	if len(fields) == 0 {
+		return errors.New("no fields given for the text index")
+	}
+	md := model().ProtoReflect().Descriptor()
+	keys := bson.D{}
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		resolved, err := resolvePath(md, field)
+		if err != nil {
+			return err
+		}
+		if fd := resolved[len(resolved)-1]; fd.Kind() != protoreflect.StringKind || fd.IsMap() {
+			return fmt.Errorf("field %s of %s is no string field, but %s", field, md.FullName(), fd.Kind())
+		}
+		path := jsonPath(resolved)
+		keys = append(keys, bson.E{Key: path, Value: "text"})
+		paths = append(paths, path)
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	return p.ensureIndex(coll, "text:"+strings.Join(paths, ","), mongo.IndexModel{Keys: keys})
+}
+
+// SearchFields returns a filter matching the documents which contain the
+// query in any of the fields, ignoring case, e.g. for the search box of a
+// list that shall match name, email or phone. The query is matched
+// literally, characters like . or * have no special meaning. An empty
+// query matches all documents, so the filter can be passed on
+// unconditionally. Fields the model does not have make the query fail
+// with ErrUnknownField, fields which are no strings never match.
+//
+// Unlike Search, it needs no text index, but can not use an index at all,
+// so it scans all documents matching the other filters.
+func SearchFields(model func() protoreflect.ProtoMessage, query string, fields ...string) bson.D {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return bson.D{}
+	}
+	md := model().ProtoReflect().Descriptor()
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	conditions := make([]bson.D, 0, len(fields))
+	for _, field := range fields {
+		// the json path, so proto names work as well. Unknown fields are
+		// kept as they are and reported by the validation of the query.
+		path := field
+		if resolved, err := resolvePath(md, field); err == nil {
+			path = jsonPath(resolved)
+		}
+		conditions = append(conditions, bson.D{bson.E{Key: path, Value: pattern}})
+	}
+	return Or(conditions...)
+}
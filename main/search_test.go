@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSearchFieldsShape(t *testing.T) {
+	for _, query := range []string{"", "  \t"} {
+		if filter := SearchFields(person, query, "name"); !reflect.DeepEqual(filter, bson.D{}) {
+			t.Errorf("the filter of query %q is %v, want one matching all", query, filter)
+		}
+	}
+	got := SearchFields(sample, " a.b* ", "string_value", "mainItem.name")
+	pattern := primitive.Regex{Pattern: `a\.b\*`, Options: "i"}
+	want := Or(bson.D{{Key: "stringValue", Value: pattern}}, bson.D{{Key: "mainItem.name", Value: pattern}})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the filter is %v, want %v", got, want)
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	_, bound := newTestStore(t)
+	for _, p := range []*Person{
+		{Name: "Zoë Müller", Email: "zoe@example.com"},
+		{Name: "Zoe Miller", Email: "miller@example.org", Phones: []*Person_PhoneNumber{{Number: "+49 (30) 1234"}}},
+		{Name: "a.b*c", Email: "dots@example.com"},
+		{Name: "axbbbc", Email: "EXAMPLE@mail.com"},
+	} {
+		if _, _, err := bound.Store(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(query string, fields []string, filters ...bson.D) []string {
+		t.Helper()
+		found, err := bound.Filter(person, append(filters, SearchFields(person, query, fields...))...)
+		if err != nil {
+			t.Fatalf("could not search for %q: %v", query, err)
+		}
+		names := []string{}
+		for _, m := range found {
+			names = append(names, m.(*Person).Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	all := []string{"name", "email", "phones.number"}
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"zoë", []string{"Zoë Müller"}},
+		{"ZOE", []string{"Zoe Miller", "Zoë Müller"}},
+		{"müller", []string{"Zoë Müller"}},
+		{"a.b*c", []string{"a.b*c"}},
+		{"(30)", []string{"Zoe Miller"}},
+		{"example.org", []string{"Zoe Miller"}},
+		{"example", []string{"Zoe Miller", "Zoë Müller", "a.b*c", "axbbbc"}},
+		{"", []string{"Zoe Miller", "Zoë Müller", "a.b*c", "axbbbc"}},
+		{"[", []string{}},
+	} {
+		if got := search(c.query, all); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("searching for %q found %v, want %v", c.query, got, c.want)
+		}
+	}
+
+	if got, want := search(".", []string{"name"}), []string{"a.b*c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searching the names for a dot found %v, want %v", got, want)
+	}
+	// upper case letters come first
+	if got, want := search("example", all, Lt("name", "a")), []string{"Zoe Miller", "Zoë Müller"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searching with another filter found %v, want %v", got, want)
+	}
+	if got, want := search("", all, Eq("name", "axbbbc")), []string{"axbbbc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searching for nothing with another filter found %v, want %v", got, want)
+	}
+	if _, err := bound.Filter(person, SearchFields(person, "zoe", "name", "nickname")); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("searching an unknown field returned %v, want ErrUnknownField", err)
+	}
+
+	// fields which are no strings never match
+	match := seedSamples(t, bound, `{"stringValue": "a", "int32Value": 1}`)
+	if got := match(SearchFields(sample, "1", "int32Value")); len(got) != 0 {
+		t.Errorf("searching a number field found %v", got)
+	}
+	if got := match(SearchFields(sample, "A", "int32Value", "stringValue")); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("searching a number and a string field found %v", got)
+	}
+}
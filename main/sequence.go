@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// sequenceCollection holds the counters of NextSequence of a realm.
+const sequenceCollection = "_sequences"
+
+// NextSequence returns the next number of the named sequence within the
+// realm of the user, starting at 1. Every number is returned once, even
+// to concurrent callers, which makes it fit for invoice numbers and the
+// like.
+func (p *BoundProtoStore) NextSequence(name string) (_ int64, err error) {
+	p, done := p.operation("NextSequence", nil)
+	defer done(&err)
+	if err := p.ready(); err != nil {
+		return 0, err
+	}
+	coll := p.db(p.user.Realm).Collection(sequenceCollection)
+	filter := bson.D{bson.E{Key: fieldID, Value: name}}
+	update := bson.D{bson.E{Key: "$inc", Value: bson.D{bson.E{Key: "value", Value: int64(1)}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var res struct {
+		Value int64 `bson:"value"`
+	}
+	err = coll.FindOneAndUpdate(p.ctx, filter, update, opts).Decode(&res)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent caller created the sequence in between, which is
+		// incremented now
+		err = coll.FindOneAndUpdate(p.ctx, filter, update, opts).Decode(&res)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get the next number of sequence %s: %w", name, err)
+	}
+	return res.Value, nil
+}
+
+// StoreSequenced sets the field of the message to the next number of the
+// named sequence and stores it. The field has to be an int64 field.
+func (p *BoundProtoStore) StoreSequenced(message protoreflect.ProtoMessage, field string, sequence string, opts ...StoreOption) (_ string, err error) {
+	p, done := p.operation("StoreSequenced", modelOf(message))
+	defer done(&err)
+	md := message.ProtoReflect().Descriptor()
+	fd := fieldByName(md, field)
+	if fd == nil {
+		return "", fmt.Errorf("message %s has no field %s", md.FullName(), field)
+	}
+	if fd.Kind() != protoreflect.Int64Kind || fd.IsList() {
+		return "", fmt.Errorf("field %s of %s is no int64 field, but %s", field, md.FullName(), fd.Kind())
+	}
+	n, err := p.NextSequence(sequence)
+	if err != nil {
+		return "", err
+	}
+	message.ProtoReflect().Set(fd, protoreflect.ValueOfInt64(n))
+	id, _, err := p.Store(message, opts...)
+	return id, err
+}
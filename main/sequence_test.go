@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestNextSequenceConcurrent(t *testing.T) {
+	_, bound := newTestStore(t)
+	const callers, calls = 10, 20
+	numbers := make(chan int64, callers*calls)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				n, err := bound.NextSequence("invoices")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				numbers <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(numbers)
+	var got []int64
+	for n := range numbers {
+		got = append(got, n)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if len(got) != callers*calls {
+		t.Fatalf("got %d numbers, want %d", len(got), callers*calls)
+	}
+	for i, n := range got {
+		if n != int64(i+1) {
+			t.Fatalf("the numbers are not unique and without gaps: %v", got)
+		}
+	}
+
+	// sequences count independently
+	if n, err := bound.NextSequence("orders"); err != nil || n != 1 {
+		t.Errorf("the first number of another sequence is %d: %v", n, err)
+	}
+	if n, err := otherUser(bound).NextSequence("invoices"); err != nil || n != callers*calls+1 {
+		t.Errorf("another user of the realm got %d: %v", n, err)
+	}
+}
+
+func TestStoreSequenced(t *testing.T) {
+	_, bound := newTestStore(t)
+	for want := int64(1); want <= 2; want++ {
+		msg := newSample(t, `{"stringValue": "invoice"}`)
+		id, err := bound.StoreSequenced(msg, "int64_value", "invoices")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := bound.Get(sample, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := got.ProtoReflect().Get(got.ProtoReflect().Descriptor().Fields().ByName("int64_value")).Int(); n != want {
+			t.Errorf("stored number %d, want %d", n, want)
+		}
+	}
+	for _, field := range []string{"unknown", "int32_value", "numbers"} {
+		if _, err := bound.StoreSequenced(sample(), field, "invoices"); err == nil {
+			t.Errorf("stored a sequence number in field %s", field)
+		}
+	}
+}
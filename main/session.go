@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WithCausalConsistency makes Bind open a causally consistent session,
+// which all operations of the bound store run in. So a query sees the
+// writes of the bound store before, even if it reads from a secondary or
+// a write was retried. The session ends with Close of the bound store, or
+// once its context is done. A bound store with a session must not be used
+// concurrently.
+func WithCausalConsistency() Option {
+	return func(s *settings) {
+		s.causalConsistency = true
+	}
+}
+
+// boundSession is the session of a bound store, which ends once.
+type boundSession struct {
+	session mongo.Session
+	once    sync.Once
+	// done stops waiting for the context to be done
+	done chan struct{}
+}
+
+// startSession opens the session of the bound store and runs its
+// operations in it. If no session can be opened, the operations run
+// without one, as they would without WithCausalConsistency.
+func (p *BoundProtoStore) startSession() {
+	session, err := p.protoStore.client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		p.protoStore.settings.logger.Warnf("could not start a causally consistent session, running without one: %v", err)
+		return
+	}
+	s := &boundSession{session: session, done: make(chan struct{})}
+	p.session = s
+	p.ctx = mongo.NewSessionContext(p.ctx, session)
+	done := p.ctx.Done()
+	go func() {
+		select {
+		case <-done:
+			s.end()
+		case <-s.done:
+		}
+	}()
+}
+
+func (s *boundSession) end() {
+	s.once.Do(func() {
+		close(s.done)
+		// the context of the bound store may be done already
+		s.session.EndSession(context.Background())
+	})
+}
+
+// Close ends the session of the bound store, see WithCausalConsistency.
+// It does nothing without a session or if the session already ended, so
+// it is safe to defer it right after Bind.
+func (p *BoundProtoStore) Close() {
+	if p.session != nil {
+		p.session.end()
+	}
+}
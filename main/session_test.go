@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessions returns the ids of the sessions the commands with the names
+// were sent in, in their order. Commands without a session have none.
+func (l *commandLog) sessions(names ...string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ids []string
+	for _, command := range l.commands {
+		for _, name := range names {
+			if _, err := command.LookupErr(name); err != nil {
+				continue
+			}
+			id := ""
+			if lsid, err := command.LookupErr("lsid", "id"); err == nil {
+				id = lsid.String()
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestCausalConsistency(t *testing.T) {
+	store, seeded := newTestStore(t, WithCausalConsistency())
+	commands := monitorCommands(t, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := store.Bind(ctx, seeded.user)
+	defer bound.Close()
+	other := store.Bind(ctx, seeded.user)
+	defer other.Close()
+
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Filter(person, Eq("name", "Ada")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Get(person, id); err != nil {
+		t.Fatal(err)
+	}
+	ids := commands.sessions("update", "find")
+	if len(ids) != 3 {
+		t.Fatalf("sent %d commands, want an update and two finds", len(ids))
+	}
+	for _, id := range ids {
+		if id == "" || id != ids[0] {
+			t.Fatalf("the commands were sent in the sessions %v, want all in the same", ids)
+		}
+	}
+	if _, err := other.Get(person, id); err != nil {
+		t.Fatal(err)
+	}
+	ids = commands.sessions("find")
+	if last := ids[len(ids)-1]; last == "" || last == ids[0] {
+		t.Errorf("another bound store sent its query in the session %s, want one of its own", last)
+	}
+
+	// Close is idempotent, and afterwards the session can not be used
+	bound.Close()
+	bound.Close()
+	if _, err := bound.Get(person, id); err == nil || !strings.Contains(err.Error(), "ended session") {
+		t.Errorf("Get after Close returned %v, want an error of the ended session", err)
+	}
+
+	// the session ends with the context of the bound store
+	cancel()
+	select {
+	case <-other.session.done:
+	case <-time.After(5 * time.Second):
+		t.Error("the session did not end with the context")
+	}
+	other.Close()
+}
+
+func TestBindWithoutCausalConsistency(t *testing.T) {
+	store, _ := newOfflineStore(t, context.Background())
+	bound := store.Bind(context.Background(), &User{Realm: "offline"})
+	if bound.session != nil {
+		t.Error("opened a session without WithCausalConsistency")
+	}
+	// nothing to end
+	bound.Close()
+	bound.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WithSingleflight makes concurrent Gets of the same document share a
+// single query, e.g. for a hot document requested by many requests at
+// once. Every caller gets a copy of the message of its own. A caller which
+// gives up waiting does not abort the query of the others. Instead, the
+// query is bound by WithDefaultTimeout, or by 30 seconds without it. Gets
+// with query options, like IncludeDeleted, or in a session, see
+// WithCausalConsistency, query on their own.
+func WithSingleflight() Option {
+	return func(s *settings) {
+		s.singleflight = true
+	}
+}
+
+// flightGroup tracks the Gets in flight, by realm, type and id.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a Get in flight. Its result is set once done is closed.
+type flight struct {
+	done    chan struct{}
+	message protoreflect.ProtoMessage
+	err     error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[string]*flight{}}
+}
+
+// do runs fn, unless a call with the same key is in flight already, and
+// waits for its result until the context is done. fn runs detached from
+// the callers, so it completes for the others when one gives up.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (protoreflect.ProtoMessage, error)) (protoreflect.ProtoMessage, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		g.flights[key] = f
+		go func() {
+			f.message, f.err = fn()
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for document: %w", ctx.Err())
+	case <-f.done:
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	// the callers must not see the changes of each other
+	return proto.Clone(f.message), nil
+}
+
+// sharedGet runs the Get in the flight of the store, if it may be shared.
+func (p *BoundProtoStore) sharedGet(model func() protoreflect.ProtoMessage, id string) (protoreflect.ProtoMessage, bool, error) {
+	if p.protoStore.flights == nil || p.session != nil || !reflect.DeepEqual(p.query, queryConfig{}) {
+		return nil, false, nil
+	}
+	m := model()
+	// the type of the model is part of the key, as models of the same
+	// message, like dynamic ones, may differ
+	key := fmt.Sprintf("%s\x00%s\x00%T\x00%s", p.user.Realm, m.ProtoReflect().Descriptor().FullName(), m, id)
+	res, err := p.protoStore.flights.do(p.ctx, key, func() (protoreflect.ProtoMessage, error) {
+		detached := *p
+		var cancel context.CancelFunc
+		detached.ctx, cancel = p.flightContext()
+		defer cancel()
+		// no caller waits for the flight in particular, so running out of
+		// time counts as a failure of the database, see callerDone
+		detached.callerCtx = detachedContext{p.ctx}
+		// the flight may outlive the Get of this caller, which logs it
+		// without the details of the flight
+		detached.trace = &opTrace{}
+		return detached.get(model, id)
+	})
+	return res, true, err
+}
+
+// flightTimeout bounds a flight without WithDefaultTimeout.
+const flightTimeout = 30 * time.Second
+
+// flightContext returns the context of a flight. It keeps the values of
+// the context of the caller which started it, but is bound by a deadline
+// of its own, as the flight runs on when that caller gives up.
+func (p *BoundProtoStore) flightContext() (context.Context, context.CancelFunc) {
+	timeout := p.protoStore.settings.defaultTimeout
+	if timeout <= 0 {
+		timeout = flightTimeout
+	}
+	return context.WithTimeout(detachedContext{p.ctx}, timeout)
+}
+
+// detachedContext keeps the values of its parent, but neither its deadline
+// nor its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestFlightWaitersGiveUp(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	calls := 0
+	fn := func() (protoreflect.ProtoMessage, error) {
+		calls++
+		<-release
+		return &Person{Name: "Ada"}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error)
+	go func() {
+		_, err := g.do(ctx, "key", fn)
+		gaveUp <- err
+	}()
+	results := make(chan protoreflect.ProtoMessage, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// wait until the flight started, so the waiters join it
+			time.Sleep(10 * time.Millisecond)
+			m, err := g.do(context.Background(), "key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- m
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-gaveUp:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("the waiter which gave up got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiter did not give up when its context ended")
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	var got []protoreflect.ProtoMessage
+	for m := range results {
+		got = append(got, m)
+	}
+	if calls != 1 {
+		t.Errorf("the flight ran %d times, want once", calls)
+	}
+	if len(got) != 2 || got[0] == got[1] || !proto.Equal(got[0], got[1]) {
+		t.Errorf("the waiters got %v, want copies of the same message", got)
+	}
+}
+
+func TestFlightContext(t *testing.T) {
+	for _, c := range []struct {
+		opts []Option
+		want time.Duration
+	}{
+		{nil, flightTimeout},
+		{[]Option{WithDefaultTimeout(time.Second)}, time.Second},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, bound := newOfflineStore(t, ctx, append(c.opts, WithSingleflight())...)
+		flightCtx, flightCancel := bound.flightContext()
+		cancel()
+		deadline, ok := flightCtx.Deadline()
+		if !ok {
+			t.Fatal("the flight has no deadline")
+		}
+		if left := time.Until(deadline); left > c.want || left < c.want-time.Second {
+			t.Errorf("the flight has %v left, want %v", left, c.want)
+		}
+		if flightCtx.Err() != nil {
+			t.Error("the flight ended with the caller which started it")
+		}
+		flightCancel()
+	}
+}
+
+func TestSingleflightSharesGets(t *testing.T) {
+	store, bound := newTestStore(t, WithSingleflight())
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := monitorCommands(t, store)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	got := make([]protoreflect.ProtoMessage, callers)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := bound.Get(person, id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got[i] = m
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	finds := log.count("find")
+	if finds == 0 || finds >= callers {
+		t.Errorf("%d concurrent Gets sent %d finds", callers, finds)
+	}
+	got[0].(*Person).Name = "changed"
+	for _, m := range got[1:] {
+		if m.(*Person).Name != "Ada" {
+			t.Fatal("the callers share the message")
+		}
+	}
+}
+
+func BenchmarkConcurrentGets(b *testing.B) {
+	for _, shared := range []bool{false, true} {
+		name := "separate"
+		var opts []Option
+		if shared {
+			name = "singleflight"
+			opts = append(opts, WithSingleflight())
+		}
+		b.Run(name, func(b *testing.B) {
+			store, bound := newTestStore(b, opts...)
+			id, _, err := bound.Store(&Person{Name: "Ada"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			log := monitorCommands(b, store)
+			// many requests at once, like for a hot document
+			b.SetParallelism(32)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := bound.Get(person, id); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(log.count("find"))/float64(b.N), "finds/op")
+		})
+	}
+}
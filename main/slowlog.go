@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WithSlowQueryLog logs the operations which take longer than the
+// threshold as warnings, see WithLogger, with their collection, realm,
+// duration, number of results and filter. The values of the filter are
+// left out, so the shape of the filter is logged, like
+// {age: {$gt: ?}}, but no data of the documents. See WithSlowQueryValues
+// to include them.
+func WithSlowQueryLog(threshold time.Duration) Option {
+	return func(s *settings) {
+		s.slowQueryThreshold = threshold
+	}
+}
+
+// WithSlowQueryValues includes the values of the filters in the slow
+// query log, see WithSlowQueryLog. The log then contains the data of the
+// documents, so only use it for debugging locally.
+func WithSlowQueryValues() Option {
+	return func(s *settings) {
+		s.slowQueryValues = true
+	}
+}
+
+// opTrace collects what the slow query log tells about an operation.
+type opTrace struct {
+	filter interface{}
+	// results is the number of results, if counted
+	results int
+	counted bool
+}
+
+// traceFilter records the filter of the operation for the slow query log.
+func (p *BoundProtoStore) traceFilter(filter interface{}) {
+	if p.trace != nil {
+		p.trace.filter = filter
+	}
+}
+
+// traceResults records the number of results of the operation for the
+// slow query log.
+func (p *BoundProtoStore) traceResults(n int) {
+	if p.trace != nil {
+		p.trace.results = n
+		p.trace.counted = true
+	}
+}
+
+// logSlow logs the operation if it took longer than the threshold of
+// WithSlowQueryLog.
+func (p *BoundProtoStore) logSlow(name string, model func() protoreflect.ProtoMessage, took time.Duration) {
+	s := &p.protoStore.settings
+	if took < s.slowQueryThreshold {
+		return
+	}
+	msg := "slow " + name
+	if model != nil {
+		msg += " on collection " + string(model().ProtoReflect().Descriptor().FullName())
+	}
+	msg += fmt.Sprintf(" of realm %s took %v", p.user.Realm, took)
+	if p.trace.counted {
+		msg += fmt.Sprintf(" with %d results", p.trace.results)
+	}
+	if p.trace.filter != nil {
+		if s.slowQueryValues {
+			msg += fmt.Sprintf(", filter %v", p.trace.filter)
+		} else {
+			msg += ", filter " + filterShape(p.trace.filter)
+		}
+	}
+	s.logger.Warnf("%s", msg)
+}
+
+// filterShape renders the keys and operators of the filter, but replaces
+// its values with ?.
+func filterShape(v interface{}) string {
+	switch v := v.(type) {
+	case bson.D:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			parts = append(parts, e.Key+": "+filterShape(e.Value))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case bson.M:
+		return mapShape(v)
+	case map[string]interface{}:
+		return mapShape(v)
+	case []bson.D:
+		parts := make([]string, 0, len(v))
+		for _, d := range v {
+			parts = append(parts, filterShape(d))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case bson.A:
+		return listShape(v)
+	case []interface{}:
+		return listShape(v)
+	}
+	return "?"
+}
+
+func mapShape(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+": "+filterShape(m[key]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// listShape renders the filters of a list, like those of $or. A list of
+// values, like those of $in, is a single ?, which hides their number.
+func listShape(list []interface{}) string {
+	parts := make([]string, 0, len(list))
+	for _, elem := range list {
+		shape := filterShape(elem)
+		if shape == "?" {
+			return "?"
+		}
+		parts = append(parts, shape)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// notDeleted matches all documents which are not soft-deleted.
+var notDeleted = bson.E{Key: fieldDeletedAt, Value: bson.D{bson.E{Key: "$exists", Value: false}}}
+
+// SoftDelete marks the document with the given id as deleted instead of
+// removing it. It records when and by whom it was deleted. Afterwards,
+// the document is invisible to Filter, All and Get unless the query
+// includes deleted documents, see IncludeDeleted. If there is no such
+// document, or it is already deleted, an error wrapping ErrNotFound is
+// returned.
+func (p *BoundProtoStore) SoftDelete(model func() protoreflect.ProtoMessage, id string) (err error) {
+	p, done := p.operation("SoftDelete", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	update := bson.D{
+		bson.E{Key: "$currentDate", Value: bson.D{bson.E{Key: fieldDeletedAt, Value: bson.D{bson.E{Key: "$type", Value: "date"}}}}},
+		bson.E{Key: "$set", Value: bson.D{bson.E{Key: fieldDeletedBy, Value: p.user.ID}}},
+	}
+	res, err := coll.UpdateOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}, notDeleted}, update)
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not soft-delete document %s in collection %s: %w", id, coll.Name(), writeError(err))
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
+
+// deleted matches all documents which are soft-deleted.
+var deleted = bson.E{Key: fieldDeletedAt, Value: bson.D{bson.E{Key: "$exists", Value: true}}}
+
+// isDeleted tells whether a soft-deleted document matches the filter. An
+// upsert which excludes the deleted documents fails with a duplicate key
+// error on such a document, but also on a violated unique index, so the
+// error only means ErrDeleted if this holds.
+func (p *BoundProtoStore) isDeleted(coll *mongo.Collection, filter bson.D) bool {
+	filter = append(filter[:len(filter):len(filter)], deleted)
+	n, err := coll.CountDocuments(p.ctx, filter, options.Count().SetLimit(1))
+	return err == nil && n > 0
+}
+
+// Restore makes a soft-deleted document visible again. If there is no
+// soft-deleted document with the given id, an error wrapping ErrNotFound
+// is returned.
+func (p *BoundProtoStore) Restore(model func() protoreflect.ProtoMessage, id string) (err error) {
+	p, done := p.operation("Restore", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	update := bson.D{bson.E{Key: "$unset", Value: bson.D{
+		bson.E{Key: fieldDeletedAt, Value: ""},
+		bson.E{Key: fieldDeletedBy, Value: ""},
+	}}}
+	res, err := coll.UpdateOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}, deleted}, update)
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not restore document %s in collection %s: %w", id, coll.Name(), writeError(err))
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no soft-deleted document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
+
+// Purge permanently removes the soft-deleted documents matching the
+// filters, which are combined like in Filter, and returns how many were
+// removed. Documents which are not soft-deleted are never touched. For
+// retention policies, combine it with DeletedBefore.
+func (p *BoundProtoStore) Purge(model func() protoreflect.ProtoMessage, filters ...bson.D) (_ int64, err error) {
+	p, done := p.operation("Purge", model)
+	defer done(&err)
+	filters = append(filters[:len(filters):len(filters)], bson.D{deleted})
+	return p.deleteMany(model, filters)
+}
+
+// DeletedBefore matches the documents which were soft-deleted before the
+// given time.
+func DeletedBefore(t time.Time) bson.D {
+	return bson.D{bson.E{Key: fieldDeletedAt, Value: bson.D{bson.E{Key: "$lt", Value: t}}}}
+}
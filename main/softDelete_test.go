@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestSoftDelete(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bound.Store(&Person{Name: "Grace"}); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Second)
+	if err := bound.SoftDelete(person, id); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := rawDoc(t, bound, person, id)
+	if at, ok := doc[fieldDeletedAt].(primitive.DateTime); !ok || at.Time().Before(before) {
+		t.Errorf("soft delete stored %s %#v, want the current date", fieldDeletedAt, doc[fieldDeletedAt])
+	}
+	if !reflect.DeepEqual(doc[fieldDeletedBy], doc[fieldCreatedBy]) {
+		t.Errorf("soft delete stored %s %#v, want the user %#v", fieldDeletedBy, doc[fieldDeletedBy], doc[fieldCreatedBy])
+	}
+
+	if _, err := bound.Get(person, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a soft-deleted document returned %v, want ErrNotFound", err)
+	}
+	if found, err := bound.Filter(person); err != nil || len(found) != 1 {
+		t.Errorf("Filter found %d documents with the soft-deleted one: %v", len(found), err)
+	}
+	included := bound.With(IncludeDeleted())
+	if _, err := included.Get(person, id); err != nil {
+		t.Errorf("Get with IncludeDeleted returned %v", err)
+	}
+	if found, err := included.Filter(person); err != nil || len(found) != 2 {
+		t.Errorf("Filter with IncludeDeleted found %d documents: %v", len(found), err)
+	}
+
+	if err := bound.SoftDelete(person, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice returned %v, want ErrNotFound", err)
+	}
+	if _, _, err := bound.Store(&Person{Id: id, Name: "Ada Lovelace"}); !errors.Is(err, ErrDeleted) {
+		t.Errorf("storing a soft-deleted document returned %v, want ErrDeleted", err)
+	}
+	if _, _, err := bound.Store(&Person{Id: id, Name: "Ada Lovelace"}, WithRestore()); err != nil {
+		t.Fatalf("could not restore by storing: %v", err)
+	}
+	got, err := bound.Get(person, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.(*Person).Name != "Ada Lovelace" {
+		t.Errorf("read %v after the restore", got)
+	}
+}
+
+func TestDuplicateKeyIsNotErrDeleted(t *testing.T) {
+	_, bound := newTestStore(t)
+	// a unique index of the application, which no soft-deleted document
+	// violates
+	coll := bound.db(bound.user.Realm).Collection("main.Person")
+	_, err := coll.Indexes().CreateOne(bound.ctx, mongo.IndexModel{
+		Keys:    bson.D{bson.E{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bound.Store(&Person{Name: "Ada", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	grace := func() *Person { return &Person{Name: "Grace", Email: "ada@example.com"} }
+	_, _, err = bound.Store(grace())
+	if !mongo.IsDuplicateKeyError(err) || errors.Is(err, ErrDeleted) {
+		t.Errorf("Store violating a unique index returned %v, want the duplicate key error", err)
+	}
+	if _, err := bound.StoreMany([]protoreflect.ProtoMessage{grace()}); err == nil || errors.Is(err, ErrDeleted) {
+		t.Errorf("StoreMany violating a unique index returned %v, want the duplicate key error", err)
+	}
+	if _, _, err := bound.GetOrCreate(grace(), "name"); err == nil || errors.Is(err, ErrDeleted) {
+		t.Errorf("GetOrCreate violating a unique index returned %v, want the duplicate key error", err)
+	}
+	if _, _, err := bound.UpsertByKey(grace(), "name"); err == nil || errors.Is(err, ErrDeleted) {
+		t.Errorf("UpsertByKey violating a unique index returned %v, want the duplicate key error", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.Restore(person, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restoring a live document returned %v, want ErrNotFound", err)
+	}
+	if err := bound.Restore(person, primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restoring a missing document returned %v, want ErrNotFound", err)
+	}
+
+	if err := bound.SoftDelete(person, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.Restore(person, id); err != nil {
+		t.Fatal(err)
+	}
+	doc := rawDoc(t, bound, person, id)
+	if _, ok := doc[fieldDeletedAt]; ok {
+		t.Errorf("the restored document keeps %s", fieldDeletedAt)
+	}
+	if _, ok := doc[fieldDeletedBy]; ok {
+		t.Errorf("the restored document keeps %s", fieldDeletedBy)
+	}
+	if _, err := bound.Get(person, id); err != nil {
+		t.Errorf("Get of the restored document returned %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	_, bound := newTestStore(t)
+	ids := map[string]string{}
+	for _, name := range []string{"live", "old", "recent"} {
+		id, _, err := bound.Store(&Person{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+	for _, name := range []string{"old", "recent"} {
+		if err := bound.SoftDelete(person, ids[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deleted two days ago
+	coll := bound.db(bound.user.Realm).Collection("main.Person")
+	old := rawDoc(t, bound, person, ids["old"])
+	twoDaysAgo := primitive.NewDateTimeFromTime(time.Now().Add(-48 * time.Hour))
+	if _, err := coll.UpdateOne(bound.ctx, bson.D{bson.E{Key: fieldID, Value: old[fieldID]}}, bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: fieldDeletedAt, Value: twoDaysAgo}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the filters never select live documents
+	n, err := bound.Purge(person, Eq("name", "live"))
+	if err != nil || n != 0 {
+		t.Errorf("purging by the name of a live document removed %d documents: %v", n, err)
+	}
+	n, err = bound.Purge(person, DeletedBefore(time.Now().Add(-24*time.Hour)))
+	if err != nil || n != 1 {
+		t.Errorf("purging the documents deleted before yesterday removed %d documents, want 1: %v", n, err)
+	}
+	included := bound.With(IncludeDeleted())
+	if _, err := included.Get(person, ids["old"]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of the purged document returned %v, want ErrNotFound", err)
+	}
+	if _, err := included.Get(person, ids["recent"]); err != nil {
+		t.Errorf("the recently deleted document was purged: %v", err)
+	}
+
+	n, err = bound.Purge(person)
+	if err != nil || n != 1 {
+		t.Errorf("purging all deleted documents removed %d documents, want 1: %v", n, err)
+	}
+	if _, err := bound.Get(person, ids["live"]); err != nil {
+		t.Errorf("the live document was purged: %v", err)
+	}
+}
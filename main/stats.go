@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Sum returns the sum of the numeric field over the documents matching
+// the filters, which are combined like in Filter. The field may be a
+// nested path like order.total. Documents without the field count as
+// zero.
+func (p *BoundProtoStore) Sum(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ float64, err error) {
+	p, done := p.operation("Sum", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$sum", false, filters)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return asFloat(value)
+}
+
+// Avg returns the average of the numeric field over the documents
+// matching the filters, like Sum. Documents without the field are left
+// out. If no document has the field, an error wrapping ErrNotFound is
+// returned.
+func (p *BoundProtoStore) Avg(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ float64, err error) {
+	p, done := p.operation("Avg", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$avg", false, filters)
+	if err != nil {
+		return 0, err
+	}
+	return asFloat(value)
+}
+
+// Min returns the smallest value of the numeric field among the documents
+// matching the filters, like Avg. Use MinTime for timestamp fields.
+func (p *BoundProtoStore) Min(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ float64, err error) {
+	p, done := p.operation("Min", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$min", false, filters)
+	if err != nil {
+		return 0, err
+	}
+	return asFloat(value)
+}
+
+// Max returns the largest value of the numeric field among the documents
+// matching the filters, like Avg. Use MaxTime for timestamp fields.
+func (p *BoundProtoStore) Max(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ float64, err error) {
+	p, done := p.operation("Max", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$max", false, filters)
+	if err != nil {
+		return 0, err
+	}
+	return asFloat(value)
+}
+
+// MinTime returns the earliest value of the google.protobuf.Timestamp
+// field among the documents matching the filters, like Min.
+func (p *BoundProtoStore) MinTime(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ time.Time, err error) {
+	p, done := p.operation("MinTime", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$min", true, filters)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return asTime(value)
+}
+
+// MaxTime returns the latest value of the google.protobuf.Timestamp
+// field among the documents matching the filters, like Max.
+func (p *BoundProtoStore) MaxTime(model func() protoreflect.ProtoMessage, field string, filters ...bson.D) (_ time.Time, err error) {
+	p, done := p.operation("MaxTime", model)
+	defer done(&err)
+	value, err := p.aggregateField(model, field, "$max", true, filters)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return asTime(value)
+}
+
+// aggregateField applies the accumulator of $group to the field over the
+// documents matching the filters. The field has to be numeric, or a
+// timestamp if dates is set. If no document has the field, an error
+// wrapping ErrNotFound is returned.
+func (p *BoundProtoStore) aggregateField(model func() protoreflect.ProtoMessage, field string, accumulator string, dates bool, filters []bson.D) (interface{}, error) {
+	md := model().ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, field)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range fields {
+		if fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("could not aggregate field %s of %s, as %s is repeated", field, md.FullName(), fd.Name())
+		}
+	}
+	fd := fields[len(fields)-1]
+	if dates && !isTimestamp(fd) {
+		return nil, fmt.Errorf("field %s of %s is no timestamp", field, md.FullName())
+	}
+	if !dates && !isNumeric(fd) {
+		return nil, fmt.Errorf("field %s of %s is no numeric field, but %s", field, md.FullName(), fd.Kind())
+	}
+	path := jsonPath(fields)
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return nil, err
+	}
+	fieldType := bson.D{bson.E{Key: "$type", Value: "$" + path}}
+	isSet := bson.D{bson.E{Key: "$ne", Value: bson.A{fieldType, "missing"}}}
+	isString := bson.D{bson.E{Key: "$eq", Value: bson.A{fieldType, "string"}}}
+	pipeline := mongo.Pipeline{
+		{bson.E{Key: "$match", Value: filter}},
+		{bson.E{Key: "$group", Value: bson.D{
+			bson.E{Key: "_id", Value: nil},
+			bson.E{Key: "value", Value: bson.D{bson.E{Key: accumulator, Value: "$" + path}}},
+			bson.E{Key: "count", Value: bson.D{bson.E{Key: "$sum", Value: bson.D{bson.E{Key: "$cond", Value: bson.A{isSet, 1, 0}}}}}},
+			bson.E{Key: "strings", Value: bson.D{bson.E{Key: "$sum", Value: bson.D{bson.E{Key: "$cond", Value: bson.A{isString, 1, 0}}}}}},
+		}}},
+	}
+	opts := options.Aggregate()
+	if collation := p.collation(filters); collation != nil {
+		opts.SetCollation(collation)
+	}
+	if d := p.maxTime(); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if p.query.hint != "" {
+		opts.SetHint(p.query.hint)
+	}
+	opts.SetComment(p.comment(md))
+	rows, err := coll.Aggregate(p.ctx, pipeline, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not aggregate field %s of collection %s: %w", path, coll.Name(), err)
+	}
+	var results []struct {
+		Value   interface{} `bson:"value"`
+		Count   int64       `bson:"count"`
+		Strings int64       `bson:"strings"`
+	}
+	if err := rows.All(p.ctx, &results); err != nil {
+		return nil, fmt.Errorf("could not fetch the aggregate of field %s of collection %s: %w", path, coll.Name(), err)
+	}
+	if len(results) == 0 || results[0].Count == 0 {
+		return nil, fmt.Errorf("no document of collection %s has field %s: %w", coll.Name(), path, ErrNotFound)
+	}
+	if results[0].Strings > 0 {
+		// written before 64 bit integers were stored as numbers
+		return nil, fmt.Errorf("%d documents of collection %s hold field %s as string, store them again to aggregate it", results[0].Strings, coll.Name(), path)
+	}
+	return results[0].Value, nil
+}
+
+func asFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case primitive.Decimal128:
+		return strconv.ParseFloat(v.String(), 64)
+	}
+	return 0, fmt.Errorf("aggregate %v is no number, but %T", value, value)
+}
+
+func asTime(value interface{}) (time.Time, error) {
+	if t, ok := value.(primitive.DateTime); ok {
+		return t.Time().UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("aggregate %v is no date, but %T", value, value)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ResultStream reads the results of a query one by one, see FilterStream.
+// It is not safe for concurrent use.
+type ResultStream struct {
+	cursor   *mongo.Cursor
+	model    func() protoreflect.ProtoMessage
+	settings *settings
+	filter   interface{}
+}
+
+// FilterStream runs the query of Filter, but decodes the documents one at
+// a time while they are read, so a large result does not have to fit into
+// memory. The cap of WithMaxResults does not apply. Close the stream when
+// done, also if it was not read to the end.
+func (p *BoundProtoStore) FilterStream(model func() protoreflect.ProtoMessage, filters ...bson.D) (*ResultStream, error) {
+	md := model().ProtoReflect().Descriptor()
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.findOptions(md, filters)
+	if err != nil {
+		return nil, err
+	}
+	return p.stream(model, filter, opts)
+}
+
+// stream runs the query and returns a stream of its results.
+func (p *BoundProtoStore) stream(model func() protoreflect.ProtoMessage, filter interface{}, opts *options.FindOptions) (*ResultStream, error) {
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := coll.Find(p.ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not read collection %s with filter %v: %w", coll.Name(), filter, err)
+	}
+	return &ResultStream{
+		cursor:   cursor,
+		model:    model,
+		settings: &p.protoStore.settings,
+		filter:   filter,
+	}, nil
+}
+
+// Next returns the next result. After the last one, it returns io.EOF.
+func (s *ResultStream) Next(ctx context.Context) (protoreflect.ProtoMessage, error) {
+	m, err := s.NextStored(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.Message, nil
+}
+
+// NextStored works like Next, but also returns the id of the document,
+// like FilterStored does.
+func (s *ResultStream) NextStored(ctx context.Context) (StoredMessage, error) {
+	doc, err := s.nextDoc(ctx)
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	return s.settings.fromDoc(s.model, doc)
+}
+
+// nextDoc returns the next document, before it is decoded into a message.
+// After the last one, it returns io.EOF.
+func (s *ResultStream) nextDoc(ctx context.Context) (bson.M, error) {
+	if !s.cursor.Next(ctx) {
+		if err := s.cursor.Err(); err != nil {
+			return nil, fmt.Errorf("could not fetch results of collection %s with filter %v: %w", s.collection(), s.filter, err)
+		}
+		return nil, io.EOF
+	}
+	var doc bson.M
+	if err := s.cursor.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not decode result of collection %s: %w", s.collection(), err)
+	}
+	return doc, nil
+}
+
+// Close releases the cursor of the stream on the server.
+func (s *ResultStream) Close() error {
+	// the context of the query may be canceled already, which must not
+	// keep the cursor from being released
+	if err := s.cursor.Close(context.Background()); err != nil {
+		return fmt.Errorf("could not close cursor of collection %s: %w", s.collection(), err)
+	}
+	return nil
+}
+
+func (s *ResultStream) collection() protoreflect.FullName {
+	return s.model().ProtoReflect().Descriptor().FullName()
+}
+
+// ForEachBatch runs the query of FilterStream and passes the results to fn
+// in batches of batchSize, e.g. to write them elsewhere in bulk. The last
+// batch may be smaller. The documents are read from the database in
+// batches of the same size, regardless of BatchSize. The first error of
+// fn aborts the iteration and is returned.
+func (p *BoundProtoStore) ForEachBatch(model func() protoreflect.ProtoMessage, batchSize int, fn func(batch []protoreflect.ProtoMessage) error, filters ...bson.D) (err error) {
+	p, done := p.operation("ForEachBatch", model)
+	defer done(&err)
+	if batchSize < 1 || batchSize > math.MaxInt32 {
+		return fmt.Errorf("invalid batch size %d, it must be between 1 and %d", batchSize, math.MaxInt32)
+	}
+	md := model().ProtoReflect().Descriptor()
+	filter, err := p.queryFilter(md, filters)
+	if err != nil {
+		return err
+	}
+	opts, err := p.findOptions(md, filters)
+	if err != nil {
+		return err
+	}
+	opts.SetBatchSize(int32(batchSize))
+	stream, err := p.stream(model, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	batch := make([]protoreflect.ProtoMessage, 0, batchSize)
+	for {
+		m, err := stream.Next(p.ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, m)
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			// fn may keep the batch, so it gets a new one
+			batch = make([]protoreflect.ProtoMessage, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// decodeAll reads the remaining documents and decodes them with the
+// number of workers. The results keep the order of the documents. The
+// errors of all documents are returned together as a *DecodeError, once
+// all workers stopped.
+func (s *ResultStream) decodeAll(ctx context.Context, workers int) ([]StoredMessage, error) {
+	docs := make([]bson.M, 0)
+	for {
+		doc, err := s.nextDoc(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	res := make([]StoredMessage, len(docs))
+	errs := make([]error, len(docs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				res[i], errs[i] = s.settings.fromDoc(s.model, docs[i])
+			}
+		}()
+	}
+	for i := range docs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	decodeErr := &DecodeError{Errors: map[int]error{}}
+	for i, err := range errs {
+		if err != nil {
+			decodeErr.Errors[i] = err
+		}
+	}
+	if len(decodeErr.Errors) > 0 {
+		return nil, decodeErr
+	}
+	return res, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// readStream reads the stream to its end and closes it.
+func readStream(t *testing.T, stream *ResultStream) []protoreflect.ProtoMessage {
+	t.Helper()
+	defer stream.Close()
+	var res []protoreflect.ProtoMessage
+	for {
+		m, err := stream.Next(context.Background())
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatalf("could not read result %d: %v", len(res), err)
+		}
+		res = append(res, m)
+	}
+}
+
+func TestFilterStream(t *testing.T) {
+	_, bound := newTestStore(t, WithMaxResults(100))
+	messages := make([]protoreflect.ProtoMessage, 300)
+	for i := range messages {
+		messages[i] = newSample(t, fmt.Sprintf(`{"stringValue": "%04d", "int32Value": %d, "tags": ["t%d"]}`, i, i%7, i%3))
+	}
+	ids, err := bound.StoreMany(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.SoftDelete(sample, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	sorted := bound.With(SortBy("int32Value", false))
+
+	// more results than Filter returns at most
+	stream, err := sorted.FilterStream(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := readStream(t, stream)
+	if len(streamed) != 299 {
+		t.Errorf("streamed %d samples, want all 299 which are not deleted", len(streamed))
+	}
+	if _, err := sorted.Filter(sample); !errors.Is(err, ErrResultTruncated) {
+		t.Errorf("Filter of all samples returned %v, want ErrResultTruncated", err)
+	}
+
+	// the same results as Filter, in the same order
+	filters := []bson.D{Eq("tags", "t1"), Lt("int32Value", int32(3))}
+	found, err := sorted.Filter(sample, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err = sorted.FilterStream(sample, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed = readStream(t, stream)
+	if len(found) == 0 || len(streamed) != len(found) {
+		t.Fatalf("streamed %d samples, Filter found %d", len(streamed), len(found))
+	}
+	for i := range found {
+		if !proto.Equal(streamed[i], found[i]) {
+			t.Fatalf("streamed %v as result %d, Filter found %v", formatTestMessage(streamed[i]), i, formatTestMessage(found[i]))
+		}
+	}
+
+	// the ids of NextStored, and io.EOF after the last result
+	stream, err = bound.FilterStream(sample, Eq("stringValue", "0001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	stored, err := stream.NextStored(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ID != ids[1] {
+		t.Errorf("streamed id %s, want %s", stored.ID, ids[1])
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stream.Next(context.Background()); err != io.EOF {
+			t.Errorf("reading after the last result returned %v, want io.EOF", err)
+		}
+	}
+}
+
+func TestFilterStreamErrors(t *testing.T) {
+	_, bound := newTestStore(t)
+	if _, err := bound.FilterStream(sample, Eq("nowhere", 1)); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("streaming by an unknown field returned %v, want ErrUnknownField", err)
+	}
+	if _, err := bound.With(SortBy("nowhere", true)).FilterStream(sample); err == nil {
+		t.Error("streamed sorted by an unknown field")
+	}
+
+	seedSamples(t, bound, `{"stringValue": "a"}`, `{"stringValue": "b"}`)
+	// a document no message can be read from, as both members of a oneof
+	// are set
+	_, err := bound.db(bound.user.Realm).Collection("storetest.Sample").InsertOne(bound.ctx, bson.M{
+		fieldID:   primitive.NewObjectID(),
+		fieldType: "storetest.Sample:1",
+		"text":    "hi",
+		"number":  "5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := bound.With(SortBy(MetaID, true)).FilterStream(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stream.Next(context.Background()); err != nil {
+			t.Fatalf("could not read result %d: %v", i, err)
+		}
+	}
+	if _, err := stream.Next(context.Background()); !errors.Is(err, ErrDataCorruption) {
+		t.Errorf("reading the corrupt document returned %v, want ErrDataCorruption", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("could not close the stream: %v", err)
+	}
+
+	// the first batch is read with the query, the next one with the
+	// context of Next
+	stream, err = bound.With(BatchSize(1)).FilterStream(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := stream.Next(ctx); err == nil || err == io.EOF {
+		t.Errorf("reading with a canceled context returned %v", err)
+	}
+	// closed before its end
+	if err := stream.Close(); err != nil {
+		t.Errorf("could not close the stream: %v", err)
+	}
+}
+
+func TestDecodeConcurrency(t *testing.T) {
+	_, bound := newTestStore(t)
+	messages := make([]protoreflect.ProtoMessage, 50)
+	for i := range messages {
+		messages[i] = newSample(t, fmt.Sprintf(`{"stringValue": "%02d", "int64Value": "%d", "tags": ["t%d"]}`, i, i*1000, i%3))
+	}
+	if _, err := bound.StoreMany(messages); err != nil {
+		t.Fatal(err)
+	}
+	sequential, err := bound.With(SortBy("int64Value", false)).Filter(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 8, 100} {
+		store, _ := newTestStore(t, WithDecodeConcurrency(workers))
+		concurrent := store.Bind(bound.ctx, bound.user)
+		found, err := concurrent.With(SortBy("int64Value", false)).Filter(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != len(sequential) {
+			t.Fatalf("%d workers decoded %d samples, want %d", workers, len(found), len(sequential))
+		}
+		for i := range found {
+			if !proto.Equal(found[i], sequential[i]) {
+				t.Fatalf("%d workers decoded %v as result %d, want %v", workers, formatTestMessage(found[i]), i, formatTestMessage(sequential[i]))
+			}
+		}
+	}
+
+	// documents no message can be read from, as both members of a oneof
+	// are set, are reported together. They come last, after the sample
+	// without int64Value.
+	for i := 0; i < 2; i++ {
+		_, err := bound.db(bound.user.Realm).Collection("storetest.Sample").InsertOne(bound.ctx, bson.M{
+			fieldID:   primitive.NewObjectID(),
+			fieldType: "storetest.Sample:1",
+			"text":    "hi",
+			"number":  "5",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, _ := newTestStore(t, WithDecodeConcurrency(4))
+	concurrent := store.Bind(bound.ctx, bound.user)
+	_, err = concurrent.With(SortBy("int64Value", false)).Filter(sample)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("decoding the corrupt documents returned %v, want a DecodeError", err)
+	}
+	if len(decodeErr.Errors) != 2 || decodeErr.Errors[50] == nil || decodeErr.Errors[51] == nil {
+		t.Errorf("the errors are those of the results %v, want 50 and 51", decodeErr.Errors)
+	}
+	if !errors.Is(err, ErrDataCorruption) {
+		t.Errorf("the error %v does not wrap ErrDataCorruption", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	err := &DecodeError{Errors: map[int]error{
+		7: fmt.Errorf("bad: %w", ErrDataCorruption),
+		2: errors.New("worse"),
+	}}
+	if got, want := err.Error(), "2 documents could not be decoded: 2: worse; 7: bad: data corruption"; got != want {
+		t.Errorf("the message is %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrDataCorruption) {
+		t.Error("the error does not wrap ErrDataCorruption")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("the error wraps ErrNotFound")
+	}
+	err.Errors[9] = fmt.Errorf("could not decode: %w", &InvalidIDError{ID: 9})
+	var invalidID *InvalidIDError
+	if !errors.As(err, &invalidID) || invalidID.ID != 9 {
+		t.Errorf("errors.As found %v, want the InvalidIDError of 9", invalidID)
+	}
+}
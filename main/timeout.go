@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// WithDefaultTimeout bounds every operation of a bound store whose context
+// has no deadline, so a hung database can not block the caller forever.
+// A deadline of the context is never extended, also if it is later than
+// the timeout. An operation which runs out of time fails with an error
+// wrapping ErrTimeout.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(s *settings) {
+		s.defaultTimeout = d
+	}
+}
+
+// operation returns the store to run the operation of the name on, whose
+// context times out after the default timeout, see WithDefaultTimeout.
+// The returned func ends the operation and has to be deferred with its
+// error, which is turned into a *TimeoutError once the deadline passed.
+// It also logs the operation if it was slow, see WithSlowQueryLog. The
+// model tells the collection of the operation, if it has one.
+func (p *BoundProtoStore) operation(name string, model func() protoreflect.ProtoMessage) (*BoundProtoStore, func(*error)) {
+	s := &p.protoStore.settings
+	_, hasDeadline := p.ctx.Deadline()
+	timeout := s.defaultTimeout > 0 && !hasDeadline
+	// nested operations are logged as part of the outer one
+	traced := s.slowQueryThreshold > 0 && p.trace == nil
+	op := p
+	cancel := func() {}
+	if timeout || traced {
+		c := *p
+		if timeout {
+			c.callerCtx = p.ctx
+			c.ctx, cancel = context.WithTimeout(p.ctx, s.defaultTimeout)
+		}
+		if traced {
+			c.trace = &opTrace{}
+		}
+		op = &c
+	}
+	start := time.Now()
+	return op, func(err *error) {
+		defer cancel()
+		if traced {
+			op.logSlow(name, model, time.Since(start))
+		}
+		if *err == nil || op.ctx.Err() != context.DeadlineExceeded {
+			return
+		}
+		var timeoutErr *TimeoutError
+		if errors.As(*err, &timeoutErr) {
+			// a nested operation reported it already
+			return
+		}
+		timeoutErr = &TimeoutError{Operation: name, Err: *err}
+		if model != nil {
+			timeoutErr.Collection = model().ProtoReflect().Descriptor().FullName()
+		}
+		*err = timeoutErr
+	}
+}
+
+// callerDone tells whether the caller gave up on the operation, as opposed
+// to the operation running out of the time WithDefaultTimeout gives it.
+func (p *BoundProtoStore) callerDone() bool {
+	if p.callerCtx != nil {
+		return p.callerCtx.Err() != nil
+	}
+	return p.ctx.Err() != nil
+}
+
+// modelOf returns a model of the message for operation.
+func modelOf(message protoreflect.ProtoMessage) func() protoreflect.ProtoMessage {
+	return func() protoreflect.ProtoMessage {
+		return message
+	}
+}
+
+// ErrTimeout is returned when an operation did not complete before the
+// deadline of its context, see WithDefaultTimeout. The concrete error is a
+// *TimeoutError, which tells the operation.
+var ErrTimeout = errors.New("timeout")
+
+// TimeoutError describes an operation which ran out of time. It matches
+// ErrTimeout with errors.Is.
+type TimeoutError struct {
+	// Operation is the method of the store, like Get.
+	Operation string
+	// Collection is the collection the operation ran on, if any.
+	Collection protoreflect.FullName
+	Err        error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Collection == "" {
+		return fmt.Sprintf("%s timed out: %v", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s on collection %s timed out: %v", e.Operation, e.Collection, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
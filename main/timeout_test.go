@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOperationDeadline(t *testing.T) {
+	const timeout = time.Minute
+	for _, c := range []struct {
+		name     string
+		deadline time.Duration // of the context of the caller, 0 for none
+		want     time.Duration
+	}{
+		{"without deadline", 0, timeout},
+		{"with shorter deadline", time.Second, time.Second},
+		{"with later deadline", time.Hour, time.Hour},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			if c.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.deadline)
+				defer cancel()
+			}
+			_, bound := newOfflineStore(t, ctx, WithDefaultTimeout(timeout))
+			start := time.Now()
+			op, done := bound.operation("Get", person)
+			var err error
+			defer done(&err)
+			deadline, ok := op.ctx.Deadline()
+			if !ok {
+				t.Fatal("the operation has no deadline")
+			}
+			if d := deadline.Sub(start); d > c.want+time.Second || d < c.want-time.Second {
+				t.Errorf("the operation has %v left, want %v", d, c.want)
+			}
+		})
+	}
+}
+
+func TestOperationTimeoutError(t *testing.T) {
+	_, bound := newOfflineStore(t, context.Background(), WithDefaultTimeout(time.Millisecond))
+	err := func() (err error) {
+		op, done := bound.operation("Get", person)
+		defer done(&err)
+		<-op.ctx.Done()
+		return fmt.Errorf("could not read: %w", op.ctx.Err())
+	}()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if timeoutErr.Operation != "Get" || timeoutErr.Collection != "main.Person" {
+		t.Errorf("the error names %s on %s, want Get on main.Person", timeoutErr.Operation, timeoutErr.Collection)
+	}
+
+	// the caller giving up is no timeout of the store
+	ctx, cancel := context.WithCancel(context.Background())
+	_, bound = newOfflineStore(t, ctx, WithDefaultTimeout(time.Hour))
+	cancel()
+	err = func() (err error) {
+		op, done := bound.operation("Get", person)
+		defer done(&err)
+		return op.ctx.Err()
+	}()
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("a canceled caller got %v", err)
+	}
+}
+
+// timedOut is an attempt which waits until the operation runs out of time.
+func timedOut(op *BoundProtoStore, attempts *int) func() error {
+	return func() error {
+		*attempts++
+		<-op.ctx.Done()
+		return fmt.Errorf("could not read: %w", op.ctx.Err())
+	}
+}
+
+func TestDefaultTimeoutCountsAsFailure(t *testing.T) {
+	_, bound := newOfflineStore(t, context.Background(),
+		WithDefaultTimeout(5*time.Millisecond), WithRetry(3, time.Millisecond), WithCircuitBreaker(2, time.Hour))
+	for i := 0; i < 2; i++ {
+		attempts := 0
+		op, done := bound.operation("Get", person)
+		err := op.retry("get", timedOut(op, &attempts))
+		done(&err)
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("got %v, want ErrTimeout", err)
+		}
+		if attempts != 1 {
+			t.Errorf("the operation was attempted %d times after it ran out of time, want once", attempts)
+		}
+	}
+	op, done := bound.operation("Get", person)
+	err := op.retry("get", func() error { return nil })
+	done(&err)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("after two timeouts, got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCallerDeadlineIsNoFailure(t *testing.T) {
+	store, _ := newOfflineStore(t, context.Background(), WithRetry(3, time.Millisecond), WithCircuitBreaker(1, time.Hour))
+	for _, deadline := range []time.Duration{time.Millisecond, 0} {
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		bound := store.Bind(ctx, &User{Realm: "offline"})
+		attempts := 0
+		op, done := bound.operation("Get", person)
+		err := op.retry("get", timedOut(op, &attempts))
+		done(&err)
+		cancel()
+		if errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("the breaker opened after the caller gave up: %v", err)
+		}
+	}
+	if store.breaker.failures != 0 {
+		t.Errorf("the breaker counted %d failures of callers which gave up", store.breaker.failures)
+	}
+}
+
+func TestTimeoutIsTransient(t *testing.T) {
+	if !isTransient(fmt.Errorf("could not read: %w", context.DeadlineExceeded)) {
+		t.Error("a timeout is not transient")
+	}
+	if isTransient(fmt.Errorf("could not read: %w", context.Canceled)) {
+		t.Error("a cancellation is transient")
+	}
+}
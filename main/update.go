@@ -0,0 +1,620 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// UpdateFields writes only the fields of the message named by the mask
+// onto the existing document with the id of the message. This is what
+// PATCH-style APIs need. Paths use the proto names of the fields and may
+// name nested fields, like address.city, but must not descend into
+// repeated or map fields. A path of an unset field clears it, a path of a
+// set member of a oneof also clears the other members.
+func (p *BoundProtoStore) UpdateFields(message protoreflect.ProtoMessage, mask *fieldmaskpb.FieldMask) (err error) {
+	p, done := p.operation("UpdateFields", modelOf(message))
+	defer done(&err)
+	md := message.ProtoReflect().Descriptor()
+	table := md.FullName()
+	if len(mask.GetPaths()) == 0 {
+		return fmt.Errorf("could not update %s: the field mask is empty", table)
+	}
+	paths := make([]string, 0, len(mask.GetPaths()))
+	resolved := make([][]protoreflect.FieldDescriptor, 0, len(mask.GetPaths()))
+	for _, path := range mask.GetPaths() {
+		fields, err := resolvePath(md, path)
+		if err != nil {
+			return fmt.Errorf("invalid field mask for %s: %w", table, err)
+		}
+		for _, fd := range fields[:len(fields)-1] {
+			if fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("invalid field mask for %s: path %s descends into the repeated field %s", table, path, fd.Name())
+			}
+		}
+		paths = append(paths, jsonPath(fields))
+		resolved = append(resolved, fields)
+	}
+
+	idField := md.Fields().ByName("id")
+	if idField == nil {
+		return fmt.Errorf("could not update %s: the message has no id field", table)
+	}
+	id := message.ProtoReflect().Get(idField).String()
+
+	coll, err := p.collection(func() protoreflect.ProtoMessage { return message })
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	doc, err := p.protoStore.settings.toMap(message)
+	if err != nil {
+		return err
+	}
+	p.protoStore.settings.toGeoJSON(md, doc)
+
+	set := bson.D{}
+	unset := bson.D{}
+	masked := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		masked[path] = true
+	}
+	for i, path := range paths {
+		value, ok := lookupPath(doc, path)
+		if !ok {
+			unset = append(unset, bson.E{Key: path, Value: ""})
+			continue
+		}
+		fields := resolved[i]
+		set = append(set, bson.E{Key: path, Value: orderedField(fields[len(fields)-1], value)})
+		// like Store, writing a member of a oneof removes the others
+		for _, sibling := range oneofSiblings(resolved[i]) {
+			if !masked[sibling] {
+				masked[sibling] = true
+				unset = append(unset, bson.E{Key: sibling, Value: ""})
+			}
+		}
+	}
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	update = append(update, touchUpdatedAt)
+
+	res, err := coll.UpdateOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}, notDeleted}, update)
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not update the fields %v of document %s in collection %s: %w", paths, id, coll.Name(), writeError(err))
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
+
+// oneofSiblings returns the paths of the other members of the oneof the
+// last of the fields belongs to, if any.
+func oneofSiblings(fields []protoreflect.FieldDescriptor) []string {
+	last := fields[len(fields)-1]
+	oneof := last.ContainingOneof()
+	if oneof == nil {
+		return nil
+	}
+	var siblings []string
+	members := oneof.Fields()
+	for i := 0; i < members.Len(); i++ {
+		if member := members.Get(i); member != last {
+			path := append(fields[:len(fields)-1:len(fields)-1], member)
+			siblings = append(siblings, jsonPath(path))
+		}
+	}
+	return siblings
+}
+
+// Increment atomically adds delta to the integer field of the document
+// with the given id and returns the new value. Unlike Get, mutate and
+// Store, no concurrent increment is lost. The field may be a nested path
+// like stats.viewCount, an unset field starts at zero. Other fields fail
+// with a *FieldKindError. If the new value does not fit the field, e.g.
+// an int32 field, nothing is written and an error wrapping ErrOutOfRange
+// is returned. With an unacknowledged write concern, the new value is
+// unknown and 0 is returned.
+func (p *BoundProtoStore) Increment(model func() protoreflect.ProtoMessage, id string, field string, delta int64) (_ int64, err error) {
+	p, done := p.operation("Increment", model)
+	defer done(&err)
+	md := model().ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, field)
+	if err != nil {
+		return 0, err
+	}
+	fd := fields[len(fields)-1]
+	if !isInteger(fd) || fd.IsList() || fd.IsMap() {
+		return 0, &FieldKindError{Operation: "Increment", Message: md.FullName(), Field: field, Kind: fd.Kind(), Repeated: fd.IsList() || fd.IsMap()}
+	}
+	path := jsonPath(fields)
+	low, high, bounded := integerRange(fd)
+	if bounded && (delta > high-low || delta < low-high) {
+		return 0, fmt.Errorf("could not add %d to field %s of %s: %w", delta, field, md.FullName(), ErrOutOfRange)
+	}
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return 0, err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return 0, err
+	}
+	byID := bson.D{bson.E{Key: fieldID, Value: docID}, notDeleted}
+	filter := byID
+	if bounded {
+		// the range is checked by the filter, so the check and the
+		// increment are atomic
+		filter = append(byID[:len(byID):len(byID)], incrementBounds(path, delta, low, high)...)
+	}
+	update := bson.D{bson.E{Key: "$inc", Value: bson.D{bson.E{Key: path, Value: delta}}}, touchUpdatedAt}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{bson.E{Key: path, Value: 1}})
+	var doc bson.M
+	err = coll.FindOneAndUpdate(p.ctx, filter, update, opts).Decode(&doc)
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return 0, nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		n, err := coll.CountDocuments(p.ctx, byID, options.Count().SetLimit(1))
+		if err != nil {
+			return 0, fmt.Errorf("could not read document %s in collection %s: %w", id, coll.Name(), err)
+		}
+		if n > 0 {
+			return 0, fmt.Errorf("could not add %d to field %s of document %s in collection %s: %w", delta, path, id, coll.Name(), ErrOutOfRange)
+		}
+		return 0, fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not increment field %s of document %s in collection %s: %w", path, id, coll.Name(), writeError(err))
+	}
+	value, _ := lookupPath(doc, path)
+	switch n := value.(type) {
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("field %s of document %s in collection %s holds no number after the increment, but %T", path, id, coll.Name(), value)
+}
+
+func isInteger(fd protoreflect.FieldDescriptor) bool {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+// integerRange returns the lowest and the highest value Increment can
+// store in the integer field. Unsigned 64 bit fields are capped at the
+// highest int64, which Increment returns. The bool is false for signed 64
+// bit fields, whose overflow the database rejects itself.
+func integerRange(fd protoreflect.FieldDescriptor) (int64, int64, bool) {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return math.MinInt32, math.MaxInt32, true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return 0, math.MaxUint32, true
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return 0, math.MaxInt64, true
+	}
+	return 0, 0, false
+}
+
+// incrementBounds returns the filter matching the values of the field at
+// the path which stay within low and high when delta is added.
+func incrementBounds(path string, delta, low, high int64) bson.D {
+	var bounds bson.D
+	switch {
+	case delta > 0:
+		bounds = bson.D{bson.E{Key: "$lte", Value: high - delta}}
+	case delta < 0:
+		bounds = bson.D{bson.E{Key: "$gte", Value: low - delta}}
+	default:
+		return nil
+	}
+	if delta < low || delta > high {
+		return bson.D{bson.E{Key: path, Value: bounds}}
+	}
+	// an unset field starts at zero, which stays within the range
+	return bson.D{bson.E{Key: "$or", Value: bson.A{
+		bson.D{bson.E{Key: path, Value: bounds}},
+		bson.D{bson.E{Key: path, Value: bson.D{bson.E{Key: "$exists", Value: false}}}},
+	}}}
+}
+
+func isNumeric(fd protoreflect.FieldDescriptor) bool {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind,
+		protoreflect.FloatKind, protoreflect.DoubleKind:
+		return true
+	}
+	return false
+}
+
+// PushToList appends the values to the repeated field of the document
+// with the given id, without reading it first. The values have to be of
+// the Go type of the elements, e.g. *Person_PhoneNumber for a repeated
+// message field or string for a repeated string field.
+func (p *BoundProtoStore) PushToList(model func() protoreflect.ProtoMessage, id, fieldPath string, values ...interface{}) (err error) {
+	p, done := p.operation("PushToList", model)
+	defer done(&err)
+	return p.updateList(model, id, fieldPath, "$push", values)
+}
+
+// AddToSet works like PushToList, but skips the values that are already
+// in the list.
+func (p *BoundProtoStore) AddToSet(model func() protoreflect.ProtoMessage, id, fieldPath string, values ...interface{}) (err error) {
+	p, done := p.operation("AddToSet", model)
+	defer done(&err)
+	return p.updateList(model, id, fieldPath, "$addToSet", values)
+}
+
+// PullFromList removes all elements equal to one of the values from the
+// repeated field of the document with the given id.
+func (p *BoundProtoStore) PullFromList(model func() protoreflect.ProtoMessage, id, fieldPath string, values ...interface{}) (err error) {
+	p, done := p.operation("PullFromList", model)
+	defer done(&err)
+	return p.updateList(model, id, fieldPath, "$pull", values)
+}
+
+func (p *BoundProtoStore) updateList(model func() protoreflect.ProtoMessage, id, fieldPath, operator string, values []interface{}) error {
+	if len(values) == 0 {
+		return fmt.Errorf("no values given for %s on field %s", operator, fieldPath)
+	}
+	elems, path, err := p.listValues(model, fieldPath, values)
+	if err != nil {
+		return err
+	}
+
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	var update bson.D
+	if operator == "$pull" {
+		// the elements have to equal one of the values as a whole, like
+		// with $in, not only match them field by field
+		update = bson.D{bson.E{Key: "$pullAll", Value: bson.D{bson.E{Key: path, Value: elems}}}}
+	} else {
+		update = bson.D{bson.E{Key: operator, Value: bson.D{bson.E{Key: path, Value: bson.D{bson.E{Key: "$each", Value: elems}}}}}}
+	}
+	update = append(update, touchUpdatedAt)
+	res, err := coll.UpdateOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}, notDeleted}, update)
+	p.invalidate(protoreflect.FullName(coll.Name()), id)
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not %s values of field %s of document %s in collection %s: %w", operator, path, id, coll.Name(), writeError(err))
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
+
+// listValues converts the values into the elements of the repeated field
+// at the path, as they are stored. To get exactly the representation
+// Store writes, they are set on an otherwise empty message of the model,
+// which is then converted like in Store. It also returns the path of the
+// field within the documents.
+func (p *BoundProtoStore) listValues(model func() protoreflect.ProtoMessage, fieldPath string, values []interface{}) (bson.A, string, error) {
+	m := model()
+	md := m.ProtoReflect().Descriptor()
+	fields, err := resolvePath(md, fieldPath)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, fd := range fields[:len(fields)-1] {
+		if fd.IsList() || fd.IsMap() {
+			return nil, "", fmt.Errorf("path %s of %s descends into the repeated field %s", fieldPath, md.FullName(), fd.Name())
+		}
+	}
+	fd := fields[len(fields)-1]
+	if !fd.IsList() {
+		return nil, "", fmt.Errorf("field %s of %s is not repeated", fieldPath, md.FullName())
+	}
+
+	msg := m.ProtoReflect()
+	for _, parent := range fields[:len(fields)-1] {
+		msg = msg.Mutable(parent).Message()
+	}
+	list := msg.Mutable(fd).List()
+	for _, value := range values {
+		v, err := protoValue(fd, value)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid value for field %s of %s: %w", fieldPath, md.FullName(), err)
+		}
+		list.Append(v)
+	}
+
+	// only the values are kept, so the document is reused
+	doc := getDoc()
+	defer putDoc(doc)
+	if err := p.protoStore.settings.toMapInto(m, doc); err != nil {
+		return nil, "", err
+	}
+	path := jsonPath(fields)
+	elems, _ := lookupPath(doc, path)
+	converted, ok := asList(elems)
+	if !ok || len(converted) != len(values) {
+		return nil, "", fmt.Errorf("could not convert the values for field %s of %s", fieldPath, md.FullName())
+	}
+	for i, elem := range converted {
+		converted[i] = orderedValue(fd, elem)
+	}
+	return bson.A(converted), path, nil
+}
+
+// protoValue converts a Go value into the value of a singular or an
+// element of a repeated field. The Go type has to match the kind of the
+// field.
+func protoValue(fd protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	mismatch := fmt.Errorf("%T does not match the kind %s of field %s", value, fd.Kind(), fd.FullName())
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m, ok := value.(protoreflect.ProtoMessage)
+		if !ok || m.ProtoReflect().Descriptor().FullName() != fd.Message().FullName() {
+			return protoreflect.Value{}, mismatch
+		}
+		return protoreflect.ValueOfMessage(m.ProtoReflect()), nil
+	case protoreflect.EnumKind:
+		switch e := value.(type) {
+		case protoreflect.Enum:
+			if e.Descriptor().FullName() != fd.Enum().FullName() {
+				return protoreflect.Value{}, mismatch
+			}
+			return protoreflect.ValueOfEnum(e.Number()), nil
+		case protoreflect.EnumNumber:
+			return protoreflect.ValueOfEnum(e), nil
+		}
+		return protoreflect.Value{}, mismatch
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		switch n := value.(type) {
+		case int32:
+			return protoreflect.ValueOfInt32(n), nil
+		case int:
+			if int(int32(n)) == n {
+				return protoreflect.ValueOfInt32(int32(n)), nil
+			}
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		switch n := value.(type) {
+		case int64:
+			return protoreflect.ValueOfInt64(n), nil
+		case int:
+			return protoreflect.ValueOfInt64(int64(n)), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if n, ok := value.(uint32); ok {
+			return protoreflect.ValueOfUint32(n), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n, ok := value.(uint64); ok {
+			return protoreflect.ValueOfUint64(n), nil
+		}
+	case protoreflect.FloatKind:
+		if f, ok := value.(float32); ok {
+			return protoreflect.ValueOfFloat32(f), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := value.(float64); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.BoolKind:
+		if b, ok := value.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.StringKind:
+		if s, ok := value.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		if b, ok := value.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	}
+	return protoreflect.Value{}, mismatch
+}
+
+// FindAndUpdate atomically applies the update to a document matching the
+// filter and returns it, by default as it was before the update. This
+// makes "claim the next job" semantics possible. If no document matches
+// and no upsert was requested, an error wrapping ErrNotFound is returned.
+// Like the other writes, it sets the updatedAt metadata, and an upsert
+// inserts the metadata of a new document, including a new id.
+func (p *BoundProtoStore) FindAndUpdate(model func() protoreflect.ProtoMessage, filter bson.D, update bson.D, opts ...FindAndUpdateOption) (_ protoreflect.ProtoMessage, err error) {
+	p, done := p.operation("FindAndUpdate", model)
+	defer done(&err)
+	cfg := findAndUpdateConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	coll, err := p.collection(model)
+	if err != nil {
+		return nil, err
+	}
+	table := model().ProtoReflect().Descriptor().FullName()
+
+	findOpts := options.FindOneAndUpdate().SetUpsert(cfg.upsert)
+	if cfg.returnAfter {
+		findOpts.SetReturnDocument(options.After)
+	}
+	if cfg.sort != nil {
+		findOpts.SetSort(cfg.sort)
+	}
+	if collation := p.collation([]bson.D{filter}); collation != nil {
+		findOpts.SetCollation(collation)
+	}
+	update, err = withOperatorFields(update, "$set", bson.D{bson.E{Key: fieldUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now())}})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.upsert {
+		metadata := bson.D{
+			bson.E{Key: fieldType, Value: p.protoStore.settings.typeValue(table)},
+			bson.E{Key: fieldCreatedBy, Value: p.user.ID},
+			bson.E{Key: fieldCreatedAt, Value: primitive.NewDateTimeFromTime(time.Now())},
+		}
+		// otherwise, the database generates an ObjectID, which the id
+		// codec may not read. An _id of the filter is inserted instead.
+		if !hasKey(filter, fieldID) {
+			docID, err := p.protoStore.settings.encodeID(string(table), p.protoStore.settings.newID())
+			if err != nil {
+				return nil, err
+			}
+			metadata = append(metadata, bson.E{Key: fieldID, Value: docID})
+		}
+		if update, err = withOperatorFields(update, "$setOnInsert", metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	combined, err := p.queryFilter(model().ProtoReflect().Descriptor(), []bson.D{filter})
+	if err != nil {
+		return nil, err
+	}
+	translated, err := p.protoStore.settings.translateFilter(update)
+	if err != nil {
+		return nil, fmt.Errorf("could not translate update %v: %w", update, err)
+	}
+	update = translated.(bson.D)
+	var doc bson.M
+	err = coll.FindOneAndUpdate(p.ctx, combined, update, findOpts).Decode(&doc)
+	switch {
+	case err == nil:
+		p.invalidateDocs(table, doc[fieldID])
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		// which document was updated is unknown
+		p.invalidateCollection(table)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("no document in collection %s matches %v: %w", coll.Name(), combined, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find and update a document in collection %s with filter %v: %w", coll.Name(), combined, err)
+	}
+	m, err := p.protoStore.settings.fromDoc(model, doc)
+	if err != nil {
+		return nil, err
+	}
+	return m.Message, nil
+}
+
+// withOperatorFields adds the fields to the operator of the update, e.g.
+// the metadata of the store to $setOnInsert. The update is not changed.
+func withOperatorFields(update bson.D, operator string, fields bson.D) (bson.D, error) {
+	res := make(bson.D, 0, len(update)+1)
+	found := false
+	for _, e := range update {
+		if e.Key == operator {
+			switch existing := e.Value.(type) {
+			case bson.D:
+				e.Value = append(existing[:len(existing):len(existing)], fields...)
+			case bson.M:
+				e.Value = withMapFields(existing, fields)
+			case map[string]interface{}:
+				e.Value = withMapFields(existing, fields)
+			default:
+				return nil, fmt.Errorf("could not add %v to %s of the update, which is a %T instead of a bson.D", fields, operator, e.Value)
+			}
+			found = true
+		}
+		res = append(res, e)
+	}
+	if !found {
+		res = append(res, bson.E{Key: operator, Value: fields})
+	}
+	return res, nil
+}
+
+// withMapFields returns a copy of the map with the fields added.
+func withMapFields(m map[string]interface{}, fields bson.D) bson.M {
+	res := make(bson.M, len(m)+len(fields))
+	for key, value := range m {
+		res[key] = value
+	}
+	for _, e := range fields {
+		res[e.Key] = e.Value
+	}
+	return res
+}
+
+// hasKey tells whether the filter has the key at its top level.
+func hasKey(filter bson.D, key string) bool {
+	for _, e := range filter {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// touchUpdatedAt sets the updatedAt metadata to the time of the server.
+var touchUpdatedAt = bson.E{Key: "$currentDate", Value: bson.D{bson.E{Key: fieldUpdatedAt, Value: bson.D{bson.E{Key: "$type", Value: "date"}}}}}
+
+// Touch sets the updatedAt metadata of the document with the given id to
+// now, without reading or changing its fields, e.g. to invalidate caches
+// keyed by it. If there is no such document, an error wrapping
+// ErrNotFound is returned.
+func (p *BoundProtoStore) Touch(model func() protoreflect.ProtoMessage, id string) (err error) {
+	p, done := p.operation("Touch", model)
+	defer done(&err)
+	coll, err := p.collection(model)
+	if err != nil {
+		return err
+	}
+	docID, err := p.protoStore.settings.encodeID(coll.Name(), id)
+	if err != nil {
+		return err
+	}
+	res, err := coll.UpdateOne(p.ctx, bson.D{bson.E{Key: fieldID, Value: docID}, notDeleted}, bson.D{touchUpdatedAt})
+	if errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not touch document %s in collection %s: %w", id, coll.Name(), writeError(err))
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no document with id %s in collection %s: %w", id, coll.Name(), ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestIncrement(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"int32Value": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		field string
+		delta int64
+		want  int64
+	}{
+		{"int32_value", 3, 8},
+		{"int32_value", -10, -2},
+		{"int64Value", math.MaxInt32 + 1, math.MaxInt32 + 1},
+		{"uint32_value", 7, 7},
+		{"main_item.quantity", 2, 2},
+	} {
+		got, err := bound.Increment(sample, id, c.field, c.delta)
+		if err != nil {
+			t.Fatalf("increment of %s by %d: %v", c.field, c.delta, err)
+		}
+		if got != c.want {
+			t.Errorf("increment of %s by %d returned %d, want %d", c.field, c.delta, got, c.want)
+		}
+	}
+	stored, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, stored, newSample(t, `{"id": "`+id+`", "int32Value": -2, "int64Value": "2147483648", "uint32Value": 7, "mainItem": {"quantity": 2}}`))
+}
+
+func TestIncrementOutOfRange(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"int32Value": 2147483640, "uint32Value": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		field string
+		delta int64
+	}{
+		{"int32_value", 8},
+		{"uint32_value", -2},
+		{"sint32_value", math.MaxInt32 + 1},
+		{"uint64_value", -1},
+		{"uint64_value", math.MinInt64},
+	} {
+		if _, err := bound.Increment(sample, id, c.field, c.delta); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("increment of %s by %d returned %v, want ErrOutOfRange", c.field, c.delta, err)
+		}
+	}
+	stored, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, stored, newSample(t, `{"id": "`+id+`", "int32Value": 2147483640, "uint32Value": 1}`))
+}
+
+func TestIncrementInvalidField(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"double_value", "float_value", "string_value", "numbers", "counts"} {
+		_, err := bound.Increment(sample, id, field, 1)
+		var kindErr *FieldKindError
+		if !errors.As(err, &kindErr) || !errors.Is(err, ErrInvalidFieldKind) {
+			t.Errorf("increment of %s returned %v, want a *FieldKindError", field, err)
+		}
+	}
+	if _, err := bound.Increment(sample, id, "unknown", 1); err == nil {
+		t.Error("increment of an unknown field succeeded")
+	}
+}
+
+func TestIncrementNotFound(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.Delete(sample, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.Increment(sample, id, "int32_value", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("increment of a missing document returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestFindAndUpdateUpsert(t *testing.T) {
+	_, bound := newTestStore(t, WithIDCodec(UUIDStringCodec{}))
+	upserted, err := bound.FindAndUpdate(sample, Eq("stringValue", "job"),
+		bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "stringValue", Value: "job"}, bson.E{Key: "int32Value", Value: 1}}}},
+		WithUpsert(), ReturnAfter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := upserted.ProtoReflect().Get(upserted.ProtoReflect().Descriptor().Fields().ByName("id")).String()
+	if _, err := uuid.FromString(id); err != nil {
+		t.Errorf("upsert inserted the id %q, which is no uuid: %v", id, err)
+	}
+	assertEqual(t, upserted, newSample(t, `{"id": "`+id+`", "stringValue": "job", "int32Value": 1}`))
+	doc := rawDoc(t, bound, sample, id)
+	for _, field := range []string{fieldType, fieldCreatedBy, fieldCreatedAt, fieldUpdatedAt} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("upsert did not insert %s", field)
+		}
+	}
+}
+
+func TestFindAndUpdateMetadataAndCache(t *testing.T) {
+	_, bound := newTestStore(t, WithCache(10, time.Minute))
+	id, _, err := bound.Store(newSample(t, `{"stringValue": "job", "int32Value": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rawDoc(t, bound, sample, id)[fieldUpdatedAt].(primitive.DateTime)
+	// the Get caches the document
+	if _, err := bound.Get(sample, id); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	update := bson.D{bson.E{Key: "$set", Value: bson.M{"int32Value": 2}}}
+	old, err := bound.FindAndUpdate(sample, Eq("stringValue", "job"), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, old, newSample(t, `{"id": "`+id+`", "stringValue": "job", "int32Value": 1}`))
+	if after := rawDoc(t, bound, sample, id)[fieldUpdatedAt].(primitive.DateTime); after <= before {
+		t.Errorf("update kept %s at %v", fieldUpdatedAt, after.Time())
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", "stringValue": "job", "int32Value": 2}`))
+
+	if _, err := bound.FindAndUpdate(sample, Eq("stringValue", "none"), update); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update without a match returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"stringValue": "a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rawDoc(t, bound, sample, id)
+	time.Sleep(10 * time.Millisecond)
+	if err := bound.Touch(sample, id); err != nil {
+		t.Fatal(err)
+	}
+	after := rawDoc(t, bound, sample, id)
+	if !after[fieldUpdatedAt].(primitive.DateTime).Time().After(before[fieldUpdatedAt].(primitive.DateTime).Time()) {
+		t.Errorf("touch left %s at %v", fieldUpdatedAt, after[fieldUpdatedAt])
+	}
+	if after[fieldCreatedAt] != before[fieldCreatedAt] || after["stringValue"] != "a" {
+		t.Errorf("touch changed the document from %v to %v", before, after)
+	}
+
+	if err := bound.Touch(sample, primitive.NewObjectID().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("touch of a missing document returned %v, want ErrNotFound", err)
+	}
+	if err := bound.SoftDelete(sample, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.Touch(sample, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("touch of a deleted document returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdateFields(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"stringValue": "kept", "int32Value": 1, "mainItem": {"name": "old", "quantity": 2}, "tags": ["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the message only carries what the client sent
+	patch := newSample(t, `{"id": "`+id+`", "int32Value": 5, "mainItem": {"name": "new"}, "stringValue": "ignored"}`)
+	if err := bound.UpdateFields(patch, &fieldmaskpb.FieldMask{Paths: []string{"int32_value", "main_item.name", "tags"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", "stringValue": "kept", "int32Value": 5, "mainItem": {"name": "new", "quantity": 2}}`))
+}
+
+func TestUpdateFieldsOneof(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"number": "5"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		json  string
+		paths []string
+	}{
+		{`{"text": "hi"}`, []string{"text"}},
+		// both members named, the one which is set wins
+		{`{"item": {"name": "x"}}`, []string{"text", "item"}},
+	} {
+		patch := newSample(t, `{"id": "`+id+`", `+c.json[1:])
+		if err := bound.UpdateFields(patch, &fieldmaskpb.FieldMask{Paths: c.paths}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bound.Get(sample, id)
+		if err != nil {
+			t.Fatalf("could not read the oneof after updating %v: %v", c.paths, err)
+		}
+		assertEqual(t, got, patch)
+	}
+}
+
+func TestUpdateFieldsInvalidMask(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"stringValue": "kept"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := newSample(t, `{"id": "`+id+`", "stringValue": "changed"}`)
+	for _, paths := range [][]string{
+		nil,
+		{"unknown"},
+		{"string_value", "main_item.unknown"},
+		{"items.name"},
+		{"item_map.name"},
+		{"string_value.length"},
+	} {
+		if err := bound.UpdateFields(patch, &fieldmaskpb.FieldMask{Paths: paths}); err == nil {
+			t.Errorf("updated with the mask %v", paths)
+		}
+	}
+	if err := bound.UpdateFields(patch, nil); err == nil {
+		t.Error("updated without a mask")
+	}
+	if err := bound.UpdateFields(patch, &fieldmaskpb.FieldMask{Paths: []string{"items.name"}}); err == nil || !strings.Contains(err.Error(), "repeated field items") {
+		t.Errorf("a path into a repeated field returned %v, want an error naming the field", err)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", "stringValue": "kept"}`))
+
+	missing := newSample(t, `{"id": "`+primitive.NewObjectID().Hex()+`"}`)
+	if err := bound.UpdateFields(missing, &fieldmaskpb.FieldMask{Paths: []string{"string_value"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updating a missing document returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestListUpdates(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := &Person_PhoneNumber{Number: "1", Type: Person_HOME}
+	work := &Person_PhoneNumber{Number: "2", Type: Person_WORK}
+	assertPhones := func(want ...*Person_PhoneNumber) {
+		t.Helper()
+		found, err := bound.Filter(person, Eq("name", "Ada"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 {
+			t.Fatalf("found %d persons, want 1", len(found))
+		}
+		assertEqual(t, found[0], &Person{Id: id, Name: "Ada", Phones: want})
+	}
+
+	if err := bound.PushToList(person, id, "phones", home, work); err != nil {
+		t.Fatal(err)
+	}
+	assertPhones(home, work)
+	if err := bound.PushToList(person, id, "phones", home); err != nil {
+		t.Fatal(err)
+	}
+	assertPhones(home, work, home)
+	if err := bound.PullFromList(person, id, "phones", home); err != nil {
+		t.Fatal(err)
+	}
+	assertPhones(work)
+	if err := bound.AddToSet(person, id, "phones", work, home); err != nil {
+		t.Fatal(err)
+	}
+	assertPhones(work, home)
+}
+
+func TestListUpdatesOfScalars(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"tags": ["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.AddToSet(sample, id, "tags", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.PushToList(sample, id, "numbers", int64(1)<<40, int64(-1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bound.PullFromList(sample, id, "numbers", int64(-1)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bound.Get(sample, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, got, newSample(t, `{"id": "`+id+`", "tags": ["a", "b"], "numbers": ["1099511627776"]}`))
+}
+
+func TestListUpdatesInvalid(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		field  string
+		values []interface{}
+	}{
+		{"name", []interface{}{"x"}},
+		{"unknown", []interface{}{"x"}},
+		{"phones", []interface{}{"not a phone number"}},
+		{"phones", []interface{}{&Person{}}},
+		{"phones", nil},
+	} {
+		if err := bound.PushToList(person, id, c.field, c.values...); err == nil {
+			t.Errorf("pushed %v to %s", c.values, c.field)
+		}
+	}
+	err = bound.PushToList(person, primitive.NewObjectID().Hex(), "phones", &Person_PhoneNumber{Number: "1"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("pushing to a missing document returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateFilter checks that every field the filter references exists on
+// the message, so a typo fails with ErrUnknownField instead of matching
+// nothing. The metadata fields of the store are allowed as well.
+func validateFilter(md protoreflect.MessageDescriptor, filter interface{}) error {
+	elems, ok := filterElems(filter)
+	if !ok {
+		return nil
+	}
+	for _, e := range elems {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			list, ok := asFilterList(e.Value)
+			if !ok {
+				continue
+			}
+			for _, sub := range list {
+				if err := validateFilter(md, sub); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Key, "$") {
+			// e.g. $expr or $text, which do not reference fields directly
+			continue
+		}
+		fd, err := validatePath(md, e.Key)
+		if err != nil {
+			return err
+		}
+		if fd == nil {
+			continue
+		}
+		operators, _ := filterElems(e.Value)
+		for _, op := range operators {
+			if op.Key != "$elemMatch" {
+				continue
+			}
+			if !fd.IsList() {
+				return fmt.Errorf("$elemMatch on field %s of %s, which is not repeated: %w", e.Key, md.FullName(), ErrUnknownField)
+			}
+			if isMessage(fd) {
+				if err := validateFilter(fd.Message(), op.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validatePath resolves the dot-separated path of a filter, which uses
+// the json names of the fields as they are stored. Segments may also be
+// indexes into lists and keys of maps. It returns the descriptor of the
+// last field, which is nil for metadata fields.
+func validatePath(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	if isMetadataField(path) {
+		return nil, nil
+	}
+	current := md
+	var last protoreflect.FieldDescriptor
+	for _, segment := range strings.Split(path, ".") {
+		switch {
+		case last != nil && last.IsMap():
+			// the segment is a key of the map, which protojson writes as
+			// a string, e.g. "42" or "true"
+			if !validMapKey(last.MapKey().Kind(), segment) {
+				return nil, fmt.Errorf("field path %s of message %s has key %q of map %s, which is no %s: %w", path, md.FullName(), segment, last.Name(), last.MapKey().Kind(), ErrUnknownField)
+			}
+			last = last.MapValue()
+			current = nil
+			if isMessage(last) {
+				current = last.Message()
+			}
+			continue
+		case last != nil && last.IsList() && isIndex(segment):
+			continue
+		case current == nil:
+			return nil, fmt.Errorf("field path %s of message %s descends into %s, which is no message: %w", path, md.FullName(), last.Name(), ErrUnknownField)
+		}
+		fd := current.Fields().ByJSONName(segment)
+		if fd == nil {
+			return nil, fmt.Errorf("message %s has no field %s of path %s%s: %w", current.FullName(), segment, path, suggestFields(current, segment), ErrUnknownField)
+		}
+		last = fd
+		current = nil
+		if isMessage(fd) && !fd.IsMap() {
+			current = fd.Message()
+		}
+	}
+	return last, nil
+}
+
+// validMapKey tells whether the key of a path can be a key of a map with
+// keys of the kind.
+func validMapKey(kind protoreflect.Kind, key string) bool {
+	var err error
+	switch kind {
+	case protoreflect.BoolKind:
+		return key == "true" || key == "false"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		_, err = strconv.ParseInt(key, 10, 32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		_, err = strconv.ParseInt(key, 10, 64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		_, err = strconv.ParseUint(key, 10, 32)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		_, err = strconv.ParseUint(key, 10, 64)
+	}
+	return err == nil
+}
+
+func isIndex(segment string) bool {
+	_, err := strconv.ParseUint(segment, 10, 32)
+	return err == nil
+}
+
+// suggestFields names the fields of the message the misspelled name was
+// probably meant to be, in a form to be appended to an error message.
+func suggestFields(md protoreflect.MessageDescriptor, name string) string {
+	var candidates []string
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// filters have to use the json name, a proto name like
+		// phone_number matches nothing
+		if string(fd.Name()) == name || strings.EqualFold(fd.JSONName(), name) || editDistance(fd.JSONName(), name) <= 2 {
+			candidates = append(candidates, fd.JSONName())
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(candidates, " or "))
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// filterElems returns the elements of a filter document.
+func filterElems(filter interface{}) ([]bson.E, bool) {
+	switch f := filter.(type) {
+	case bson.D:
+		return f, true
+	case bson.M:
+		elems := make([]bson.E, 0, len(f))
+		for key, value := range f {
+			elems = append(elems, bson.E{Key: key, Value: value})
+		}
+		return elems, true
+	}
+	return nil, false
+}
+
+// asFilterList returns the filters of a logical operator like $and.
+func asFilterList(value interface{}) ([]interface{}, bool) {
+	switch l := value.(type) {
+	case []bson.D:
+		list := make([]interface{}, len(l))
+		for i, f := range l {
+			list[i] = f
+		}
+		return list, true
+	case bson.A:
+		return l, true
+	case []interface{}:
+		return l, true
+	}
+	return nil, false
+}
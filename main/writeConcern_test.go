@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// commandLog records the commands the client of a store sends.
+type commandLog struct {
+	mu       sync.Mutex
+	commands []bson.Raw
+}
+
+// monitorCommands replaces the client of the store by one which records
+// its commands.
+func monitorCommands(t testing.TB, store *ProtoStore) *commandLog {
+	t.Helper()
+	log := &commandLog{}
+	monitor := &event.CommandMonitor{Started: func(_ context.Context, e *event.CommandStartedEvent) {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		log.commands = append(log.commands, e.Command)
+	}}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(testURI()).SetMonitor(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	store.client = client
+	return log
+}
+
+// writeConcern returns the write concern of the last command with the
+// name, or nil if it was sent without one.
+func (l *commandLog) writeConcern(t testing.TB, name string) bson.Raw {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.commands) - 1; i >= 0; i-- {
+		if _, err := l.commands[i].LookupErr(name); err != nil {
+			continue
+		}
+		wc, err := l.commands[i].LookupErr("writeConcern")
+		if err != nil {
+			return nil
+		}
+		return wc.Document()
+	}
+	t.Fatalf("no command %s was sent", name)
+	return nil
+}
+
+func TestWriteConcernPlumbing(t *testing.T) {
+	store, bound := newTestStore(t, WithWriteConcern(writeconcern.New(writeconcern.W(1), writeconcern.J(true))))
+	log := monitorCommands(t, store)
+
+	id, _, err := bound.Store(&Person{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := log.writeConcern(t, "update")
+	if wc.Lookup("w").Int32() != 1 || !wc.Lookup("j").Boolean() {
+		t.Errorf("Store sent the write concern %v, want the one of the store", wc)
+	}
+
+	majority := bound.With(WriteConcern(writeconcern.New(writeconcern.WMajority())))
+	if _, err := majority.StoreMany([]protoreflect.ProtoMessage{&Person{Name: "Grace"}}); err != nil {
+		t.Fatal(err)
+	}
+	wc = log.writeConcern(t, "update")
+	if wc.Lookup("w").StringValue() != "majority" {
+		t.Errorf("StoreMany sent the write concern %v, want the one of the call", wc)
+	}
+	if err := majority.Delete(person, id); err != nil {
+		t.Fatal(err)
+	}
+	wc = log.writeConcern(t, "delete")
+	if wc.Lookup("w").StringValue() != "majority" {
+		t.Errorf("Delete sent the write concern %v, want the one of the call", wc)
+	}
+}
+
+func TestUnacknowledgedWrites(t *testing.T) {
+	_, bound := newTestStore(t)
+	id, _, err := bound.Store(newSample(t, `{"tags": ["a"], "int32Value": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := primitive.NewObjectID().Hex()
+	unacknowledged := bound.With(WriteConcern(writeconcern.New(writeconcern.W(0))))
+	for name, write := range map[string]func(id string) error{
+		"UpdateFields": func(id string) error {
+			mask := &fieldmaskpb.FieldMask{Paths: []string{"string_value"}}
+			return unacknowledged.UpdateFields(newSample(t, `{"id": "`+id+`", "stringValue": "b"}`), mask)
+		},
+		"Increment": func(id string) error {
+			n, err := unacknowledged.Increment(sample, id, "int32_value", 1)
+			if n != 0 {
+				t.Errorf("unacknowledged increment returned %d, want 0", n)
+			}
+			return err
+		},
+		"Touch":        func(id string) error { return unacknowledged.Touch(sample, id) },
+		"PushToList":   func(id string) error { return unacknowledged.PushToList(sample, id, "tags", "b") },
+		"PullFromList": func(id string) error { return unacknowledged.PullFromList(sample, id, "tags", "b") },
+		"SoftDelete":   func(id string) error { return unacknowledged.SoftDelete(sample, id) },
+		"Restore":      func(id string) error { return unacknowledged.Restore(sample, id) },
+		"Delete":       func(id string) error { return unacknowledged.Delete(sample, id) },
+	} {
+		for _, id := range []string{id, missing} {
+			if err := write(id); err != nil {
+				t.Errorf("unacknowledged %s of %s returned %v, want nil", name, id, err)
+			}
+		}
+	}
+}
+
+func TestWriteErrorMarksWriteConcernTimeouts(t *testing.T) {
+	timeout := &mongo.WriteConcernError{Code: writeConcernFailedCode, Message: "waiting for replication timed out"}
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{mongo.WriteException{WriteConcernError: timeout}, true},
+		{mongo.BulkWriteException{WriteConcernError: timeout}, true},
+		{mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 100}}, false},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: duplicateKeyCode}}}, false},
+		{context.DeadlineExceeded, false},
+	} {
+		err := writeError(c.err)
+		if got := errors.Is(err, ErrWriteConcernTimeout); got != c.want {
+			t.Errorf("writeError(%v) is ErrWriteConcernTimeout: %t, want %t", c.err, got, c.want)
+		}
+	}
+}
+
+// count returns how many commands with the name were sent.
+func (l *commandLog) count(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, command := range l.commands {
+		if _, err := command.LookupErr(name); err == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -1 +1,4 @@
 protoc --go_out=. *.proto
+protoc --include_imports -o model.pb *.proto
+go run ./cmd/protofields -descriptor_set model.pb -out main/modelFields.go
+rm model.pb